@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ADDRESS_HASH_SIZE     = 20 //公钥哈希值长度
+	ADDRESS_CHECKSUM_SIZE = 4
+	ADDRESS_SIZE          = 1 /*version*/ + ADDRESS_HASH_SIZE + ADDRESS_CHECKSUM_SIZE
+)
+
+var ErrAddressChecksum = errors.New("地址校验和错误，请检查是否输入有误")
+
+//由公钥计算当前网络的地址：Base58Check(版本 + SHA256(公钥)的前20字节)
+//公钥为带算法前缀的字符串，不同算法的公钥地址不同
+func NewAddress(public []byte) []byte {
+	return EncodeAddress(chainParams.AddressVersion, SHA256(public)[:ADDRESS_HASH_SIZE])
+}
+
+//编码地址：Base58(版本(1字节) + 公钥哈希值(20字节) + 校验和(4字节))，校验和为前21字节两次SHA256的前4字节
+func EncodeAddress(version byte, hash []byte) []byte {
+	d := append([]byte{version}, FitBytesInto(hash, ADDRESS_HASH_SIZE)...)
+	return encodeBase58(append(d, addressChecksum(d)...))
+}
+
+//解码地址，返回版本和公钥哈希值，不检查版本
+func DecodeAddress(addr []byte) (byte, []byte, error) {
+	if bytes.IndexByte(addr, SCHEME_SEPARATOR) >= 0 {
+		return 0, nil, errors.New("地址格式错误")
+	}
+	d, err := decodeBase58(addr)
+	if err != nil {
+		return 0, nil, errors.New("地址格式错误")
+	}
+	if len(d) != ADDRESS_SIZE {
+		return 0, nil, errors.New("地址长度错误")
+	}
+	body := d[:ADDRESS_SIZE-ADDRESS_CHECKSUM_SIZE]
+	if !bytes.Equal(addressChecksum(body), d[len(body):]) {
+		return 0, nil, ErrAddressChecksum
+	}
+	return body[0], body[1:], nil
+}
+
+//检查地址的格式、校验和以及是否属于当前网络
+func ValidateAddress(addr []byte) error {
+	version, _, err := DecodeAddress(addr)
+	if err != nil {
+		return err
+	}
+	if version != chainParams.AddressVersion {
+		return fmt.Errorf("地址不属于%s网络", chainParams.Name)
+	}
+	return nil
+}
+
+//是否为当前网络的有效地址
+func IsAddress(key []byte) bool {
+	return ValidateAddress(key) == nil
+}
+
+//账户标识：当前网络的地址不变，其他数据看作公钥，返回公钥的地址，空数据返回空字符串
+//账本和公钥索引按账户标识记录，转账给公钥和转账给它的地址进入同一账户
+func AccountOf(key []byte) string {
+	if len(key) == 0 {
+		return ""
+	}
+	if IsAddress(key) {
+		return string(key)
+	}
+	return string(NewAddress(key))
+}
+
+//解析转账的接收方：当前网络的地址、公钥、多重签名公钥或锁定脚本
+//没有算法前缀且长度与地址相近时按地址检查，防止输错的地址被当作公钥
+func ParseRecipient(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("接收方不能为空")
+	}
+	if IsScript([]byte(s)) {
+		if _, err := ParseScriptKey([]byte(s)); err != nil {
+			return nil, errors.New("接收方不是有效的锁定脚本：" + err.Error())
+		}
+		return []byte(s), nil
+	}
+	if IsMultisig([]byte(s)) {
+		if _, err := ParseMultisigKey([]byte(s)); err != nil {
+			return nil, errors.New("接收方不是有效的多重签名公钥：" + err.Error())
+		}
+		return []byte(s), nil
+	}
+	if _, _, err := decodeSchemeData([]byte(s)); err != nil {
+		return nil, errors.New("接收方不是有效的地址或公钥：" + err.Error())
+	}
+	if strings.IndexByte(s, SCHEME_SEPARATOR) < 0 && len(s) < 2*KEY_SIZE {
+		if err := ValidateAddress([]byte(s)); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(s), nil
+}
+
+func addressChecksum(d []byte) []byte {
+	return SHA256(SHA256(d))[:ADDRESS_CHECKSUM_SIZE]
+}
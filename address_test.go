@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	kp, _ := GenerateKeypair(SCHEME_ED25519)
+	addr := NewAddress(kp.Public)
+	if len(addr) != 34 || addr[0] != 'Y' || ValidateAddress(addr) != nil {
+		t.Fatal("主网地址错误", string(addr))
+	}
+	version, hash, err := DecodeAddress(addr)
+	if err != nil || version != MainNetParams.AddressVersion || !bytes.Equal(hash, SHA256(kp.Public)[:ADDRESS_HASH_SIZE]) {
+		t.Error("解码地址错误", err)
+	}
+	//开头为0的哈希值编码后长度不变
+	if zero := EncodeAddress(0, make([]byte, ADDRESS_HASH_SIZE)); ValidateAddress(zero) == nil {
+		t.Error("版本不同的地址不应有效")
+	} else if v, h, err := DecodeAddress(zero); err != nil || v != 0 || !bytes.Equal(h, make([]byte, ADDRESS_HASH_SIZE)) {
+		t.Error("0哈希值的地址解码错误", err)
+	}
+
+	//任意一个字符输错都能发现
+	s := string(addr)
+	for i := range s {
+		for _, c := range []string{"2", "3"} {
+			typo := s[:i] + c + s[i+1:]
+			if typo != s && ValidateAddress([]byte(typo)) == nil {
+				t.Error("输错的地址应无效", typo)
+			}
+		}
+	}
+	if err := ValidateAddress(addr[:len(addr)-1]); err == nil {
+		t.Error("长度错误的地址应无效")
+	}
+
+	//其他网络的地址
+	withParams(t, &TestNetParams)
+	if testnet := NewAddress(kp.Public); testnet[0] != 't' || ValidateAddress(testnet) != nil {
+		t.Error("测试网地址错误", string(testnet))
+	}
+	if ValidateAddress(addr) == nil {
+		t.Error("主网地址不应在测试网有效")
+	}
+}
+
+func TestAccountOf(t *testing.T) {
+	kp := GenerateNewKeypair()
+	addr := NewAddress(kp.Public)
+	if AccountOf(kp.Public) != string(addr) || AccountOf(addr) != string(addr) || AccountOf(nil) != "" {
+		t.Error("公钥和它的地址应为同一账户")
+	}
+
+	if to, err := ParseRecipient(" " + string(addr) + " "); err != nil || !bytes.Equal(to, addr) {
+		t.Error("解析地址失败", err)
+	}
+	if _, err := ParseRecipient(string(kp.Public)); err != nil {
+		t.Error("解析公钥失败", err)
+	}
+	legacy, _ := legacyP224Scheme{}.Public([]byte{1})
+	if _, err := ParseRecipient(string(encodeBase58(legacy))); err != nil {
+		t.Error("解析旧版公钥失败", err)
+	}
+	for _, bad := range []string{"", "bob", string(addr[1:]), "p224:0OIl", "rsa:abc"} {
+		if _, err := ParseRecipient(bad); err == nil {
+			t.Error("接收方应无效", bad)
+		}
+	}
+}
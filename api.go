@@ -0,0 +1,466 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//HTTP JSON接口，供其他服务查询节点和提交交易：
+//GET /tip 主链最新区块，GET /block/<哈希值或高度> 区块，GET /tx/<哈希值> 主链或交易池中的交易，
+//POST /tx 提交交易，请求为{"raw": 序列化交易的十六进制}，POST /send 使用节点的密钥创建交易，
+//POST /multisig 创建未签名的多重签名转账交易，POST /script 创建未签名的脚本转账交易，
+//GET /peers 已连接的节点，GET /mempool 交易池中的交易(按优先级排序)，GET /mining 挖矿状态
+//所有请求都须带有令牌，allowSend为假时/send被禁用
+func NewAPIHandler(token string, allowSend bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tip", apiGet(handleAPITip))
+	mux.HandleFunc("/block/", apiGet(handleAPIBlock))
+	mux.HandleFunc("/tx", apiPost(handleAPISubmitTransaction))
+	if allowSend {
+		mux.HandleFunc("/send", apiPost(handleAPISend))
+	} else {
+		mux.HandleFunc("/send", func(w http.ResponseWriter, r *http.Request) {
+			writeAPIError(w, apiError{http.StatusForbidden, errors.New("节点未启用/send，启动节点时使用-apisend启用")})
+		})
+	}
+	mux.HandleFunc("/multisig", apiPost(handleAPIUnsigned(func(from []byte) error {
+		_, err := ParseMultisigKey(from)
+		return err
+	})))
+	mux.HandleFunc("/script", apiPost(handleAPIUnsigned(func(from []byte) error {
+		_, err := ParseScriptKey(from)
+		return err
+	})))
+	mux.HandleFunc("/tx/", apiGet(handleAPITransaction))
+	mux.HandleFunc("/peers", apiGet(handleAPIPeers))
+	mux.HandleFunc("/mempool", apiGet(handleAPIMempool))
+	mux.HandleFunc("/mining", apiGet(handleAPIMining))
+	return apiAuth(token, mux)
+}
+
+//在address上启动HTTP JSON接口，令牌写入数据目录下的api.cookie，供本机的客户端读取
+func StartAPI(address, dir string, allowSend bool) {
+	token, err := NewAPIToken(dir)
+	if err != nil {
+		fmt.Println("HTTP接口启动失败：", err)
+		return
+	}
+	fmt.Println("HTTP接口监听：", address)
+	if err := http.ListenAndServe(address, NewAPIHandler(token, allowSend)); err != nil {
+		fmt.Println("HTTP接口启动失败：", err)
+	}
+}
+
+//生成新的接口令牌并写入令牌文件，只有节点的用户可以读取，每次启动节点时更换
+func NewAPIToken(dir string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	dir = getDirectoryWithBaseDir(dir)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", err
+	}
+	file := path.Join(dir, BLOCKCHAIN_API_COOKIE_FILENAME)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(token), 0600); err != nil {
+		return "", err
+	}
+	return token, os.Rename(tmp, file)
+}
+
+//读取节点写入的接口令牌
+func ReadAPIToken(dir string) (string, error) {
+	d, err := os.ReadFile(path.Join(getDirectoryWithBaseDir(dir), BLOCKCHAIN_API_COOKIE_FILENAME))
+	if err != nil {
+		return "", errors.New("读取接口令牌失败，节点是否已启动HTTP接口：" + err.Error())
+	}
+	return strings.TrimSpace(string(d)), nil
+}
+
+//检查请求头部Authorization: Bearer <令牌>，令牌错误时返回401
+func apiAuth(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeAPIError(w, apiError{http.StatusUnauthorized, errors.New("接口令牌错误")})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//接口错误，包含HTTP状态码
+type apiError struct {
+	status int
+	error
+}
+
+//只接受GET请求的处理函数，返回值序列化为JSON
+func apiGet(handle func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, apiError{http.StatusMethodNotAllowed, errors.New("只支持GET请求")})
+			return
+		}
+		v, err := handle(r)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+//只接受JSON格式POST请求的处理函数，成功时返回202，返回值序列化为JSON
+//拒绝其他格式的请求，网页表单无法跨站提交
+func apiPost(handle func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAPIError(w, apiError{http.StatusMethodNotAllowed, errors.New("只支持POST请求")})
+			return
+		}
+		if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+			writeAPIError(w, apiError{http.StatusUnsupportedMediaType, errors.New("请求须为application/json")})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_MESSAGE_SIZE)
+		v, err := handle(r)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, v)
+	}
+}
+
+//解析JSON请求
+func decodeAPIRequest(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return apiError{http.StatusBadRequest, err}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(apiError); ok {
+		status = e.status
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+//交易的JSON格式，公钥为字符串，哈希值和数据为十六进制
+type APITransaction struct {
+	Hash      string      `json:"hash"`
+	From      string      `json:"from"`
+	To        string      `json:"to,omitempty"`
+	Amount    uint64      `json:"amount"`
+	Fee       uint64      `json:"fee"`
+	TimeStamp uint32      `json:"timestamp"`
+	Nonce     uint32      `json:"nonce"`
+	Payload   string      `json:"payload"`
+	Signature string      `json:"signature"`
+	Inputs    []APIInput  `json:"inputs,omitempty"`
+	Outputs   []APIOutput `json:"outputs,omitempty"`
+
+	Block  string `json:"block,omitempty"`  //包含交易的主链区块，未打包时为空
+	Height *int   `json:"height,omitempty"` //包含交易的区块高度
+}
+
+type APIInput struct {
+	Hash  string `json:"hash"`
+	Index uint32 `json:"index"`
+}
+
+type APIOutput struct {
+	Amount uint64 `json:"amount"`
+	To     string `json:"to"`
+}
+
+//区块的JSON格式
+type APIBlock struct {
+	Hash         string           `json:"hash"`
+	Height       int              `json:"height"`
+	PreBlock     string           `json:"previous"`
+	MerkelRoot   string           `json:"merkel_root"`
+	Origin       string           `json:"origin"`
+	TimeStamp    uint32           `json:"timestamp"`
+	Bits         uint32           `json:"bits"`
+	Nonce        uint32           `json:"nonce"`
+	Transactions []APITransaction `json:"transactions"`
+}
+
+//节点的JSON格式
+type APIPeer struct {
+	Remote          string `json:"remote"`
+	Address         string `json:"address,omitempty"`
+	ProtocolVersion uint32 `json:"protocol_version,omitempty"`
+	Height          uint32 `json:"height,omitempty"`
+	FullNode        bool   `json:"full_node"`
+}
+
+func NewAPITransaction(t Transaction) APITransaction {
+	at := APITransaction{
+		Hash:      hex.EncodeToString(t.Hash()),
+		From:      string(t.Header.From),
+		To:        string(t.Header.To),
+		Amount:    t.Header.Amount,
+		Fee:       t.Header.Fee,
+		TimeStamp: t.Header.TimeStamp,
+		Nonce:     t.Header.Nonce,
+		Payload:   hex.EncodeToString(t.Payload),
+		Signature: hex.EncodeToString(t.Signature),
+	}
+	for _, in := range t.Inputs {
+		at.Inputs = append(at.Inputs, APIInput{hex.EncodeToString(in.Hash), in.Index})
+	}
+	for _, o := range t.Outputs {
+		at.Outputs = append(at.Outputs, APIOutput{o.Amount, string(o.To)})
+	}
+	return at
+}
+
+func NewAPIBlock(b Block, height int) APIBlock {
+	ab := APIBlock{
+		Hash:         hex.EncodeToString(b.Hash()),
+		Height:       height,
+		PreBlock:     hex.EncodeToString(StripByte(b.PreBlock, 0)),
+		MerkelRoot:   hex.EncodeToString(b.BlockHeader.MerkelRoot),
+		Origin:       string(b.BlockHeader.Origin),
+		TimeStamp:    b.BlockHeader.TimeStamp,
+		Bits:         b.BlockHeader.Bits,
+		Nonce:        b.BlockHeader.Nonce,
+		Transactions: []APITransaction{},
+	}
+	for _, t := range *b.TransactionSlice {
+		ab.Transactions = append(ab.Transactions, NewAPITransaction(t))
+	}
+	return ab
+}
+
+//解析路径中prefix之后的32字节哈希值
+func apiHashParam(r *http.Request, prefix string) ([]byte, error) {
+	hash, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil || len(hash) != 32 {
+		return nil, apiError{http.StatusBadRequest, errors.New("哈希值格式错误")}
+	}
+	return hash, nil
+}
+
+func handleAPITip(r *http.Request) (interface{}, error) {
+	tip := self.Blockchain.Tip()
+	return map[string]interface{}{"network": chainParams.Name, "height": tip.Height, "hash": hex.EncodeToString(tip.Hash)}, nil
+}
+
+//区块可以用哈希值(64个十六进制字符)或主链上的高度指定
+func handleAPIBlock(r *http.Request) (interface{}, error) {
+	id := strings.TrimPrefix(r.URL.Path, "/block/")
+	var b *Block
+	if len(id) == 64 {
+		hash, err := apiHashParam(r, "/block/")
+		if err != nil {
+			return nil, err
+		}
+		b = self.Blockchain.GetBlock(hash)
+	} else {
+		height, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, apiError{http.StatusBadRequest, errors.New("区块哈希值或高度格式错误")}
+		}
+		b = self.Blockchain.BlockAt(height)
+	}
+	if b == nil {
+		return nil, apiError{http.StatusNotFound, errors.New("区块不存在")}
+	}
+	return NewAPIBlock(*b, self.Blockchain.Height(b.Hash())), nil
+}
+
+//先在主链上查找交易，再在交易池中查找
+func handleAPITransaction(r *http.Request) (interface{}, error) {
+	hash, err := apiHashParam(r, "/tx/")
+	if err != nil {
+		return nil, err
+	}
+	if b := self.Blockchain.FindTransaction(hash); b != nil {
+		for _, t := range *b.TransactionSlice {
+			if string(t.Hash()) == string(hash) {
+				at := NewAPITransaction(t)
+				height := self.Blockchain.Height(b.Hash())
+				at.Block, at.Height = hex.EncodeToString(b.Hash()), &height
+				return at, nil
+			}
+		}
+	}
+	if mp := self.Blockchain.Mempool; mp != nil {
+		if t := mp.Get(hash); t != nil {
+			return NewAPITransaction(*t), nil
+		}
+	}
+	return nil, apiError{http.StatusNotFound, errors.New("交易不存在")}
+}
+
+//提交交易，验证签名和工作量后放入交易队列，由区块链放入交易池并广播
+func handleAPISubmitTransaction(r *http.Request) (interface{}, error) {
+	req := struct {
+		Raw string `json:"raw"`
+	}{}
+	if err := decodeAPIRequest(r, &req); err != nil {
+		return nil, err
+	}
+	d, err := hex.DecodeString(req.Raw)
+	if err != nil {
+		return nil, apiError{http.StatusBadRequest, errors.New("交易数据不是十六进制")}
+	}
+	t := new(Transaction)
+	if rem, err := t.UnmarshalBinary(d); err != nil || len(rem) != 0 {
+		return nil, apiError{http.StatusBadRequest, errors.New("交易数据格式错误")}
+	}
+	if !t.VerifyTransaction(TRANSACTION_POW) {
+		return nil, apiError{http.StatusBadRequest, errors.New("交易验证失败")}
+	}
+	self.Blockchain.TransactionsQueue <- t
+	return map[string]string{"hash": hex.EncodeToString(t.Hash())}, nil
+}
+
+//发送交易的请求，金额为字符串，格式与标准输入的send命令相同
+type APISendRequest struct {
+	From    string `json:"from,omitempty"` //钱包中的密钥名称，为空时使用默认交易密钥
+	To      string `json:"to,omitempty"`
+	Amount  string `json:"amount,omitempty"`
+	Fee     string `json:"fee,omitempty"`
+	Payload string `json:"payload,omitempty"`
+}
+
+//使用节点钱包中的密钥创建并签名交易，未指定接收者时创建只包含数据的交易
+func handleAPISend(r *http.Request) (interface{}, error) {
+	req := APISendRequest{}
+	if err := decodeAPIRequest(r, &req); err != nil {
+		return nil, err
+	}
+	keypair := self.Keypair
+	if req.From != "" {
+		name := ""
+		if self.Wallet != nil {
+			name = self.Wallet.Find(req.From)
+		}
+		if name == "" {
+			return nil, apiError{http.StatusBadRequest, errors.New("密钥不存在：" + req.From)}
+		}
+		var err error
+		if keypair, err = self.Wallet.Keypair(name); err != nil {
+			return nil, err
+		}
+	}
+	var t *Transaction
+	if req.To == "" {
+		if req.Amount != "" || req.Fee != "" {
+			return nil, apiError{http.StatusBadRequest, errors.New("转账须指定接收者")}
+		}
+		t = CreateTransaction(keypair, req.Payload)
+	} else {
+		amount, err := ParseAmount(req.Amount)
+		fee := uint64(0)
+		if err == nil && req.Fee != "" {
+			fee, err = ParseAmount(req.Fee)
+		}
+		if err != nil {
+			return nil, apiError{http.StatusBadRequest, errors.New("金额格式错误：" + err.Error())}
+		}
+		to, err := ParseRecipient(req.To)
+		if err != nil {
+			return nil, apiError{http.StatusBadRequest, err}
+		}
+		t, err = CreateTransferTransaction(keypair, to, amount, fee, []byte(req.Payload))
+		if err != nil {
+			return nil, apiError{http.StatusBadRequest, err}
+		}
+	}
+	self.Blockchain.TransactionsQueue <- t
+	return map[string]string{"hash": hex.EncodeToString(t.Hash())}, nil
+}
+
+//创建未签名的转账交易，from为多重签名公钥或锁定脚本，须通过check检查，
+//返回序列化交易的十六进制，由签名者签名或填入解锁脚本后提交
+func handleAPIUnsigned(check func(from []byte) error) func(r *http.Request) (interface{}, error) {
+	return func(r *http.Request) (interface{}, error) {
+		req := APISendRequest{}
+		if err := decodeAPIRequest(r, &req); err != nil {
+			return nil, err
+		}
+		if err := check([]byte(req.From)); err != nil {
+			return nil, apiError{http.StatusBadRequest, err}
+		}
+		return createUnsignedTransaction(req)
+	}
+}
+
+func createUnsignedTransaction(req APISendRequest) (interface{}, error) {
+	amount, err := ParseAmount(req.Amount)
+	fee := uint64(0)
+	if err == nil && req.Fee != "" {
+		fee, err = ParseAmount(req.Fee)
+	}
+	if err != nil {
+		return nil, apiError{http.StatusBadRequest, errors.New("金额格式错误：" + err.Error())}
+	}
+	to, err := ParseRecipient(req.To)
+	if err != nil {
+		return nil, apiError{http.StatusBadRequest, err}
+	}
+	t, err := BuildTransferTransaction([]byte(req.From), to, amount, fee, []byte(req.Payload))
+	if err != nil {
+		return nil, apiError{http.StatusBadRequest, err}
+	}
+	raw, err := t.MarshalBinary()
+	if err != nil {
+		return nil, apiError{http.StatusBadRequest, err}
+	}
+	return map[string]string{"raw": hex.EncodeToString(raw), "hash": hex.EncodeToString(t.Hash())}, nil
+}
+
+func handleAPIPeers(r *http.Request) (interface{}, error) {
+	peers := []APIPeer{}
+	for _, node := range self.Network.ConnectedNodes() {
+		p := APIPeer{Remote: node.Remote, Address: node.Address}
+		if v := node.Version; node.Handshaked {
+			p.ProtocolVersion, p.Height = v.ProtocolVersion, v.Height
+			p.FullNode = v.Services&SERVICE_FULL_NODE != 0
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+func handleAPIMempool(r *http.Request) (interface{}, error) {
+	mp := self.Blockchain.Mempool
+	if mp == nil {
+		return nil, apiError{http.StatusNotFound, errors.New("轻节点没有交易池")}
+	}
+	ts := []APITransaction{}
+	for _, t := range mp.Select(mp.Len()) {
+		ts = append(ts, NewAPITransaction(t))
+	}
+	return ts, nil
+}
+
+func handleAPIMining(r *http.Request) (interface{}, error) {
+	return self.Blockchain.MiningStatus(), nil
+}
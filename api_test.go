@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+//测试接口使用的令牌
+const testAPIToken = "test-token"
+
+//使用测试区块链启动HTTP接口，启用/send，结束后恢复
+func newTestAPI(t *testing.T, bc *Blockchain) *httptest.Server {
+	prevBlockchain, prevNetwork := self.Blockchain, self.Network
+	self.Blockchain, self.Network = bc, SetupNetwork("127.0.0.1:0", chainParams.Port)
+	server := httptest.NewServer(NewAPIHandler(testAPIToken, true))
+	t.Cleanup(func() {
+		server.Close()
+		self.Blockchain, self.Network = prevBlockchain, prevNetwork
+	})
+	return server
+}
+
+//发送请求并解析JSON回复，检查状态码
+func apiRequest(t *testing.T, method, url string, body interface{}, status int, v interface{}) {
+	var req *http.Request
+	if method == http.MethodPost {
+		d, _ := json.Marshal(body)
+		req, _ = http.NewRequest(method, url, bytes.NewReader(d))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req, _ = http.NewRequest(method, url, nil)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAPIToken)
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	if r.StatusCode != status {
+		t.Fatal(method, url, "状态码错误", r.StatusCode)
+	}
+	if v != nil {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAPIBlocksAndTransactions(t *testing.T) {
+	bc := NewBlockchain()
+	bs := newBranch(nil, 2, "api")
+	for _, b := range bs {
+		if _, err := bc.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := newTestAPI(t, bc)
+
+	tip := map[string]interface{}{}
+	apiRequest(t, http.MethodGet, server.URL+"/tip", nil, http.StatusOK, &tip)
+	if tip["hash"] != hex.EncodeToString(bs[1].Hash()) || tip["height"] != float64(1) || tip["network"] != chainParams.Name {
+		t.Error("最新区块错误", tip)
+	}
+
+	ab := APIBlock{}
+	apiRequest(t, http.MethodGet, server.URL+"/block/1", nil, http.StatusOK, &ab)
+	if ab.Hash != hex.EncodeToString(bs[1].Hash()) || ab.PreBlock != hex.EncodeToString(bs[0].Hash()) || len(ab.Transactions) != 1 {
+		t.Error("按高度获取区块错误", ab)
+	}
+	apiRequest(t, http.MethodGet, server.URL+"/block/"+hex.EncodeToString(bs[0].Hash()), nil, http.StatusOK, &ab)
+	if ab.Height != 0 {
+		t.Error("按哈希值获取区块错误", ab)
+	}
+	apiRequest(t, http.MethodGet, server.URL+"/block/5", nil, http.StatusNotFound, nil)
+	apiRequest(t, http.MethodGet, server.URL+"/block/abc", nil, http.StatusBadRequest, nil)
+
+	tr := (*bs[1].TransactionSlice)[0]
+	at := APITransaction{}
+	apiRequest(t, http.MethodGet, server.URL+"/tx/"+hex.EncodeToString(tr.Hash()), nil, http.StatusOK, &at)
+	if at.Block != hex.EncodeToString(bs[1].Hash()) || at.Height == nil || *at.Height != 1 {
+		t.Error("主链上的交易错误", at)
+	}
+	apiRequest(t, http.MethodGet, server.URL+"/tx/"+hex.EncodeToString(SHA256(nil)), nil, http.StatusNotFound, nil)
+}
+
+func TestAPIMempoolAndSubmit(t *testing.T) {
+	bc := NewBlockchain()
+	bc.Mempool = NewMempool(nil)
+	server := newTestAPI(t, bc)
+
+	kp := newTestKeypair()
+	t1 := newTestTransaction(kp, uint32(time.Now().Unix()))
+	if err := bc.Mempool.Add(t1); err != nil {
+		t.Fatal(err)
+	}
+	ts := []APITransaction{}
+	apiRequest(t, http.MethodGet, server.URL+"/mempool", nil, http.StatusOK, &ts)
+	if len(ts) != 1 || ts[0].Hash != hex.EncodeToString(t1.Hash()) {
+		t.Error("交易池内容错误", ts)
+	}
+	at := APITransaction{}
+	apiRequest(t, http.MethodGet, server.URL+"/tx/"+hex.EncodeToString(t1.Hash()), nil, http.StatusOK, &at)
+	if at.Block != "" || at.Height != nil {
+		t.Error("交易池中的交易不应有区块", at)
+	}
+
+	//提交的交易放入交易队列
+	t2 := newTestTransaction(kp, uint32(time.Now().Unix()))
+	raw, _ := t2.MarshalBinary()
+	done := make(chan *Transaction, 1)
+	go func() { done <- <-bc.TransactionsQueue }()
+	reply := map[string]string{}
+	apiRequest(t, http.MethodPost, server.URL+"/tx", map[string]string{"raw": hex.EncodeToString(raw)}, http.StatusAccepted, &reply)
+	if reply["hash"] != hex.EncodeToString(t2.Hash()) {
+		t.Error("提交交易的回复错误", reply)
+	}
+	if queued := <-done; !bytes.Equal(queued.Hash(), t2.Hash()) {
+		t.Error("提交的交易未放入交易队列")
+	}
+
+	t2.Signature = nil
+	raw, _ = t2.MarshalBinary()
+	apiRequest(t, http.MethodPost, server.URL+"/tx", map[string]string{"raw": hex.EncodeToString(raw)}, http.StatusBadRequest, nil)
+	apiRequest(t, http.MethodGet, server.URL+"/tx", nil, http.StatusMethodNotAllowed, nil)
+}
+
+func TestAPIStatus(t *testing.T) {
+	server := newTestAPI(t, NewBlockchain())
+
+	peers := []APIPeer{}
+	apiRequest(t, http.MethodGet, server.URL+"/peers", nil, http.StatusOK, &peers)
+	if len(peers) != 0 {
+		t.Error("不应有已连接的节点", peers)
+	}
+
+	//已握手的节点显示版本消息中的信息
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	node := &Node{TCPConn: conn.(*net.TCPConn), Address: "10.0.5.33:9207"}
+	node.Version = &VersionMessage{ProtocolVersion: PROTOCOL_VERSION, Height: 7, Services: SERVICE_FULL_NODE}
+	self.Network.Nodes[conn.RemoteAddr().String()] = node
+	apiRequest(t, http.MethodGet, server.URL+"/peers", nil, http.StatusOK, &peers)
+	if len(peers) != 1 || peers[0].Address != node.Address || peers[0].Height != 7 || !peers[0].FullNode {
+		t.Error("已连接的节点信息错误", peers)
+	}
+	//客户端据此显示节点所在的网络
+	withParams(t, &TestNetParams)
+	tip := map[string]interface{}{}
+	apiRequest(t, http.MethodGet, server.URL+"/tip", nil, http.StatusOK, &tip)
+	if tip["network"] != "testnet" {
+		t.Error("应返回节点所在的网络", tip)
+	}
+	mining := MiningStatus{}
+	apiRequest(t, http.MethodGet, server.URL+"/mining", nil, http.StatusOK, &mining)
+	if mining.Mining || mining.BlocksMined != 0 {
+		t.Error("挖矿状态错误", mining)
+	}
+}
+
+//创建未签名的多重签名交易，签名者分别签名后合并提交；创建脚本交易
+func TestAPIMultisig(t *testing.T) {
+	bc := NewBlockchain()
+	bc.Mempool = NewMempool(nil)
+	server := newTestAPI(t, bc)
+
+	k, kps := newTestMultisig(t, 2, 3)
+	req := APISendRequest{From: string(k.Public()), To: string(NewAddress(newTestKeypair().Public)), Amount: "1", Fee: "0.1"}
+	reply := map[string]string{}
+	apiRequest(t, http.MethodPost, server.URL+"/multisig", req, http.StatusAccepted, &reply)
+	a, err := decodeHexTransaction(reply["raw"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply["hash"] != hex.EncodeToString(a.Hash()) || a.Header.Amount != COIN || len(a.Signature) != 0 {
+		t.Error("未签名交易错误", reply)
+	}
+	b := *a
+	if err := a.SignMultisig(kps[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SignMultisig(kps[0]); err != nil {
+		t.Fatal(err)
+	}
+	combined, err := CombineMultisigTransactions(a, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := combined.MarshalBinary()
+	done := make(chan *Transaction, 1)
+	go func() { done <- <-bc.TransactionsQueue }()
+	apiRequest(t, http.MethodPost, server.URL+"/tx", map[string]string{"raw": hex.EncodeToString(raw)}, http.StatusAccepted, nil)
+	if queued := <-done; !bytes.Equal(queued.Hash(), combined.Hash()) {
+		t.Error("多重签名交易未放入交易队列")
+	}
+
+	req.From = string(kps[0].Public)
+	apiRequest(t, http.MethodPost, server.URL+"/multisig", req, http.StatusBadRequest, nil)
+
+	//发送方为锁定脚本的交易使用/script创建
+	req.From = string(EncodeScript([]byte{OP_1}))
+	apiRequest(t, http.MethodPost, server.URL+"/multisig", req, http.StatusBadRequest, nil)
+	apiRequest(t, http.MethodPost, server.URL+"/script", req, http.StatusAccepted, &reply)
+	if s, err := decodeHexTransaction(reply["raw"]); err != nil || s.ExecuteScript() != nil {
+		t.Error("脚本交易错误", err)
+	}
+}
+
+//没有令牌或不是JSON的请求被拒绝，未启用时不能使用/send
+func TestAPIAuth(t *testing.T) {
+	server := newTestAPI(t, NewBlockchain())
+	post := func(url, contentType, token string) int {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"payload": "hello"}`))
+		req.Header.Set("Content-Type", contentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+		return r.StatusCode
+	}
+	if s := post(server.URL+"/send", "text/plain", ""); s != http.StatusUnauthorized {
+		t.Error("没有令牌的text/plain请求应被拒绝", s)
+	}
+	if s := post(server.URL+"/send", "application/json", "wrong"); s != http.StatusUnauthorized {
+		t.Error("令牌错误的请求应被拒绝", s)
+	}
+	if s := post(server.URL+"/send", "text/plain", testAPIToken); s != http.StatusUnsupportedMediaType {
+		t.Error("不是JSON的请求应被拒绝", s)
+	}
+	r, err := http.Get(server.URL + "/tip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusUnauthorized {
+		t.Error("没有令牌的GET请求应被拒绝", r.StatusCode)
+	}
+
+	disabled := httptest.NewServer(NewAPIHandler(testAPIToken, false))
+	defer disabled.Close()
+	if s := post(disabled.URL+"/send", "application/json", testAPIToken); s != http.StatusForbidden {
+		t.Error("未启用时/send应被拒绝", s)
+	}
+
+	//令牌写入数据目录，客户端读取
+	dir := t.TempDir()
+	token, err := NewAPIToken(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read, err := ReadAPIToken(dir); err != nil || read != token || len(token) != 64 {
+		t.Error("读取接口令牌失败", err)
+	}
+	info, err := os.Stat(path.Join(getDirectoryWithBaseDir(dir), BLOCKCHAIN_API_COOKIE_FILENAME))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Error("令牌文件应只有节点的用户可以读取", err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"reflect"
 )
 
@@ -20,6 +21,7 @@ type BlockHeader struct {
 	MerkelRoot []byte //Merkel根值
 	TimeStamp  uint32 //时间戳
 	Nonce      uint32 //随机数
+	Bits       uint32 //难度目标(压缩格式)
 }
 
 //新建区块
@@ -41,75 +43,86 @@ func (b *Block) Sign(keypair *Keypair) []byte {
 }
 
 //验证区块
-func (b *Block) VerifyBlock(prefix []byte) bool {
-	headerHash := b.Hash()
-	merkel := b.GenerateMerkelRoot()
+//难度目标是否符合难度调整规则，需要结合前一区块由区块树验证；交易余额需要由账本验证
+//Merkel根值的版本由区块高度决定，需要结合前一区块用VerifyMerkelRoot验证
+func (b *Block) VerifyBlock() bool {
+	for _, t := range *b.TransactionSlice {
+		if !t.VerifyTransaction(TRANSACTION_POW) {
+			return false
+		}
+		//脚本的时间锁与交易时间比较，交易时间不能晚于区块时间
+		if IsScript(t.Header.From) && t.Header.TimeStamp > b.BlockHeader.TimeStamp {
+			return false
+		}
+	}
+	return b.VerifyHeader()
+}
 
-	return reflect.DeepEqual(merkel, b.BlockHeader.MerkelRoot) &&
-		CheckProofofWork(prefix, headerHash) &&
+//验证区块头部的Merkel根值是否与高度为height时使用的版本计算的结果一致
+func (b *Block) VerifyMerkelRoot(height int) bool {
+	return reflect.DeepEqual(b.GenerateMerkelRoot(MerkelVersion(height)), b.BlockHeader.MerkelRoot)
+}
+
+//只验证区块头部：工作量证明和记账者签名，轻节点使用
+func (b *Block) VerifyHeader() bool {
+	headerHash := b.Hash()
+	return CheckBlockProofofWork(b.BlockHeader.Bits, headerHash) &&
 		SignatureVerify(b.BlockHeader.Origin, b.Signture, headerHash)
 }
 
+//获取只包含头部和签名的区块
+func (b *Block) HeaderOnly() Block {
+	return Block{b.BlockHeader, b.Signture, new(TransactionSlice)}
+}
+
 //获取区块的哈希值
 func (b *Block) Hash() []byte {
-	headHash, _ := b.BlockHeader.MarshalBinary()
-	return SHA256(headHash)
+	return b.BlockHeader.Hash()
 }
 
-//生成Merkel根值
-func (b *Block) GenerateMerkelRoot() []byte {
-
-	var merkel func(hashes [][]byte) []byte
-	merkel = func(hashes [][]byte) []byte {
+//获取区块头部哈希值，与区块哈希值相同
+func (bh *BlockHeader) Hash() []byte {
+	headHash, _ := bh.MarshalBinary()
+	return SHA256(headHash)
+}
 
-		l := len(hashes)
-		if l == 0 {
-			return nil
-		}
-		if l == 1 {
-			return hashes[0]
-		} else {
-			if l%2 == 1 {
-				return merkel([][]byte{merkel(hashes[:l-1]), hashes[l-1]})
-			}
-
-			bs := make([][]byte, l/2)
-			for i, _ := range bs {
-				j, k := i*2, (i*2)+1
-				bs[i] = SHA256(append(hashes[j], hashes[k]...))
-			}
-			return merkel(bs)
-		}
-	}
+//按版本生成Merkel根值
+func (b *Block) GenerateMerkelRoot(version byte) []byte {
 	ts := Map(func(t Transaction) []byte { return t.Hash() },
 		[]Transaction(*b.TransactionSlice)).([][]byte)
-	return merkel(ts)
+	return MerkelRoot(ts, version)
 }
 
-//序列化区块信息
+//序列化区块信息：头部 + 签名(变长) + 交易
 func (b *Block) MarshalBinary() ([]byte, error) {
 	bhb, err := b.BlockHeader.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
-	sign := FitBytesInto(b.Signture, NETWORK_KEY_SIZE)
+	buf := bytes.NewBuffer(bhb)
+	if err := writeVarBytes(buf, b.Signture); err != nil {
+		return nil, err
+	}
 	tsb, err := b.TransactionSlice.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
-	return append(append(bhb, sign...), tsb...), nil
+	buf.Write(tsb)
+	return buf.Bytes(), nil
 }
 
 //反序列化区块信息
 func (b *Block) UnmarshalBinary(d []byte) error {
 	buf := bytes.NewBuffer(d)
 	header := new(BlockHeader)
-	err := header.UnmarshalBinary(buf.Next(BLOCK_HEADER_SIZE))
+	err := header.readBinary(buf)
 	if err != nil {
 		return err
 	}
 	b.BlockHeader = header
-	b.Signture = StripByte(buf.Next(NETWORK_KEY_SIZE), 0)
+	if b.Signture, err = readVarBytes(buf); err != nil {
+		return err
+	}
 
 	ts := new(TransactionSlice)
 	err = ts.UnmarshalBinary(buf.Next(MaxInt))
@@ -121,15 +134,18 @@ func (b *Block) UnmarshalBinary(d []byte) error {
 	return nil
 }
 
-//序列化区块链头部
+//序列化区块链头部，Origin为变长字段：长度(1字节) + 公钥
 func (bh *BlockHeader) MarshalBinary() ([]byte, error) {
 	buf := new(bytes.Buffer)
 
-	buf.Write(FitBytesInto(bh.Origin, NETWORK_KEY_SIZE))
+	if err := writeVarBytes(buf, bh.Origin); err != nil {
+		return nil, err
+	}
 	binary.Write(buf, binary.LittleEndian, bh.TimeStamp)
 	buf.Write(FitBytesInto(bh.PreBlock, 32))
 	buf.Write(FitBytesInto(bh.MerkelRoot, 32))
 	binary.Write(buf, binary.LittleEndian, bh.Nonce)
+	binary.Write(buf, binary.LittleEndian, bh.Bits)
 
 	return buf.Bytes(), nil
 }
@@ -137,11 +153,29 @@ func (bh *BlockHeader) MarshalBinary() ([]byte, error) {
 //反序列化区块链头部
 func (bh *BlockHeader) UnmarshalBinary(d []byte) error {
 	buf := bytes.NewBuffer(d)
-	bh.Origin = StripByte(buf.Next(NETWORK_KEY_SIZE), 0)
+	if err := bh.readBinary(buf); err != nil {
+		return err
+	}
+	if buf.Len() != 0 {
+		return errors.New("区块头部长度错误")
+	}
+	return nil
+}
+
+//从buf中读取区块头部
+func (bh *BlockHeader) readBinary(buf *bytes.Buffer) error {
+	var err error
+	if bh.Origin, err = readVarBytes(buf); err != nil {
+		return err
+	}
+	if buf.Len() < BLOCK_HEADER_SIZE-1 {
+		return errors.New("区块头部长度不足")
+	}
 	binary.Read(bytes.NewBuffer(buf.Next(4)), binary.LittleEndian, &bh.TimeStamp)
 	bh.PreBlock = buf.Next(32)
 	bh.MerkelRoot = buf.Next(32)
 	binary.Read(bytes.NewBuffer(buf.Next(4)), binary.LittleEndian, &bh.Nonce)
+	binary.Read(bytes.NewBuffer(buf.Next(4)), binary.LittleEndian, &bh.Bits)
 
 	return nil
 }
@@ -17,7 +17,7 @@ func TestMerkellHash(t *testing.T) {
 	b := new(Block)
 	b.TransactionSlice = &TransactionSlice{*tr1, *tr2, *tr3, *tr4}
 
-	mt := b.GenerateMerkelRoot()
+	mt := b.GenerateMerkelRoot(MERKEL_VERSION_1)
 	manual := helpers.SHA256(append(helpers.SHA256(append(tr1.Hash(), tr2.Hash()...)), helpers.SHA256(append(tr3.Hash(), tr4.Hash()...))...))
 
 	if !reflect.DeepEqual(mt, manual) {
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"log"
+	"math/big"
 	"reflect"
+	"sync"
 	"time"
 )
 
@@ -12,26 +16,297 @@ type TransactionsQueue chan *Transaction
 //区块队列通道
 type BlocksQueue chan Block
 
+//区块链重组事件
+type ReorgEvent struct {
+	Fork         []byte     //分叉点区块哈希值，不在同一棵树上时为nil
+	Disconnected BlockSlice //从主链上回滚的区块，由新到旧
+	Connected    BlockSlice //新连接到主链的区块，由旧到新
+}
+
 //区块结构
 type Blockchain struct {
-	CurrentBlock Block //当前区块
-	BlockSlice         //区块切片
+	CurrentBlock Block               //当前区块
+	BlockSlice                       //区块切片，当前主链
+	Tree         *BlockTree          //区块树，包含所有分支
+	Store        *BlockStore         //区块存储
+	Orphans      *OrphanPool         //区块孤儿池
+	Sync         *Syncer             //区块同步
+	Mempool      *Mempool            //交易池，待打包的交易
+	Ledger       Ledger              //账本，主链上各公钥的余额
+	TxIndex      map[string][]byte   //交易索引，主链上的交易哈希值 -> 区块哈希值
+	KeyIndex     map[string][][]byte //账户索引，地址 -> 主链上与其相关的交易哈希值
+	HeadersOnly  bool                //轻节点，只保存区块头部，没有账本和交易池
+	Tracker      *TxTracker          //轻节点跟踪的自己的交易
+	Genesis      []byte              //创世区块哈希值，设置后只接受以创世区块为祖先的区块
+	mining       MiningStatus        //挖矿状态，由miningMutex保护
+	miningMutex  sync.Mutex
 
 	TransactionsQueue
 	BlocksQueue
+	ReorgEvents chan ReorgEvent //区块链重组事件通道
+
+	mutex sync.RWMutex //保护BlockSlice和Tree，供网络消息处理并发读取
 }
 
-//初始化区块链
-func SetupBlockChain() *Blockchain {
-	bc := new(Blockchain)
-	bc.TransactionsQueue, bc.BlocksQueue = make(TransactionsQueue), make(BlocksQueue)
+//初始化区块链，使用指定的账本，从区块文件中读取并验证已保存的区块
+func SetupBlockChain(dir string, ledger Ledger) (*Blockchain, error) {
+	bc := NewBlockchain()
+	bc.Ledger, bc.Mempool = ledger, NewMempool(ledger)
+	if err := bc.SetGenesis(chainParams.Genesis()); err != nil {
+		return nil, err
+	}
+
+	store, err := OpenBlockStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	bc.Store = store
+	if err := bc.LoadBlocks(); err != nil {
+		return nil, err
+	}
 
-	//TODO:从区块文件中读取
 	bc.CurrentBlock = bc.CreateNewBlock()
+	return bc, nil
+}
+
+//初始化轻节点的区块头部链，从区块头部文件中读取并验证已保存的区块头部
+func SetupHeaderChain(dir string) (*Blockchain, error) {
+	bc := NewBlockchain()
+	bc.HeadersOnly, bc.Ledger, bc.Mempool = true, nil, nil
+	bc.Tracker = NewTxTracker()
+	genesis := chainParams.Genesis()
+	if err := bc.SetGenesis(genesis.HeaderOnly()); err != nil {
+		return nil, err
+	}
+
+	store, err := OpenHeaderStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	bc.Store = store
+	return bc, bc.LoadBlocks()
+}
+
+//新建不带区块存储、使用账户账本的区块链
+func NewBlockchain() *Blockchain {
+	bc := new(Blockchain)
+	bc.TransactionsQueue, bc.BlocksQueue = make(TransactionsQueue), make(BlocksQueue)
+	bc.ReorgEvents = make(chan ReorgEvent, REORG_EVENT_QUEUE_SIZE)
+	bc.Tree = NewBlockTree()
+	bc.Orphans = NewOrphanPool()
+	bc.TxIndex = map[string][]byte{}
+	bc.KeyIndex = map[string][][]byte{}
+	bc.Sync = NewSyncer()
+	bc.Ledger = NewAccountLedger()
+	bc.Mempool = NewMempool(bc.Ledger)
 	return bc
 }
 
-//创建新区块
+//将创世区块作为区块树的根并连接到账本，须在加载或添加其他区块之前调用
+//创世区块不需要验证，也不保存到区块文件中
+func (bc *Blockchain) SetGenesis(g Block) error {
+	if _, err := bc.Tree.Add(g); err != nil {
+		return fmt.Errorf("添加创世区块失败：%v", err)
+	}
+	if err := bc.connectBlocks(nil, BlockSlice{g}); err != nil {
+		return err
+	}
+	bc.Genesis = g.Hash()
+	bc.BlockSlice = BlockSlice{g}
+	return nil
+}
+
+//从区块存储中加载区块，逐个重新验证后放入区块树，并选出工作量最大的主链
+//遇到无法验证的区块时，丢弃该区块及其之后的所有区块；主链上账本验证失败的区块从区块树中移除
+func (bc *Blockchain) LoadBlocks() error {
+	n := bc.Store.Len()
+	for i := 0; i < n; i++ {
+		b, err := bc.Store.Get(i)
+		if err == nil && !bc.verify(b) {
+			err = fmt.Errorf("区块 %x 验证失败", b.Hash())
+		}
+		if err == nil {
+			err = bc.checkBlock(*b)
+		}
+		if err == nil {
+			_, err = bc.Tree.Add(*b)
+		}
+		if err != nil {
+			log.Println("加载区块失败，丢弃第", i, "个及之后的区块：", err)
+			if err := bc.Store.Truncate(i); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	for bc.Tree.Best != nil {
+		//创世区块已经连接
+		path := bc.Tree.Path(bc.Tree.Get(bc.Genesis), bc.Tree.Best)
+		if err := bc.connectBlocks(nil, path); err != nil {
+			log.Println("加载区块失败：", err)
+			continue
+		}
+		bc.BlockSlice = append(bc.BlockSlice, path...)
+		break
+	}
+	fmt.Println("已加载区块：", len(bc.BlockSlice))
+	return nil
+}
+
+//验证区块，轻节点只验证区块头部
+func (bc *Blockchain) verify(b *Block) bool {
+	if bc.HeadersOnly {
+		return b.VerifyHeader()
+	}
+	return b.VerifyBlock()
+}
+
+//检查区块是否以创世区块为祖先，以及Merkel根值是否按区块高度对应的版本计算，轻节点没有交易不检查Merkel根值
+func (bc *Blockchain) checkBlock(b Block) error {
+	if bc.Genesis != nil && len(StripByte(b.PreBlock, 0)) == 0 {
+		return fmt.Errorf("区块 %x 没有前一区块，只能有一个创世区块", b.Hash())
+	}
+	if bc.HeadersOnly {
+		return nil
+	}
+	height := 0
+	if parent := bc.Tree.Get(b.PreBlock); parent != nil {
+		height = parent.Height + 1
+	}
+	if !b.VerifyMerkelRoot(height) {
+		return fmt.Errorf("区块 %x Merkel根值错误", b.Hash())
+	}
+	return nil
+}
+
+//根据哈希值获取区块，包括分支上的区块，可被其他协程调用
+func (bc *Blockchain) GetBlock(hash []byte) *Block {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	if node := bc.Tree.Get(hash); node != nil {
+		b := node.Block
+		return &b
+	}
+	return nil
+}
+
+//获取主链上指定高度的区块，超出范围时返回nil，可被其他协程调用
+func (bc *Blockchain) BlockAt(height int) *Block {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	if height < 0 || height >= len(bc.BlockSlice) {
+		return nil
+	}
+	b := bc.BlockSlice[height]
+	return &b
+}
+
+//获取主链最新区块的高度、哈希值和累计工作量
+func (bc *Blockchain) Tip() PeerTip {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	if len(bc.BlockSlice) == 0 {
+		return PeerTip{}
+	}
+	tip := PeerTip{Height: uint32(len(bc.BlockSlice) - 1), Hash: bc.BlockSlice.PreviousBlock().Hash(), Work: new(big.Int)}
+	if node := bc.Tree.Get(tip.Hash); node != nil {
+		tip.Work.Set(node.Work)
+	}
+	return tip
+}
+
+//生成区块定位器：从最新区块往前，前10个区块逐个选取，之后间隔加倍，最后包含第一个区块
+func (bc *Blockchain) BlockLocator() [][]byte {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	locator := [][]byte{}
+	step := 1
+	for i := len(bc.BlockSlice) - 1; i >= 0; i -= step {
+		locator = append(locator, bc.BlockSlice[i].Hash())
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		if i != 0 && i-step < 0 {
+			i = step
+		}
+	}
+	return locator
+}
+
+//获取定位器中第一个在主链上的区块之后的最多max个区块，都不在主链上时从第一个区块开始
+func (bc *Blockchain) BlocksAfter(locator [][]byte, max int) BlockSlice {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	start := 0
+	for _, hash := range locator {
+		node := bc.Tree.Get(hash)
+		if node != nil && node.Height < len(bc.BlockSlice) &&
+			reflect.DeepEqual(bc.BlockSlice[node.Height].Hash(), hash) {
+			start = node.Height + 1
+			break
+		}
+	}
+	end := Min(start+max, len(bc.BlockSlice))
+	if start >= end {
+		return BlockSlice{}
+	}
+	return append(BlockSlice{}, bc.BlockSlice[start:end]...)
+}
+
+//在主链上查找包含交易的区块，可被其他协程调用
+func (bc *Blockchain) FindTransaction(hash []byte) *Block {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	if node := bc.Tree.Get(bc.TxIndex[string(hash)]); node != nil {
+		b := node.Block
+		return &b
+	}
+	return nil
+}
+
+//获取区块的高度，包括分支上的区块，区块不存在时返回-1，可被其他协程调用
+func (bc *Blockchain) Height(hash []byte) int {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	if node := bc.Tree.Get(hash); node != nil {
+		return node.Height
+	}
+	return -1
+}
+
+//获取区块在主链上的高度，不在主链上时返回-1，可被其他协程调用
+func (bc *Blockchain) MainChainHeight(hash []byte) int {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	node := bc.Tree.Get(hash)
+	if node == nil || node.Height >= len(bc.BlockSlice) || !bytes.Equal(bc.BlockSlice[node.Height].Hash(), hash) {
+		return -1
+	}
+	return node.Height
+}
+
+//获取主链上与地址或公钥相关的最多max个交易哈希值，由新到旧，可被其他协程调用
+func (bc *Blockchain) KeyTransactions(public []byte, max int) [][]byte {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	hashes := bc.KeyIndex[AccountOf(public)]
+	result := [][]byte{}
+	for i := len(hashes) - 1; i >= 0 && len(result) < max; i-- {
+		result = append(result, hashes[i])
+	}
+	return result
+}
+
+//检查区块是否已在区块树或孤儿池中
+func (bc *Blockchain) HasBlock(hash []byte) bool {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.Tree.Get(hash) != nil || bc.Orphans.Exists(hash)
+}
+
+//创建新区块，从交易池中按优先级选取待打包的交易
 func (bc *Blockchain) CreateNewBlock() Block {
 	prev := bc.BlockSlice.PreviousBlock()
 
@@ -40,31 +315,136 @@ func (bc *Blockchain) CreateNewBlock() Block {
 		prevBlockHash = prev.Hash()
 	}
 	nb := NewBlock(prevBlockHash)
-	nb.BlockHeader.Origin = self.Keypair.Public
+	nb.BlockHeader.Origin = self.Miner.Public
+	nb.BlockHeader.Bits = bc.Tree.NextBits(bc.Tree.Best)
+	for _, t := range bc.Mempool.Select(BLOCK_MAX_TRANSACTIONS) {
+		t := t
+		nb.AddTransaction(&t)
+	}
 
 	return nb
 }
 
-//向区块链中添加区块
-func (bc *Blockchain) AddBlock(b Block) {
-	bc.BlockSlice = append(bc.BlockSlice, b)
+//向区块树中添加区块，并写入区块文件
+//新区块所在分支的累计工作量超过主链时切换主链并更新账本，返回主链的变化
+//新主链上的区块账本验证失败时保持原来的主链，该区块及其后代从区块树中移除
+func (bc *Blockchain) AddBlock(b Block) (*ReorgEvent, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if err := bc.checkBlock(b); err != nil {
+		return nil, err
+	}
+	prevBest := bc.Tree.Best
+	node, err := bc.Tree.Add(b)
+	if err != nil {
+		return nil, err
+	}
+	if bc.Tree.Best == prevBest {
+		if bc.Store != nil {
+			logOnError(bc.Store.Append(b))
+		}
+		return nil, nil
+	}
+
+	fork := bc.Tree.CommonAncestor(prevBest, node)
+	forkHeight := -1
+	event := &ReorgEvent{Connected: bc.Tree.Path(fork, node)}
+	if fork != nil {
+		forkHeight = fork.Height
+		event.Fork = fork.Hash
+	}
+	for i := len(bc.BlockSlice) - 1; i > forkHeight; i-- {
+		event.Disconnected = append(event.Disconnected, bc.BlockSlice[i])
+	}
+	if err := bc.connectBlocks(event.Disconnected, event.Connected); err != nil {
+		bc.Tree.Best = prevBest
+		return nil, err
+	}
+	if bc.Store != nil {
+		logOnError(bc.Store.Append(b))
+	}
+
+	//重新分配切片，避免覆盖回滚的区块
+	bc.BlockSlice = append(bc.BlockSlice[:forkHeight+1:forkHeight+1], event.Connected...)
+	return event, nil
+}
+
+//更新账本和交易索引：回滚disconnected中的区块(由新到旧)，再连接connected中的区块(由旧到新)
+//连接失败时恢复原来的账本，并将失败的区块及其后代从区块树中移除；轻节点没有账本
+func (bc *Blockchain) connectBlocks(disconnected, connected BlockSlice) error {
+	if bc.Ledger == nil {
+		return nil
+	}
+	for _, b := range disconnected {
+		bc.Ledger.DisconnectBlock(b)
+	}
+	for i, b := range connected {
+		err := bc.Ledger.ConnectBlock(b)
+		if err == nil {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			bc.Ledger.DisconnectBlock(connected[j])
+		}
+		for j := len(disconnected) - 1; j >= 0; j-- {
+			logOnError(bc.Ledger.ConnectBlock(disconnected[j]))
+		}
+		bc.Tree.Invalidate(b.Hash())
+		return fmt.Errorf("区块 %x 账本验证失败：%v", b.Hash(), err)
+	}
+
+	for _, b := range disconnected {
+		for _, t := range *b.TransactionSlice {
+			hash := t.Hash()
+			delete(bc.TxIndex, string(hash))
+			for _, key := range t.Keys() {
+				bc.KeyIndex[key] = removeHash(bc.KeyIndex[key], hash)
+				if len(bc.KeyIndex[key]) == 0 {
+					delete(bc.KeyIndex, key)
+				}
+			}
+		}
+	}
+	for _, b := range connected {
+		blockHash := b.Hash()
+		for _, t := range *b.TransactionSlice {
+			hash := t.Hash()
+			bc.TxIndex[string(hash)] = blockHash
+			for _, key := range t.Keys() {
+				bc.KeyIndex[key] = append(bc.KeyIndex[key], hash)
+			}
+		}
+	}
+	return nil
+}
+
+//从哈希值列表中移除hash
+func removeHash(hashes [][]byte, hash []byte) [][]byte {
+	for i, h := range hashes {
+		if bytes.Equal(h, hash) {
+			return append(hashes[:i:i], hashes[i+1:]...)
+		}
+	}
+	return hashes
 }
 
 //启动区块链
 func (bc *Blockchain) Run() {
 	interruptBlockGen := bc.GenerateBlock()
+	expire := time.NewTicker(MEMPOOL_EXPIRE_INTERVAL * time.Second)
 	for {
 		select {
 		//处理交易
 		case tr := <-bc.TransactionsQueue:
-			if bc.CurrentBlock.TransactionSlice.Exists(*tr) {
+			if bc.Mempool.Exists(tr.Hash()) {
 				continue
 			}
-			if !tr.VerifyTransaction(TRANSACTION_POW) {
-				fmt.Println("收到未经验证的交易信息:", tr)
+			if err := bc.Mempool.Add(*tr); err != nil {
+				fmt.Println("交易未放入交易池：", err)
 				continue
 			}
-			bc.CurrentBlock.AddTransaction(tr)
+			bc.CurrentBlock = bc.CreateNewBlock()
 			interruptBlockGen <- bc.CurrentBlock
 			//将交易广播到网络
 			mes := NewMessage(MESSAGE_SEND_TRANSACTION)
@@ -75,39 +455,86 @@ func (bc *Blockchain) Run() {
 
 		//区块处理
 		case b := <-bc.BlocksQueue:
-			if bc.BlockSlice.Exists(b) {
+			relay := !bc.Sync.TakeSynced(b.Hash())
+			if bc.HasBlock(b.Hash()) {
 				fmt.Println("区块已存在")
 				continue
 			}
-			if !b.VerifyBlock(BLOCK_POW) {
+			if !b.VerifyBlock() {
 				fmt.Println("区块未验证通过，不符合难度要求。")
 				continue
 			}
-			if reflect.DeepEqual(b.PreBlock, bc.CurrentBlock.Hash()) {
-				//TODO：区块孤儿池的实现
-				fmt.Println("缺失区块")
-			} else {
-				fmt.Println("新区块", b.Hash())
-				transDiff := TransactionSlice{}
-				if !reflect.DeepEqual(b.BlockHeader.MerkelRoot, bc.CurrentBlock.MerkelRoot) {
-					//被打包的交易信息有差别
-					transDiff = DiffTransactionSlices(*b.TransactionSlice, *bc.CurrentBlock.TransactionSlice)
-				}
-				bc.AddBlock(b)
+			if bc.addOrphan(b) {
+				continue
+			}
+
+			bc.ProcessBlock(b, relay, interruptBlockGen)
+			//连接等待该区块的孤儿区块
+			for _, orphan := range bc.Orphans.Take(b.Hash()) {
+				bc.ProcessBlock(orphan, true, interruptBlockGen)
+			}
 
-				//广播区块
-				mes := NewMessage(MESSAGE_SEND_BLOCK)
-				mes.Data, _ = b.MarshalBinary()
-				self.Network.BroadcastQueue <- *mes
+		//移除过期交易
+		case now := <-expire.C:
+			bc.Mempool.Expire(now)
+		}
+	}
+}
 
-				//新区块
-				bc.CurrentBlock = bc.CreateNewBlock()
-				bc.CurrentBlock.TransactionSlice = &transDiff
+//前一区块缺失时，将区块放入孤儿池并向网络请求缺失的区块
+//前一区块本身是孤儿区块时同样放入孤儿池，等前一区块连接后一起连接
+func (bc *Blockchain) addOrphan(b Block) bool {
+	bc.mutex.RLock()
+	connected := bc.Tree.Get(b.PreBlock) != nil
+	bc.mutex.RUnlock()
+	if len(StripByte(b.PreBlock, 0)) == 0 || connected {
+		return false
+	}
+	bc.Orphans.Add(b)
+	missing := bc.Orphans.MissingAncestor(b)
+	fmt.Println("缺失区块", missing)
 
-				interruptBlockGen <- bc.CurrentBlock
-			}
+	mes := NewMessage(MESSAGE_GET_BLOCK)
+	mes.Data = missing
+	self.Network.BroadcastQueue <- *mes
+	return true
+}
+
+//将区块加入区块链，relay为真时广播，主链变化时更新交易池并重新开始挖矿
+func (bc *Blockchain) ProcessBlock(b Block, relay bool, interruptBlockGen chan Block) {
+	event, err := bc.AddBlock(b)
+	if err != nil {
+		fmt.Println("添加区块失败：", err)
+		return
+	}
+	fmt.Println("新区块", b.Hash())
+
+	//广播区块，分支上的区块也需要广播，以便其他节点切换分支
+	//同步得到的历史区块不广播，同步期间挖出或收到的新区块照常广播
+	if relay {
+		mes := NewMessage(MESSAGE_SEND_BLOCK)
+		mes.Data, _ = b.MarshalBinary()
+		self.Network.BroadcastQueue <- *mes
+	}
+
+	if event == nil {
+		fmt.Println("区块不在主链上")
+		return
+	}
+	if len(event.Disconnected) > 0 {
+		fmt.Println("区块链重组，回滚区块：", len(event.Disconnected), "连接区块：", len(event.Connected))
+		select {
+		case bc.ReorgEvents <- *event:
+		default:
+			log.Println("重组事件通道已满，丢弃事件")
 		}
 	}
+
+	//新区块
+	bc.Mempool.ApplyReorg(*event)
+	bc.CurrentBlock = bc.CreateNewBlock()
+
+	interruptBlockGen <- bc.CurrentBlock
 }
 
 //比对交易切片，返回不同交易
@@ -130,6 +557,30 @@ func DiffTransactionSlices(a, b TransactionSlice) (diff TransactionSlice) {
 	return diff
 }
 
+//挖矿状态
+type MiningStatus struct {
+	Mining       bool   `json:"mining"`            //是否正在挖矿，没有交易时不挖矿
+	Height       int    `json:"height"`            //正在挖的区块高度
+	Transactions int    `json:"transactions"`      //正在挖的区块中的交易数
+	Bits         uint32 `json:"bits"`              //正在挖的区块的难度目标
+	Started      uint32 `json:"started,omitempty"` //开始挖当前区块的时间
+	BlocksMined  int    `json:"blocks_mined"`      //启动以来挖出的区块数
+}
+
+//获取挖矿状态，可被其他协程调用
+func (bc *Blockchain) MiningStatus() MiningStatus {
+	bc.miningMutex.Lock()
+	defer bc.miningMutex.Unlock()
+	return bc.mining
+}
+
+//更新挖矿状态
+func (bc *Blockchain) setMining(update func(s *MiningStatus)) {
+	bc.miningMutex.Lock()
+	defer bc.miningMutex.Unlock()
+	update(&bc.mining)
+}
+
 //生成区块
 //当收到新的区块或交易时，打断挖矿，重新开始挖矿
 func (bc *Blockchain) GenerateBlock() chan Block {
@@ -140,20 +591,32 @@ func (bc *Blockchain) GenerateBlock() chan Block {
 		block := <-interrupt
 	Loop:
 		fmt.Println("开始挖矿啦！")
-		block.BlockHeader.MerkelRoot = block.GenerateMerkelRoot()
+		height := bc.Height(block.PreBlock) + 1
 		block.BlockHeader.Nonce = 0
 		block.BlockHeader.TimeStamp = uint32(time.Now().Unix())
+		//交易池接受稍微超前的交易，时间晚于区块的脚本交易留到之后的区块
+		block.RemoveFutureScripts()
+		block.BlockHeader.MerkelRoot = block.GenerateMerkelRoot(MerkelVersion(height))
+		bc.setMining(func(s *MiningStatus) {
+			s.Mining, s.Height, s.Transactions = block.TransactionSlice.Len() > 0, height, block.TransactionSlice.Len()
+			s.Bits, s.Started = block.BlockHeader.Bits, block.BlockHeader.TimeStamp
+		})
 		for true {
 			sleepTime := time.Nanosecond
 			if block.TransactionSlice.Len() > 0 {
-				if CheckProofofWork(BLOCK_POW, block.Hash()) {
+				if CheckBlockProofofWork(block.BlockHeader.Bits, block.Hash()) {
 
-					block.Signture = block.Sign(self.Keypair)
+					block.Signture = block.Sign(self.Miner)
+					bc.setMining(func(s *MiningStatus) { s.Mining, s.BlocksMined = false, s.BlocksMined+1 })
 					bc.BlocksQueue <- block
 					sleepTime = time.Hour * 24
 					fmt.Println("恭喜~挖矿成功，生成区块！")
 				} else {
 					block.BlockHeader.Nonce += 1
+					//随机数用尽时更新时间戳
+					if block.BlockHeader.Nonce == 0 {
+						block.BlockHeader.TimeStamp = uint32(time.Now().Unix())
+					}
 				}
 			} else {
 				sleepTime = time.Hour * 24
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sync"
+)
+
+//区块存储
+//区块文件(blocks.dat)只追加写入，每条记录为：长度(4字节) + 校验和(4字节) + 区块序列化数据
+//索引文件(blocks.idx)依次记录每条记录在区块文件中的偏移量(8字节)
+//写入时先写区块文件并同步到磁盘，再写索引文件。启动时校验索引，
+//若写入过程中崩溃，则扫描索引之后的完整记录补全索引，并截断残缺的记录
+type BlockStore struct {
+	data    *os.File
+	index   *os.File
+	offsets []int64 //每条记录在区块文件中的偏移量
+	size    int64   //区块文件有效长度
+
+	mutex sync.Mutex
+}
+
+//打开区块存储，不存在时创建
+func OpenBlockStore(dir string) (*BlockStore, error) {
+	return openStore(dir, BLOCKCHAIN_BLOCKS_FILENAME, BLOCKCHAIN_INDEX_FILENAME)
+}
+
+//打开轻节点的区块头部存储，记录为不含交易的区块
+func OpenHeaderStore(dir string) (*BlockStore, error) {
+	return openStore(dir, BLOCKCHAIN_HEADERS_FILENAME, BLOCKCHAIN_HEADERS_INDEX_FILENAME)
+}
+
+func openStore(dir, dataFile, indexFile string) (*BlockStore, error) {
+	dir = getDirectoryWithBaseDir(dir)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+
+	data, err := os.OpenFile(path.Join(dir, dataFile), os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(path.Join(dir, indexFile), os.O_RDWR|os.O_CREATE, 0660)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	s := &BlockStore{data: data, index: index}
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//校验并修复区块文件和索引文件
+func (s *BlockStore) recover() error {
+	info, err := s.data.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	idx, err := io.ReadAll(s.index)
+	if err != nil {
+		return err
+	}
+
+	//校验索引中记录的偏移量
+	offsets := []int64{}
+	var end int64
+	for i := 0; i+8 <= len(idx); i += 8 {
+		off := int64(binary.LittleEndian.Uint64(idx[i:]))
+		if off != end {
+			break
+		}
+		d, err := s.readRecord(off, size)
+		if err != nil {
+			break
+		}
+		offsets = append(offsets, off)
+		end = off + BLOCK_RECORD_HEADER_SIZE + int64(len(d))
+	}
+
+	//索引之后可能还有已写入区块文件但未写入索引的记录
+	for end < size {
+		d, err := s.readRecord(end, size)
+		if err != nil {
+			break
+		}
+		offsets = append(offsets, end)
+		end += BLOCK_RECORD_HEADER_SIZE + int64(len(d))
+	}
+
+	if end != size {
+		log.Println("区块文件尾部数据不完整，截断", size-end, "字节")
+		if err := s.data.Truncate(end); err != nil {
+			return err
+		}
+	}
+	if len(offsets)*8 != len(idx) || end != size {
+		log.Println("重建区块索引，共", len(offsets), "个区块")
+		if err := s.writeIndex(offsets); err != nil {
+			return err
+		}
+	}
+
+	s.offsets, s.size = offsets, end
+	return nil
+}
+
+//读取指定偏移量的记录，并校验长度和校验和
+func (s *BlockStore) readRecord(off, size int64) ([]byte, error) {
+	if off+BLOCK_RECORD_HEADER_SIZE > size {
+		return nil, errors.New("区块记录头部不完整")
+	}
+	header := make([]byte, BLOCK_RECORD_HEADER_SIZE)
+	if _, err := s.data.ReadAt(header, off); err != nil {
+		return nil, err
+	}
+	l := int64(binary.LittleEndian.Uint32(header[:4]))
+	if off+BLOCK_RECORD_HEADER_SIZE+l > size {
+		return nil, errors.New("区块记录数据不完整")
+	}
+	d := make([]byte, l)
+	if _, err := s.data.ReadAt(d, off+BLOCK_RECORD_HEADER_SIZE); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[4:], SHA256(d)[:4]) {
+		return nil, errors.New("区块记录校验和错误")
+	}
+	return d, nil
+}
+
+//重写索引文件
+func (s *BlockStore) writeIndex(offsets []int64) error {
+	buf := make([]byte, len(offsets)*8)
+	for i, off := range offsets {
+		binary.LittleEndian.PutUint64(buf[i*8:], uint64(off))
+	}
+	if err := s.index.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.index.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	return s.index.Sync()
+}
+
+//追加区块
+func (s *BlockStore) Append(b Block) error {
+	d, err := b.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record := make([]byte, BLOCK_RECORD_HEADER_SIZE, BLOCK_RECORD_HEADER_SIZE+len(d))
+	binary.LittleEndian.PutUint32(record[:4], uint32(len(d)))
+	copy(record[4:], SHA256(d)[:4])
+	record = append(record, d...)
+
+	//先写区块数据并落盘，再写索引
+	if _, err := s.data.WriteAt(record, s.size); err != nil {
+		return err
+	}
+	if err := s.data.Sync(); err != nil {
+		return err
+	}
+
+	off := make([]byte, 8)
+	binary.LittleEndian.PutUint64(off, uint64(s.size))
+	if _, err := s.index.WriteAt(off, int64(len(s.offsets))*8); err != nil {
+		return err
+	}
+	if err := s.index.Sync(); err != nil {
+		return err
+	}
+
+	s.offsets = append(s.offsets, s.size)
+	s.size += int64(len(record))
+	return nil
+}
+
+//获取存储的区块数量
+func (s *BlockStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.offsets)
+}
+
+//读取第i个区块
+func (s *BlockStore) Get(i int) (*Block, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if i < 0 || i >= len(s.offsets) {
+		return nil, errors.New("区块不存在")
+	}
+	d, err := s.readRecord(s.offsets[i], s.size)
+	if err != nil {
+		return nil, err
+	}
+	b := new(Block)
+	if err := b.UnmarshalBinary(d); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//截断存储，只保留前n个区块
+func (s *BlockStore) Truncate(n int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if n < 0 || n >= len(s.offsets) {
+		return nil
+	}
+	if err := s.writeIndex(s.offsets[:n]); err != nil {
+		return err
+	}
+	if err := s.data.Truncate(s.offsets[n]); err != nil {
+		return err
+	}
+	s.size = s.offsets[n]
+	s.offsets = s.offsets[:n]
+	return s.data.Sync()
+}
+
+//关闭区块存储
+func (s *BlockStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.index.Close()
+	if e := s.data.Close(); e != nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func newStoreTestBlocks(n int) BlockSlice {
+	bs := BlockSlice{}
+	prev := []byte{}
+	for i := 0; i < n; i++ {
+		b := NewBlock(prev)
+		b.AddTransaction(NewTransaction(nil, nil, []byte(RandomString(RandomInt(0, 1024)))))
+		b.BlockHeader.MerkelRoot = b.GenerateMerkelRoot(MERKEL_VERSION_2)
+		b.BlockHeader.Nonce = uint32(i)
+		bs = append(bs, b)
+		prev = b.Hash()
+	}
+	return bs
+}
+
+func openTestBlockStore(t *testing.T, dir string) *BlockStore {
+	s, err := OpenBlockStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func checkStoredBlocks(t *testing.T, s *BlockStore, bs BlockSlice) {
+	if s.Len() != len(bs) {
+		t.Fatal("区块数量错误：", s.Len(), len(bs))
+	}
+	for i, b := range bs {
+		sb, err := s.Get(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(sb.Hash(), b.Hash()) || sb.TransactionSlice.Len() != b.TransactionSlice.Len() {
+			t.Error("读取的区块与写入的区块不一致", i)
+		}
+	}
+}
+
+func TestBlockStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	bs := newStoreTestBlocks(3)
+
+	s := openTestBlockStore(t, dir)
+	for _, b := range bs {
+		if err := s.Append(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	s = openTestBlockStore(t, dir)
+	defer s.Close()
+	checkStoredBlocks(t, s, bs)
+}
+
+func TestBlockStoreRecoverPartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	bs := newStoreTestBlocks(3)
+
+	s := openTestBlockStore(t, dir)
+	for _, b := range bs {
+		s.Append(b)
+	}
+	s.Close()
+
+	//模拟最后一个区块写入一半时崩溃
+	file := path.Join(getDirectoryWithBaseDir(dir), BLOCKCHAIN_BLOCKS_FILENAME)
+	info, _ := os.Stat(file)
+	os.Truncate(file, info.Size()-10)
+
+	s = openTestBlockStore(t, dir)
+	checkStoredBlocks(t, s, bs[:2])
+
+	//修复后可以继续追加
+	if err := s.Append(bs[2]); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openTestBlockStore(t, dir)
+	defer s.Close()
+	checkStoredBlocks(t, s, bs)
+}
+
+func TestBlockStoreRebuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	bs := newStoreTestBlocks(3)
+
+	s := openTestBlockStore(t, dir)
+	for _, b := range bs {
+		s.Append(b)
+	}
+	s.Close()
+
+	//模拟区块写入后、索引写入前崩溃
+	os.Truncate(path.Join(getDirectoryWithBaseDir(dir), BLOCKCHAIN_INDEX_FILENAME), 8)
+
+	s = openTestBlockStore(t, dir)
+	defer s.Close()
+	checkStoredBlocks(t, s, bs)
+}
+
+func TestBlockStoreTruncate(t *testing.T) {
+	dir := t.TempDir()
+	bs := newStoreTestBlocks(3)
+
+	s := openTestBlockStore(t, dir)
+	for _, b := range bs {
+		s.Append(b)
+	}
+	if err := s.Truncate(1); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openTestBlockStore(t, dir)
+	defer s.Close()
+	checkStoredBlocks(t, s, bs[:1])
+}
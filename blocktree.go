@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+//区块树节点
+type BlockNode struct {
+	Block
+	Hash   []byte
+	Parent *BlockNode
+	Height int      //区块高度，根区块为0
+	Work   *big.Int //从根区块到该区块的累计工作量
+	seq    int      //加入区块树的顺序，工作量相同时先收到的优先
+}
+
+//区块树，记录所有已验证的分支
+type BlockTree struct {
+	nodes map[string]*BlockNode
+	Best  *BlockNode //累计工作量最大的区块
+	added int        //已加入的区块数
+}
+
+//新建区块树
+func NewBlockTree() *BlockTree {
+	return &BlockTree{nodes: map[string]*BlockNode{}}
+}
+
+//根据哈希值获取区块节点
+func (t *BlockTree) Get(hash []byte) *BlockNode {
+	return t.nodes[string(hash)]
+}
+
+//向区块树中添加区块，前一区块必须已在树中，或者区块为根区块
+//同时验证区块的难度目标和时间戳
+func (t *BlockTree) Add(b Block) (*BlockNode, error) {
+	hash := b.Hash()
+	if t.Get(hash) != nil {
+		return nil, errors.New("区块已存在")
+	}
+
+	node := &BlockNode{Block: b, Hash: hash, Work: BlockWork(b.BlockHeader.Bits)}
+	if len(StripByte(b.PreBlock, 0)) != 0 {
+		node.Parent = t.Get(b.PreBlock)
+		if node.Parent == nil {
+			return nil, errors.New("前一区块不存在")
+		}
+		node.Height = node.Parent.Height + 1
+		node.Work.Add(node.Work, node.Parent.Work)
+	}
+
+	if b.BlockHeader.Bits != t.NextBits(node.Parent) {
+		return nil, errors.New("区块难度目标错误")
+	}
+	if node.Parent != nil && b.BlockHeader.TimeStamp <= t.MedianTimePast(node.Parent) {
+		return nil, errors.New("区块时间早于前面区块时间的中位数")
+	}
+	if int64(b.BlockHeader.TimeStamp) > time.Now().Unix()+BLOCK_MAX_FUTURE_TIME {
+		return nil, errors.New("区块时间超前")
+	}
+	t.added++
+	node.seq = t.added
+	t.nodes[string(hash)] = node
+
+	//工作量相同时保留先收到的分支
+	if t.Best == nil || node.Work.Cmp(t.Best.Work) > 0 {
+		t.Best = node
+	}
+	return node, nil
+}
+
+//将区块及其所有后代从区块树中移除，并重新选择工作量最大的区块
+func (t *BlockTree) Invalidate(hash []byte) {
+	invalid := t.Get(hash)
+	if invalid == nil {
+		return
+	}
+	for k, node := range t.nodes {
+		n := node
+		for n != nil && n.Height > invalid.Height {
+			n = n.Parent
+		}
+		if n == invalid {
+			delete(t.nodes, k)
+		}
+	}
+
+	//与Add相同，工作量相同时保留先收到的区块
+	t.Best = nil
+	for _, node := range t.nodes {
+		if t.Best == nil || node.Work.Cmp(t.Best.Work) > 0 ||
+			(node.Work.Cmp(t.Best.Work) == 0 && node.seq < t.Best.seq) {
+			t.Best = node
+		}
+	}
+}
+
+//获取两个区块的共同祖先，不在同一棵树上时返回nil
+func (t *BlockTree) CommonAncestor(a, b *BlockNode) *BlockNode {
+	for a != nil && b != nil && a != b {
+		if a.Height >= b.Height {
+			a = a.Parent
+		} else {
+			b = b.Parent
+		}
+	}
+	if a != b {
+		return nil
+	}
+	return a
+}
+
+//获取从from(不包含)到to的区块，from为nil时从根区块开始
+func (t *BlockTree) Path(from, to *BlockNode) BlockSlice {
+	bs := BlockSlice{}
+	for n := to; n != nil && n != from; n = n.Parent {
+		bs = append(bs, n.Block)
+	}
+	for i, j := 0, len(bs)-1; i < j; i, j = i+1, j-1 {
+		bs[i], bs[j] = bs[j], bs[i]
+	}
+	return bs
+}
+
+//计算parent之后下一个区块的难度目标
+//每隔RetargetInterval个区块，根据这段时间的实际出块时间调整一次，规则由当前网络参数决定
+func (t *BlockTree) NextBits(parent *BlockNode) uint32 {
+	if parent == nil {
+		return chainParams.InitialBits
+	}
+	if chainParams.NoRetarget || (parent.Height+1)%chainParams.RetargetInterval != 0 {
+		return parent.BlockHeader.Bits
+	}
+
+	first := parent
+	for i := 0; i < chainParams.RetargetInterval && first.Parent != nil; i++ {
+		first = first.Parent
+	}
+	actual := int64(parent.BlockHeader.TimeStamp) - int64(first.BlockHeader.TimeStamp)
+	expected := int64(parent.Height-first.Height) * chainParams.TargetSpacing
+	return CalculateNextBits(parent.BlockHeader.Bits, actual, expected)
+}
+
+//获取node及之前BLOCK_MEDIAN_TIME_BLOCKS个区块时间戳的中位数
+func (t *BlockTree) MedianTimePast(node *BlockNode) uint32 {
+	times := []int{}
+	for n := node; n != nil && len(times) < BLOCK_MEDIAN_TIME_BLOCKS; n = n.Parent {
+		times = append(times, int(n.BlockHeader.TimeStamp))
+	}
+	sort.Ints(times)
+	return uint32(times[len(times)/2])
+}
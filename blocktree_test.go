@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//测试区块的时间戳，每生成一个区块按期望出块间隔递增，难度保持不变
+var testBlockTime = uint32(time.Now().Unix()) - 30*24*60*60
+
+//在parent之后生成n个区块，区块未经挖矿
+func newBranch(parent []byte, n int, payload string) BlockSlice {
+	bs := BlockSlice{}
+	for i := 0; i < n; i++ {
+		b := NewBlock(parent)
+		b.BlockHeader.Bits = BLOCK_INITIAL_BITS
+		testBlockTime += BLOCK_TARGET_SPACING
+		b.BlockHeader.TimeStamp = testBlockTime
+		tr := NewTransaction(nil, nil, []byte(payload+RandomString(8)))
+		tr.Signature = []byte(RandomString(16))
+		b.AddTransaction(tr)
+		b.BlockHeader.MerkelRoot = b.GenerateMerkelRoot(MERKEL_VERSION_2)
+		bs = append(bs, b)
+		parent = b.Hash()
+	}
+	return bs
+}
+
+func TestBlockTreeBestChain(t *testing.T) {
+	tree := NewBlockTree()
+	mainBranch := newBranch(nil, 3, "main")
+	side := newBranch(mainBranch[0].Hash(), 3, "side")
+
+	for _, b := range append(mainBranch, side...) {
+		if _, err := tree.Add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(tree.Best.Hash, side[2].Hash()) || tree.Best.Height != 3 {
+		t.Error("未选择工作量最大的分支")
+	}
+	fork := tree.CommonAncestor(tree.Get(mainBranch[2].Hash()), tree.Best)
+	if fork == nil || !reflect.DeepEqual(fork.Hash, mainBranch[0].Hash()) {
+		t.Error("分叉点错误")
+	}
+	if len(tree.Path(nil, tree.Best)) != 4 {
+		t.Error("主链路径错误")
+	}
+	if _, err := tree.Add(newBranch([]byte(RandomString(32)), 1, "orphan")[0]); err == nil {
+		t.Error("前一区块不存在时不应添加成功")
+	}
+}
+
+//移除分支后重新选择主链时，工作量相同的分支与Add一样保留先收到的
+func TestBlockTreeInvalidateKeepsFirstSeen(t *testing.T) {
+	tree := NewBlockTree()
+	root := newBranch(nil, 1, "root")
+	first := newBranch(root[0].Hash(), 2, "first")
+	second := newBranch(root[0].Hash(), 2, "second")
+	longer := newBranch(root[0].Hash(), 3, "longer")
+	for _, b := range append(append(append(root, first...), second...), longer...) {
+		if _, err := tree.Add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(tree.Best.Hash, longer[2].Hash()) {
+		t.Fatal("未选择工作量最大的分支")
+	}
+	tree.Invalidate(longer[0].Hash())
+	if !reflect.DeepEqual(tree.Best.Hash, first[1].Hash()) {
+		t.Error("工作量相同时应选择先收到的分支")
+	}
+}
+
+func TestBlockchainReorg(t *testing.T) {
+	bc := NewBlockchain()
+	mainBranch := newBranch(nil, 3, "main")
+	side := newBranch(mainBranch[0].Hash(), 3, "side")
+
+	for _, b := range mainBranch {
+		bc.AddBlock(b)
+	}
+	//分支工作量小于等于主链时不切换
+	for _, b := range side[:2] {
+		event, err := bc.AddBlock(b)
+		if err != nil || event != nil {
+			t.Fatal("不应切换主链", err)
+		}
+	}
+
+	event, err := bc.AddBlock(side[2])
+	if err != nil || event == nil {
+		t.Fatal("应切换到工作量更大的分支", err)
+	}
+	if len(event.Disconnected) != 2 || len(event.Connected) != 3 ||
+		!reflect.DeepEqual(event.Fork, mainBranch[0].Hash()) {
+		t.Error("重组事件错误")
+	}
+	if len(bc.BlockSlice) != 4 || !reflect.DeepEqual(bc.BlockSlice.PreviousBlock().Hash(), side[2].Hash()) {
+		t.Error("主链切换失败")
+	}
+}
+
+func TestBlockTreeRejectsBadBits(t *testing.T) {
+	tree := NewBlockTree()
+	bs := newBranch(nil, 2, "bits")
+	tree.Add(bs[0])
+
+	bs[1].BlockHeader.Bits = BLOCK_POW_LIMIT_BITS
+	if _, err := tree.Add(bs[1]); err == nil {
+		t.Error("难度目标错误的区块不应添加成功")
+	}
+}
+
+func TestBlockTreeRetarget(t *testing.T) {
+	tree := NewBlockTree()
+	parent := []byte{}
+	//出块速度是期望的两倍
+	for i := 0; i < BLOCK_RETARGET_INTERVAL; i++ {
+		b := NewBlock(parent)
+		b.BlockHeader.Bits = tree.NextBits(tree.Get(parent))
+		b.BlockHeader.TimeStamp = testBlockTime + uint32(i*BLOCK_TARGET_SPACING/2) + 1
+		if _, err := tree.Add(b); err != nil {
+			t.Fatal(err)
+		}
+		parent = b.Hash()
+	}
+	testBlockTime += BLOCK_RETARGET_INTERVAL * BLOCK_TARGET_SPACING
+
+	next := CompactToBig(tree.NextBits(tree.Best))
+	expected := new(big.Int).Div(CompactToBig(BLOCK_INITIAL_BITS), big.NewInt(2))
+	if next.Cmp(expected) != 0 {
+		t.Error("难度调整错误", next, expected)
+	}
+}
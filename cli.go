@@ -0,0 +1,695 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+//子命令
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+//node运行节点，keygen、address、keys、passwd、multisig sign和script sign使用本地钱包，其他子命令通过HTTP接口访问正在运行的节点
+var Commands []Command
+
+func init() {
+	Commands = []Command{
+		{"node", "node [-ip 地址] [-network 网络] [-rpc 地址|off] [-ledger account|utxo] [-spv] [-mempool=false]  运行节点", RunNode},
+		{"keygen", "keygen [-network 网络] [-name 名称] [-scheme 签名算法]  在钱包中生成密钥对，有助记词时派生下一个P-224密钥", RunKeygen},
+		{"address", "address [-network 网络] [-name 名称] [-public]  显示钱包中密钥的地址或公钥，默认为交易密钥", RunAddress},
+		{"keys", "keys [-network 网络] [list|init [-words 个数]|restore [-count 个数]|mnemonic|import [-name 名称] [-file 文件]|export <名称>|migrate <名称> <新名称>|mining <名称>|default <名称>]  管理钱包中的密钥", RunKeys},
+		{"passwd", "passwd [-network 网络]  修改钱包密码，旧版明文密钥直接加密", RunPasswd},
+		{"send", "send [-node 地址] [-from 名称|地址] [-to 地址|公钥 -amount 金额 [-fee 手续费]] [-payload 数据]  使用节点钱包中的密钥发送交易", RunSend},
+		{"multisig", "multisig key [-network 网络] -m 个数 <公钥>...|create [-node 地址] -from 多重签名公钥 -to 地址 -amount 金额 [-fee 手续费] [-payload 数据]|sign [-network 网络] [-name 名称] <交易>|combine <交易>...|submit [-node 地址] <交易>  创建多重签名公钥和交易，分别签名后合并提交", RunMultisig},
+		{"script", "script asm [-network 网络] <脚本>|disasm <脚本>|create [-node 地址] -from 锁定脚本 -to 地址 -amount 金额 [-fee 手续费] [-payload 数据]|sign [-network 网络] [-name 名称] <交易>|unlock <交易> <解锁脚本>|submit [-node 地址] <交易>  汇编脚本，创建脚本交易并填入解锁脚本后提交", RunScript},
+		{"block", "block [-node 地址] <哈希值|高度>  显示区块", RunBlock},
+		{"status", "status [-node 地址]  显示节点状态", RunStatus},
+		{"export", "export [-node 地址] [-from 高度] [-to 高度] [-o 文件]  导出主链区块，每行一个JSON", RunExport},
+	}
+}
+
+func FindCommand(name string) *Command {
+	for i := range Commands {
+		if Commands[i].Name == name {
+			return &Commands[i]
+		}
+	}
+	return nil
+}
+
+func PrintUsage() {
+	fmt.Fprintln(os.Stderr, "用法：yibc <子命令> [参数]")
+	for _, c := range Commands {
+		fmt.Fprintln(os.Stderr, "  yibc", c.Usage)
+	}
+}
+
+//钱包子命令的公共参数-network，返回的函数在解析参数后打开钱包
+func walletFlags(name string) (*flag.FlagSet, func() (*Wallet, error)) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	network := fs.String("network", "mainnet", "Network: mainnet, testnet or regtest")
+	return fs, func() (*Wallet, error) {
+		if err := SelectParams(*network); err != nil {
+			return nil, err
+		}
+		return OpenWallet(HOME_DIRECTORY_CONFIG, PromptPassphrase(KEYSTORE_PASSPHRASE_ENV))
+	}
+}
+
+//在钱包中生成密钥对，第一个密钥须设置钱包密码
+func RunKeygen(args []string) error {
+	fs, open := walletFlags("keygen")
+	name := fs.String("name", "", "Name of the new key, defaults to default, key1, key2...")
+	scheme := fs.String("scheme", SCHEME_P224, "Signature scheme: "+strings.Join(SignatureSchemeNames(), ", ")+", only p224 keys are derived from the mnemonic")
+	fs.Parse(args)
+	w, err := open()
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		*name = w.NextName()
+	}
+	keypair, err := w.Generate(*name, *scheme)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(NewAddress(keypair.Public)))
+	return nil
+}
+
+func RunAddress(args []string) error {
+	fs, open := walletFlags("address")
+	name := fs.String("name", "", "Name of the key, defaults to the default spending key")
+	showPublic := fs.Bool("public", false, "Print the public key instead of the address")
+	fs.Parse(args)
+	w, err := open()
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		*name = w.Default
+	}
+	public := w.Public(*name)
+	if public == nil {
+		return errors.New("没有密钥，请先运行yibc keygen")
+	}
+	if *showPublic {
+		fmt.Println(string(public))
+	} else {
+		fmt.Println(string(NewAddress(public)))
+	}
+	return nil
+}
+
+//列出、导入、导出密钥，设置助记词，设置挖矿密钥和默认交易密钥
+func RunKeys(args []string) error {
+	fs, open := walletFlags("keys")
+	fs.Parse(args)
+	w, err := open()
+	if err != nil {
+		return err
+	}
+	action, rest := "list", fs.Args()
+	if len(rest) > 0 {
+		action, rest = rest[0], rest[1:]
+	}
+	switch {
+	case action == "list" && len(rest) == 0:
+		for _, name := range w.Names() {
+			marks := []string{}
+			if name == w.Default {
+				marks = append(marks, "交易")
+			}
+			if name == w.Mining {
+				marks = append(marks, "挖矿")
+			}
+			public := w.Public(name)
+			fmt.Printf("%-16s %s %s %s %s\n", name, NewAddress(public), public, w.Paths[name], strings.Join(marks, ","))
+		}
+	case action == "init":
+		fs := flag.NewFlagSet("keys init", flag.ExitOnError)
+		words := fs.Int("words", HD_MNEMONIC_WORDS, "Number of mnemonic words: 12, 15, 18, 21 or 24")
+		fs.Parse(rest)
+		mnemonic, err := NewMnemonic(*words)
+		if err != nil {
+			return err
+		}
+		if err := w.InitMnemonic(mnemonic); err != nil {
+			return err
+		}
+		printMnemonic(mnemonic)
+	case action == "restore":
+		fs := flag.NewFlagSet("keys restore", flag.ExitOnError)
+		count := fs.Int("count", 1, "Number of keys to derive")
+		fs.Parse(rest)
+		mnemonic := []byte(os.Getenv(HD_MNEMONIC_ENV))
+		if len(mnemonic) == 0 {
+			if mnemonic, err = readPassphrase("请输入助记词："); err != nil {
+				return err
+			}
+		}
+		if err := w.Restore(string(mnemonic), *count); err != nil {
+			return err
+		}
+		fmt.Println("已恢复密钥：", strings.Join(w.Names(), " "))
+	case action == "mnemonic" && len(rest) == 0:
+		mnemonic, err := w.Mnemonic()
+		if err != nil {
+			return err
+		}
+		printMnemonic(mnemonic)
+	case action == "import":
+		return importKey(w, rest)
+	case action == "export" && len(rest) == 1:
+		e, err := w.Export(rest[0])
+		if err != nil {
+			return err
+		}
+		d, _ := json.MarshalIndent(e, "", "  ")
+		fmt.Println(string(d))
+	case action == "migrate" && len(rest) == 2:
+		k, err := w.Migrate(rest[0], rest[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(k.Public))
+	case action == "mining" && len(rest) == 1:
+		return w.SetMining(rest[0])
+	case action == "default" && len(rest) == 1:
+		return w.SetDefault(rest[0])
+	default:
+		return errors.New("用法：yibc " + FindCommand("keys").Usage)
+	}
+	return nil
+}
+
+func printMnemonic(mnemonic string) {
+	fmt.Println("请抄写并妥善保存助记词，可以用yibc keys restore恢复钱包：")
+	fmt.Println(mnemonic)
+}
+
+//从文件或标准输入读取导出的密钥，从标准输入读取时密码须用环境变量或终端输入
+func importKey(w *Wallet, args []string) error {
+	fs := flag.NewFlagSet("keys import", flag.ExitOnError)
+	name := fs.String("name", "", "Name of the imported key, defaults to the name in the file")
+	file := fs.String("file", "", "File with the exported key, defaults to stdin")
+	fs.Parse(args)
+	var r io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	e := ExportedKey{}
+	if err := json.NewDecoder(r).Decode(&e); err != nil {
+		return errors.New("密钥格式错误：" + err.Error())
+	}
+	if *name != "" {
+		e.Name = *name
+	}
+	if err := w.Import(e); err != nil {
+		return err
+	}
+	fmt.Println("已导入密钥：", e.Name)
+	return nil
+}
+
+//新旧密码可以分别用环境变量YIBC_NEW_PASSPHRASE和YIBC_PASSPHRASE指定
+func RunPasswd(args []string) error {
+	fs, open := walletFlags("passwd")
+	fs.Parse(args)
+	w, err := open()
+	if err != nil {
+		return err
+	}
+	if err := w.ChangePassphrase(PromptPassphrase(KEYSTORE_NEW_PASSPHRASE_ENV)); err != nil {
+		return err
+	}
+	fmt.Println("密码已修改")
+	return nil
+}
+
+func RunSend(args []string) error {
+	fs, client := clientFlags("send")
+	req := APISendRequest{}
+	fs.StringVar(&req.From, "from", "", "Name or address of the key in the node's wallet, defaults to the default spending key")
+	fs.StringVar(&req.To, "to", "", "Address or public key of the recipient")
+	fs.StringVar(&req.Amount, "amount", "", "Amount to transfer")
+	fs.StringVar(&req.Fee, "fee", "", "Transaction fee")
+	fs.StringVar(&req.Payload, "payload", "", "Transaction payload")
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	reply := map[string]string{}
+	if err := c.Post("/send", req, &reply); err != nil {
+		return err
+	}
+	fmt.Println(reply["hash"])
+	return nil
+}
+
+//多重签名：key由公钥创建多重签名公钥，create由节点创建未签名交易，sign使用本地钱包签名，combine合并部分签名，submit提交交易
+//交易在签名者之间以序列化交易的十六进制传递
+func RunMultisig(args []string) error {
+	if len(args) == 0 {
+		return errors.New("用法：yibc multisig key|create|sign|combine|submit [参数]")
+	}
+	action, args := args[0], args[1:]
+	switch action {
+	case "key":
+		fs := flag.NewFlagSet("multisig key", flag.ExitOnError)
+		network := fs.String("network", "mainnet", "Network: mainnet, testnet or regtest")
+		m := fs.Int("m", 0, "Number of signatures required")
+		fs.Parse(args)
+		if err := SelectParams(*network); err != nil {
+			return err
+		}
+		keys := [][]byte{}
+		for _, key := range fs.Args() {
+			keys = append(keys, []byte(key))
+		}
+		k, err := NewMultisigKey(*m, keys)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("公钥：%s\n地址：%s\n", k.Public(), NewAddress(k.Public()))
+	case "create":
+		return createUnsigned("multisig", "Multisig public key of the sender", args)
+	case "sign":
+		fs, open := walletFlags("multisig sign")
+		name := fs.String("name", "", "Name of the signing key, defaults to the default spending key")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return errors.New("用法：yibc multisig sign [-name 名称] <交易>")
+		}
+		t, err := decodeHexTransaction(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		w, err := open()
+		if err != nil {
+			return err
+		}
+		if *name == "" {
+			*name = w.Default
+		}
+		keypair, err := w.Keypair(*name)
+		if err != nil {
+			return err
+		}
+		if err := t.SignMultisig(keypair); err != nil {
+			return err
+		}
+		return printMultisigTransaction(t)
+	case "combine":
+		if len(args) == 0 {
+			return errors.New("用法：yibc multisig combine <交易>...")
+		}
+		txs := []*Transaction{}
+		for _, a := range args {
+			t, err := decodeHexTransaction(a)
+			if err != nil {
+				return err
+			}
+			txs = append(txs, t)
+		}
+		t, err := CombineMultisigTransactions(txs...)
+		if err != nil {
+			return err
+		}
+		return printMultisigTransaction(t)
+	case "submit":
+		return submitTransaction("multisig", args)
+	default:
+		return errors.New("未知的多重签名命令：" + action)
+	}
+	return nil
+}
+
+//脚本：asm汇编锁定脚本，disasm反汇编，create由节点创建未签名交易，sign使用本地钱包对交易签名，
+//unlock填入解锁脚本，submit提交交易
+func RunScript(args []string) error {
+	if len(args) == 0 {
+		return errors.New("用法：yibc script asm|disasm|create|sign|unlock|submit [参数]")
+	}
+	action, args := args[0], args[1:]
+	switch action {
+	case "asm":
+		fs := flag.NewFlagSet("script asm", flag.ExitOnError)
+		network := fs.String("network", "mainnet", "Network: mainnet, testnet or regtest")
+		fs.Parse(args)
+		if err := SelectParams(*network); err != nil {
+			return err
+		}
+		script, err := AssembleScript(strings.Join(fs.Args(), " "))
+		if err != nil {
+			return err
+		}
+		if _, err := ParseScriptKey(EncodeScript(script)); err != nil {
+			return err
+		}
+		fmt.Printf("公钥：%s\n地址：%s\n", EncodeScript(script), NewAddress(EncodeScript(script)))
+	case "disasm":
+		if len(args) != 1 {
+			return errors.New("用法：yibc script disasm <锁定脚本|解锁脚本>")
+		}
+		script, err := DecodeScript([]byte(args[0]))
+		if err != nil {
+			return err
+		}
+		text, err := Disassemble(script)
+		if err != nil {
+			return err
+		}
+		fmt.Println(text)
+	case "create":
+		return createUnsigned("script", "Encoded locking script of the sender", args)
+	case "sign":
+		fs, open := walletFlags("script sign")
+		name := fs.String("name", "", "Name of the signing key, defaults to the default spending key")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return errors.New("用法：yibc script sign [-name 名称] <交易>")
+		}
+		t, err := decodeHexTransaction(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		w, err := open()
+		if err != nil {
+			return err
+		}
+		if *name == "" {
+			*name = w.Default
+		}
+		keypair, err := w.Keypair(*name)
+		if err != nil {
+			return err
+		}
+		sig, err := keypair.Sign(t.Hash())
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(sig))
+	case "unlock":
+		if len(args) != 2 {
+			return errors.New("用法：yibc script unlock <交易> <解锁脚本>")
+		}
+		t, err := decodeHexTransaction(args[0])
+		if err != nil {
+			return err
+		}
+		unlock, err := AssembleScript(args[1])
+		if err != nil {
+			return err
+		}
+		t.Signature = nil
+		if len(unlock) > 0 {
+			t.Signature = EncodeScript(unlock)
+		}
+		raw, err := t.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(raw))
+		//执行结果输出到标准错误，方便调试
+		if err := t.ExecuteScript(); err != nil {
+			fmt.Fprintln(os.Stderr, "脚本执行失败：", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "脚本执行成功")
+		}
+	case "submit":
+		return submitTransaction("script", args)
+	default:
+		return errors.New("未知的脚本命令：" + action)
+	}
+	return nil
+}
+
+//由节点创建未签名的转账交易，输出序列化交易的十六进制
+func createUnsigned(command, fromUsage string, args []string) error {
+	fs, client := clientFlags(command + " create")
+	req := APISendRequest{}
+	fs.StringVar(&req.From, "from", "", fromUsage)
+	fs.StringVar(&req.To, "to", "", "Address or public key of the recipient")
+	fs.StringVar(&req.Amount, "amount", "", "Amount to transfer")
+	fs.StringVar(&req.Fee, "fee", "", "Transaction fee")
+	fs.StringVar(&req.Payload, "payload", "", "Transaction payload")
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	reply := map[string]string{}
+	if err := c.Post("/"+command, req, &reply); err != nil {
+		return err
+	}
+	fmt.Println(reply["raw"])
+	return nil
+}
+
+//向节点提交序列化交易的十六进制，输出交易哈希值
+func submitTransaction(command string, args []string) error {
+	fs, client := clientFlags(command + " submit")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("用法：yibc %s submit [-node 地址] <交易>", command)
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	reply := map[string]string{}
+	if err := c.Post("/tx", map[string]string{"raw": fs.Arg(0)}, &reply); err != nil {
+		return err
+	}
+	fmt.Println(reply["hash"])
+	return nil
+}
+
+//解析序列化交易的十六进制
+func decodeHexTransaction(s string) (*Transaction, error) {
+	d, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("交易数据不是十六进制")
+	}
+	t := new(Transaction)
+	if rem, err := t.UnmarshalBinary(d); err != nil || len(rem) != 0 {
+		return nil, errors.New("交易数据格式错误")
+	}
+	return t, nil
+}
+
+//输出多重签名交易的十六进制，签名状态输出到标准错误，方便直接传给下一个签名者
+func printMultisigTransaction(t *Transaction) error {
+	signed, required, err := t.MultisigStatus()
+	if err != nil {
+		return err
+	}
+	raw, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	fmt.Println(hex.EncodeToString(raw))
+	fmt.Fprintf(os.Stderr, "已签名：%d/%d\n", signed, required)
+	return nil
+}
+
+func RunBlock(args []string) error {
+	fs, client := clientFlags("block")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("用法：yibc block <哈希值|高度>")
+	}
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	b := APIBlock{}
+	if err := c.Get("/block/"+fs.Arg(0), &b); err != nil {
+		return err
+	}
+	d, _ := json.MarshalIndent(b, "", "  ")
+	fmt.Println(string(d))
+	return nil
+}
+
+func RunStatus(args []string) error {
+	fs, client := clientFlags("status")
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	tip := struct {
+		Network string `json:"network"`
+		Height  int    `json:"height"`
+		Hash    string `json:"hash"`
+	}{}
+	peers, mining := []APIPeer{}, MiningStatus{}
+	if err := c.Get("/tip", &tip); err != nil {
+		return err
+	}
+	if err := c.Get("/peers", &peers); err != nil {
+		return err
+	}
+	if err := c.Get("/mining", &mining); err != nil {
+		return err
+	}
+	//显示节点所在的网络，而不是客户端-network指定的网络
+	fmt.Printf("网络：%s\n最新区块：%d %s\n已连接节点：%d\n", tip.Network, tip.Height, tip.Hash, len(peers))
+	//轻节点没有交易池
+	mempool := []APITransaction{}
+	if err := c.Get("/mempool", &mempool); err == nil {
+		fmt.Printf("交易池：%d\n", len(mempool))
+	}
+	if mining.Mining {
+		fmt.Printf("挖矿：高度 %d，%d 个交易，难度目标 %08x，开始于 %s\n", mining.Height, mining.Transactions, mining.Bits, time.Unix(int64(mining.Started), 0).Format(time.RFC3339))
+	}
+	fmt.Printf("已挖出区块：%d\n", mining.BlocksMined)
+	return nil
+}
+
+//按高度逐个获取主链区块，每行写入一个JSON格式的区块
+func RunExport(args []string) error {
+	fs, client := clientFlags("export")
+	from := fs.Int("from", 0, "First height to export")
+	to := fs.Int("to", -1, "Last height to export, defaults to the tip")
+	out := fs.String("o", "", "Output file, defaults to stdout")
+	fs.Parse(args)
+	c, err := client()
+	if err != nil {
+		return err
+	}
+	if *to < 0 {
+		tip := struct {
+			Height int `json:"height"`
+		}{}
+		if err := c.Get("/tip", &tip); err != nil {
+			return err
+		}
+		*to = tip.Height
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	for h := *from; h <= *to; h++ {
+		b := APIBlock{}
+		if err := c.Get(fmt.Sprintf("/block/%d", h), &b); err != nil {
+			return fmt.Errorf("导出高度为%d的区块失败：%v", h, err)
+		}
+		if err := enc.Encode(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//客户端子命令的公共参数-network、-node和-token，返回的函数在解析参数后创建客户端
+//没有-token时从所选网络的数据目录读取节点生成的令牌
+func clientFlags(name string) (*flag.FlagSet, func() (*APIClient, error)) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	network := fs.String("network", "mainnet", "Network: mainnet, testnet or regtest")
+	node := fs.String("node", "", "Address of the node's HTTP JSON API, defaults to 127.0.0.1 and the network's API port")
+	token := fs.String("token", "", "Token of the node's HTTP JSON API, defaults to the one in the node's api.cookie")
+	return fs, func() (*APIClient, error) {
+		if err := SelectParams(*network); err != nil {
+			return nil, err
+		}
+		if *node == "" {
+			*node = chainParams.APIAddress()
+		}
+		if *token == "" {
+			t, err := ReadAPIToken(HOME_DIRECTORY_CONFIG)
+			if err != nil {
+				return nil, err
+			}
+			*token = t
+		}
+		c := NewAPIClient(*node)
+		c.Token = *token
+		return c, nil
+	}
+}
+
+//HTTP接口的客户端
+type APIClient struct {
+	URL    string
+	Token  string //接口令牌
+	Client *http.Client
+}
+
+func NewAPIClient(address string) *APIClient {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return &APIClient{URL: strings.TrimRight(address, "/"), Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *APIClient) Get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, v)
+}
+
+func (c *APIClient) Post(path string, body, v interface{}) error {
+	d, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.URL+path, bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, v)
+}
+
+//带上令牌发送请求并解析回复
+func (c *APIClient) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	r, err := c.Client.Do(req)
+	if err != nil {
+		return errors.New("连接节点失败：" + err.Error())
+	}
+	return decodeAPIResponse(r, v)
+}
+
+//解析回复，状态码不是2xx时返回接口的错误信息
+func decodeAPIResponse(r *http.Response, v interface{}) error {
+	defer r.Body.Close()
+	if r.StatusCode/100 != 2 {
+		e := map[string]string{}
+		if json.NewDecoder(r.Body).Decode(&e) != nil || e["error"] == "" {
+			return errors.New(r.Status)
+		}
+		return errors.New(e["error"])
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
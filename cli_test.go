@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestAPIClientSend(t *testing.T) {
+	bc := NewBlockchain()
+	bs := newBranch(nil, 1, "cli")
+	if _, err := bc.AddBlock(bs[0]); err != nil {
+		t.Fatal(err)
+	}
+	server := newTestAPI(t, bc)
+	prevKeypair := self.Keypair
+	self.Keypair = newTestKeypair()
+	defer func() { self.Keypair = prevKeypair }()
+	c := NewAPIClient(strings.TrimPrefix(server.URL, "http://"))
+	c.Token = testAPIToken
+
+	b := APIBlock{}
+	if err := c.Get("/block/0", &b); err != nil || b.Hash != hex.EncodeToString(bs[0].Hash()) {
+		t.Error("获取区块失败", err)
+	}
+	if err := c.Get("/block/9", &b); err == nil || err.Error() != "区块不存在" {
+		t.Error("应返回接口的错误信息", err)
+	}
+
+	//只包含数据的交易和转账交易都由节点签名
+	bob := string(NewAddress([]byte("bob")))
+	for _, req := range []APISendRequest{{Payload: "hello"}, {To: bob, Amount: "1.5", Fee: "0.1"}} {
+		done := make(chan *Transaction, 1)
+		go func() { done <- <-bc.TransactionsQueue }()
+		reply := map[string]string{}
+		if err := c.Post("/send", req, &reply); err != nil {
+			t.Fatal(err)
+		}
+		queued := <-done
+		if reply["hash"] != hex.EncodeToString(queued.Hash()) || !queued.VerifyTransaction(TRANSACTION_POW) {
+			t.Error("发送的交易错误", req)
+		}
+		if string(queued.Header.From) != string(self.Keypair.Public) || string(queued.Payload) != req.Payload {
+			t.Error("交易应使用节点的密钥", req)
+		}
+	}
+	if err := c.Post("/send", APISendRequest{Amount: "1"}, nil); err == nil {
+		t.Error("没有接收者的转账应失败")
+	}
+	if err := c.Post("/send", APISendRequest{To: bob, Amount: "x"}, nil); err == nil {
+		t.Error("金额格式错误应失败")
+	}
+	typo := bob[:10] + "2" + bob[11:]
+	if bob[10] == '2' {
+		typo = bob[:10] + "3" + bob[11:]
+	}
+	if err := c.Post("/send", APISendRequest{To: typo, Amount: "1"}, nil); err == nil || err.Error() != ErrAddressChecksum.Error() {
+		t.Error("输错的地址应失败", err)
+	}
+}
@@ -1,10 +1,7 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"log"
-	"os"
 	"os/user"
 	"path"
 )
@@ -13,6 +10,20 @@ const (
 	HOME_DIRECTORY_CONFIG    = "my home dir"
 	BLOCKCHAIN_DIRECTORY     = ".yibc/"
 	BLOCKCHAIN_KEYS_FILENAME = "keys.json"
+
+	BLOCKCHAIN_KEYS_DIRECTORY  = "keys"        //钱包目录，每个密钥一个文件
+	BLOCKCHAIN_WALLET_FILENAME = "wallet.json" //钱包设置，挖矿密钥、默认交易密钥和派生路径
+	BLOCKCHAIN_SEED_FILENAME   = "seed.json"   //加密的钱包助记词
+
+	BLOCKCHAIN_BLOCKS_FILENAME  = "blocks.dat"  //区块文件
+	BLOCKCHAIN_INDEX_FILENAME   = "blocks.idx"  //区块索引文件
+	BLOCKCHAIN_PEERS_FILENAME   = "peers.json"  //节点地址簿
+	BLOCKCHAIN_MEMPOOL_FILENAME = "mempool.dat" //交易池文件
+
+	BLOCKCHAIN_HEADERS_FILENAME       = "headers.dat" //轻节点区块头部文件
+	BLOCKCHAIN_HEADERS_INDEX_FILENAME = "headers.idx" //轻节点区块头部索引文件
+
+	BLOCKCHAIN_API_COOKIE_FILENAME = "api.cookie" //HTTP接口令牌，节点启动时生成，客户端读取
 )
 
 func getDirectoryWithBaseDir(dir string) string {
@@ -21,45 +32,12 @@ func getDirectoryWithBaseDir(dir string) string {
 		logOnError(err)
 		dir = usr.HomeDir
 	}
-	return path.Join(dir, BLOCKCHAIN_DIRECTORY)
-}
-
-func OpenConfiguration(dir string) (*Keypair, error) {
-	dir = getDirectoryWithBaseDir(dir)
-	//Create ~/.blockchain directory
-	err := os.MkdirAll(dir, 0777)
-	logOnError(err)
-
-	//Search for keys file inside directory
-	f, err := os.OpenFile(path.Join(dir, BLOCKCHAIN_KEYS_FILENAME), os.O_RDWR|os.O_CREATE, 0660)
-	defer f.Close()
-
-	if err != nil {
-		return nil, err
-	}
-	k := &Keypair{}
-	json.NewDecoder(f).Decode(k)
-	if k == nil && k.Public == nil && k.Private == nil {
-		return nil, nil
-	}
-	return k, f.Close()
+	return path.Join(dir, BLOCKCHAIN_DIRECTORY, chainParams.DataDir)
 }
 
-func WriteConfiguration(dir string, keypair *Keypair) error {
-	if keypair != nil {
-		dir = getDirectoryWithBaseDir(dir)
-
-		err := os.MkdirAll(dir, 0777)
-		logOnError(err)
-
-		f, err := os.OpenFile(path.Join(dir, BLOCKCHAIN_KEYS_FILENAME), os.O_RDWR|os.O_CREATE, 0660)
-
-		err = json.NewEncoder(f).Encode(keypair)
-		logOnError(err)
-
-		return f.Close()
-	}
-	return errors.New("No keypair provided to save")
+//旧版的单个密钥文件，打开钱包时移动到keys目录
+func keysFile(dir string) string {
+	return path.Join(getDirectoryWithBaseDir(dir), BLOCKCHAIN_KEYS_FILENAME)
 }
 
 func logOnError(err error) {
@@ -3,22 +3,82 @@ package main
 import "fmt"
 
 const (
-	BLOCKCHAIN_PORT  = "9207"
-	NETWORK_KEY_SIZE = 80
-	KEY_SIZE         = 28
+	BLOCKCHAIN_PORT     = "9207"
+	BLOCKCHAIN_API_PORT = "9208" //HTTP接口默认端口，只监听本机
+	MAX_KEY_SIZE        = 4096   //公钥和签名的最大长度，多重签名的公钥和签名较长，序列化时为变长字段
+	VAR_LENGTH_PREFIX   = 0xfd   //变长字段的长度不小于该值时，以该字节开头，后跟2字节长度
+	KEY_SIZE            = 28
 
 	TRANSACTION_POW_COMPLEXITY = 1 //交易计算难度
-	TRANSCATION_HEADER_SIZE    = NETWORK_KEY_SIZE /*From key*/ + NETWORK_KEY_SIZE /*To key*/ +
-		4 /*int32 TimeStamp*/ + 32 /*sha256 payload hash*/ + 4 /*int32 payload length*/ + 4 /*int32 nonce*/
-	BLOCK_HEADER_SIZE = NETWORK_KEY_SIZE /*orgin key*/ + 4 /*int32 timeStamp*/ +
-		32 /*prev block hash*/ + 32 /*merkel hash*/ + 4 /*int32 nonce*/
+	//交易头部的最小长度，公钥为空时
+	TRANSCATION_HEADER_SIZE = 1 /*From key length*/ + 1 /*To key length*/ +
+		8 /*int64 amount*/ + 8 /*int64 fee*/ + 4 /*int32 TimeStamp*/ + 32 /*sha256 payload hash*/ + 4 /*int32 payload length*/ +
+		2 /*int16 input count*/ + 2 /*int16 output count*/ + 4 /*int32 nonce*/
+	//区块头部的最小长度，公钥为空时
+	BLOCK_HEADER_SIZE = 1 /*orgin key length*/ + 4 /*int32 timeStamp*/ +
+		32 /*prev block hash*/ + 32 /*merkel hash*/ + 4 /*int32 nonce*/ + 4 /*int32 bits*/
 
-	BLOCK_POW_COMPLEXITY = 3 //区块计算难度
+	BLOCK_INITIAL_BITS        = 0x1e00ffff  //初始区块难度目标，约为前3个字节为0
+	BLOCK_POW_LIMIT_BITS      = 0x1f00ffff  //区块难度目标上限(最低难度)，约为前2个字节为0
+	BLOCK_RETARGET_INTERVAL   = 10          //每隔多少个区块调整一次难度
+	BLOCK_TARGET_SPACING      = 60          //期望出块间隔(秒)
+	BLOCK_RETARGET_MAX_ADJUST = 4           //每次难度调整的最大倍数
+	BLOCK_MEDIAN_TIME_BLOCKS  = 11          //区块时间须晚于前11个区块时间的中位数
+	BLOCK_MAX_FUTURE_TIME     = 2 * 60 * 60 //区块时间最多超前当前时间(秒)
+
+	MERKEL_VERSION_1 = 1 //第1版Merkel树，直接拼接哈希值，个数为奇数时前面的叶子合并后再与最后一个叶子合并
+	MERKEL_VERSION_2 = 2 //第2版Merkel树，叶子和内部节点加前缀区分，按小于个数的最大2的幂分割
 
 	POW_PREFIX = 0 //复杂度前缀
 
 	MESSAGE_TYPE_SIZE    = 1
 	MESSAGE_OPTIONS_SIZE = 4
+
+	MESSAGE_FRAME_HEADER_SIZE = 4 /*magic*/ + MESSAGE_TYPE_SIZE + 4 /*int32 length*/ + 4 /*checksum*/
+
+	MESSAGE_MAGIC    = 0x79696263       //消息帧魔数"yibc"
+	MAX_MESSAGE_SIZE = 32 * 1024 * 1024 //消息最大长度
+
+	BLOCK_RECORD_HEADER_SIZE = 4 /*int32 length*/ + 4 /*checksum*/ //区块文件记录头部
+
+	ORPHAN_POOL_SIZE    = 100     //孤儿池最多保存的区块数
+	ORPHAN_BLOCK_EXPIRE = 60 * 60 //孤儿区块过期时间(秒)
+
+	REORG_EVENT_QUEUE_SIZE = 16 //区块链重组事件通道缓冲
+
+	MAX_PEERS              = 16     //主动连接新节点的连接数上限
+	PEER_EXCHANGE_SIZE     = 32     //每次交换的节点地址数上限
+	PEER_EXCHANGE_INTERVAL = 5 * 60 //请求节点地址的时间间隔(秒)
+	ADDRESS_BOOK_SIZE      = 1000   //地址簿最多保存的地址数
+	ADDRESS_MAX_FAILURES   = 5      //连续连接失败多少次后移除地址
+
+	PROTOCOL_VERSION     = 5  //协议版本，第3版公钥和签名为变长字段，第4版支持多重签名，变长字段可超过255字节，第5版最新区块信息包含累计工作量
+	MIN_PROTOCOL_VERSION = 5  //支持的最低协议版本
+	NETWORK_ID           = 1  //网络标识
+	HANDSHAKE_TIMEOUT    = 10 //握手超时时间(秒)
+
+	SYNC_BATCH_SIZE         = 50  //每批同步的区块数
+	SYNC_HEADERS_BATCH_SIZE = 500 //轻节点每批同步的区块头部数
+	SYNC_TIMEOUT            = 30  //同步节点回复超时时间(秒)
+	TIP_SIZE                = 68  //最新区块信息的长度：高度 + 哈希值 + 累计工作量
+
+	SERVICE_FULL_NODE = 1 //版本消息中的服务标志：保存完整区块
+
+	MAX_TX_PROOFS      = 100 //每次回复的交易证明数上限
+	SPV_PROOF_INTERVAL = 60  //轻节点请求交易证明的时间间隔(秒)
+
+	MEMPOOL_MAX_COUNT       = 5000             //交易池最多保存的交易数
+	MEMPOOL_MAX_SIZE        = 16 * 1024 * 1024 //交易池最多保存的交易字节数
+	MEMPOOL_EXPIRY          = 24 * 60 * 60     //交易过期时间(秒)
+	MEMPOOL_MAX_FUTURE_TIME = 2 * 60 * 60      //交易时间最多超前当前时间(秒)
+	MEMPOOL_EXPIRE_INTERVAL = 60               //检查过期交易的时间间隔(秒)
+	BLOCK_MAX_TRANSACTIONS  = 1000             //每个区块最多打包的交易数
+
+	COIN         = 100000000 //1个币的最小单位数
+	BLOCK_REWARD = 50 * COIN //区块奖励，记入记账者账户
+
+	LEDGER_MODE_ACCOUNT = "account" //账户模式
+	LEDGER_MODE_UTXO    = "utxo"    //UTXO模式
 )
 
 const (
@@ -30,6 +90,20 @@ const (
 
 	MESSAGE_GET_BLOCK
 	MESSAGE_SEND_BLOCK
+
+	_                   //保留，原用于通知最新区块，现由版本消息通知
+	MESSAGE_GET_BLOCKS  //按区块定位器批量请求区块
+	MESSAGE_SEND_BLOCKS //批量发送区块
+
+	MESSAGE_VERSION //握手版本消息
+	MESSAGE_VERACK  //握手确认消息
+
+	MESSAGE_GET_PROOF  //请求交易的Merkel证明
+	MESSAGE_SEND_PROOF //发送交易的Merkel证明
+
+	MESSAGE_GET_HEADERS   //按区块定位器批量请求区块头部
+	MESSAGE_SEND_HEADERS  //批量发送区块头部
+	MESSAGE_GET_TX_PROOFS //请求与公钥相关的交易及其证明
 )
 
 func SEED_NODES() []string {
@@ -4,12 +4,10 @@
 package main
 
 import (
-	"crypto/ecdsa"    //椭圆加密算法
 	"crypto/elliptic" //椭圆曲线
-	"crypto/rand"     //为随机数
-	"math/big"        //大整数
-
-	"github.com/tv42/base58"
+	"errors"
+	"fmt"
+	"math/big" //大整数
 )
 
 //密钥对
@@ -18,6 +16,7 @@ type Keypair struct {
 	Private []byte //私钥，经Base58处理，自己保存，类似账号密码
 	//Base58编码是可逆的，可以再回推出原来的字节。
 	//Base58处理的好处：便于阅读，效率比较高。
+	//除旧版P-224外，公钥和私钥以签名算法名称和":"开头，如p224:...、ed25519:...
 }
 
 //取随机数(伪随机数)，使用椭圆加密算法(Ep224)生成公钥和私钥对
@@ -29,62 +28,95 @@ func GenerateNewKeypair() *Keypair {
 	//	2) p224.Gy, _ = new(big.Int).SetString("bd376388b5f723fb4c22dfe6cd4375a05a07476444d5819985007e34", 16)
 	//2、选择一个私有密钥k（根据随机数rand.Reader生成k(pk.D)
 	//3、根据K=kG生成公钥K(PublicKey.X,PublicKey.Y)
-	kp, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
-
-	//将公钥的x和Y的值拼接成大整数，长度为56字节=28(KEY_SIZE)*2
-	pb := bigJoin(26, kp.PublicKey.X, kp.PublicKey.Y)
+	kp, _ := GenerateKeypair(SCHEME_P224)
+	return kp
+}
 
-	//使用base58编码
-	public := base58.EncodeBig([]byte{}, pb)
-	private := base58.EncodeBig([]byte{}, kp.D)
+//使用指定的签名算法生成密钥对，公钥和私钥带算法前缀(P-224除外)
+func GenerateKeypair(scheme string) (*Keypair, error) {
+	s, err := FindSignatureScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	public, private, err := s.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Keypair{Public: encodeSchemeData(s, public), Private: encodeSchemeData(s, private)}, nil
+}
 
-	pk := Keypair{Public: public, Private: private}
-	return &pk
+//由P-224私钥计算公钥K=kG，得到密钥对，私钥须在1到曲线的阶之间
+func NewKeypairFromPrivate(d *big.Int) *Keypair {
+	s := signatureSchemes[SCHEME_P224]
+	private := FitBytesInto(d.Bytes(), KEY_SIZE)
+	public, _ := s.Public(private)
+	return &Keypair{Public: encodeSchemeData(s, public), Private: encodeSchemeData(s, private)}
 }
 
-//使用私钥为哈希值签名,签名的目的是防止伪造
-func (k *Keypair) Sign(hash []byte) ([]byte, error) {
-	//解码Base58格式私钥
-	priv, err := base58.DecodeToBig(k.Private)
+//将旧版编码的P-224密钥对转换为规范编码，私钥不变，公钥的字符串不同，旧公钥的余额须转到新公钥
+func (k *Keypair) Canonical() (*Keypair, error) {
+	s, priv, err := decodeSchemeData(k.Private)
 	if err != nil {
 		return nil, err
 	}
+	if s.Name() != SCHEME_P224_LEGACY {
+		return nil, errors.New("不是旧版编码的P-224密钥")
+	}
+	d := new(big.Int).SetBytes(priv)
+	if d.Sign() == 0 || d.Cmp(elliptic.P224().Params().N) >= 0 {
+		return nil, errors.New("P-224私钥无效")
+	}
+	return NewKeypairFromPrivate(d), nil
+}
 
-	//解码Base58公钥私钥
-	pub, err := base58.DecodeToBig(k.Public)
+//密钥对的签名算法名称
+func (k *Keypair) Scheme() string {
+	s, _, err := decodeSchemeData(k.Public)
 	if err != nil {
-		return nil, err
+		return ""
 	}
-	//切分公钥获取x,y值
-	pubb := splitBig(pub, 2)
-	x, y := pubb[0], pubb[1]
+	return s.Name()
+}
 
-	key := ecdsa.PrivateKey{ecdsa.PublicKey{elliptic.P224(), x, y}, priv}
+//使用私钥为哈希值签名,签名的目的是防止伪造，签名带有与公钥相同的算法前缀
+func (k *Keypair) Sign(hash []byte) ([]byte, error) {
+	//解码私钥，私钥和公钥的算法须相同
+	s, priv, err := decodeSchemeData(k.Private)
+	if err != nil {
+		return nil, err
+	}
+	if scheme := k.Scheme(); scheme != s.Name() {
+		return nil, fmt.Errorf("私钥的签名算法%s与公钥的%s不一致", s.Name(), scheme)
+	}
 
 	//使用私钥为哈希值签名
-	r, s, err := ecdsa.Sign(rand.Reader, &key, hash)
-	//将获取值r,s拼接，并转换为Base58格式
-	return base58.EncodeBig([]byte{}, bigJoin(KEY_SIZE, r, s)), nil
+	sign, err := s.Sign(priv, hash)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSchemeData(s, sign), nil
 }
 
-//验证签名
+//验证签名，签名与公钥的算法须相同；多重签名公钥须有足够的有效签名
 func SignatureVerify(publicKey, sign, hash []byte) bool {
-	//将公钥解码为大整数
-	pub, _ := base58.DecodeToBig(publicKey)
-	pubs := splitBig(pub, 2)
-	x, y := pubs[0], pubs[1]
-	//组建公钥
-	public := ecdsa.PublicKey{elliptic.P224(), x, y}
-
-	//切分sign
-	s, _ := base58.DecodeToBig(sign)
-	sl := splitBig(s, 2)
-	r, s := sl[0], sl[1]
+	if IsMultisig(publicKey) {
+		k, err := ParseMultisigKey(publicKey)
+		return err == nil && k.Verify(sign, hash)
+	}
+	ps, pub, err := decodeSchemeData(publicKey)
+	if err != nil {
+		return false
+	}
+	ss, sig, err := decodeSchemeData(sign)
+	if err != nil || ss != ps {
+		return false
+	}
 	//调用验证方法，并返回验证结果
-	return ecdsa.Verify(&public, hash, r, s)
+	return ps.Verify(pub, sig, hash)
 }
 
-//将大整数安装固定长度拼接
+//将大整数安装固定长度拼接，只用于旧版P-224编码：第一个整数不补齐，之后的整数用字符'0'补齐，
+//开头有0字节的整数拆分时会出错，新密钥和签名使用定长编码
 func bigJoin(expectedLen int, bigs ...*big.Int) *big.Int {
 	bs := []byte{}
 	for i, b := range bigs {
@@ -98,7 +130,7 @@ func bigJoin(expectedLen int, bigs ...*big.Int) *big.Int {
 	return new(big.Int).SetBytes(bs)
 }
 
-//拆分大整数
+//拆分大整数，只用于旧版P-224编码
 func splitBig(b *big.Int, parts int) []*big.Int {
 	bs := b.Bytes()
 	if len(bs)%2 != 0 {
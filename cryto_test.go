@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/elliptic"
 	//	"fmt"
+	"math/big"
+	"strings"
 	"testing"
+
+	"github.com/tv42/base58"
 )
 
 //测试密钥对生成
@@ -30,3 +36,190 @@ func TestKeySign(t *testing.T) {
 		}
 	}
 }
+
+//测试各签名算法的签名和验证，签名与公钥的算法须一致
+func TestSignatureSchemes(t *testing.T) {
+	hash := SHA256([]byte("scheme"))
+	others := []*Keypair{}
+	for _, name := range SignatureSchemeNames() {
+		kp, err := GenerateKeypair(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kp.Scheme() != name || len(kp.Public) > MAX_KEY_SIZE {
+			t.Error("生成的密钥对错误", name, string(kp.Public))
+		}
+		if !strings.HasPrefix(string(kp.Public), name+":") {
+			t.Error("公钥前缀错误", string(kp.Public))
+		}
+		signature, err := kp.Sign(hash)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !SignatureVerify(kp.Public, signature, hash) {
+			t.Error("验证签名失败", name)
+		}
+		if SignatureVerify(kp.Public, signature, SHA256([]byte("other"))) {
+			t.Error("其他哈希值不应验证通过", name)
+		}
+		for _, other := range others {
+			if SignatureVerify(other.Public, signature, hash) {
+				t.Error("其他密钥不应验证通过", name, other.Scheme())
+			}
+		}
+		others = append(others, kp)
+	}
+
+	if _, err := GenerateKeypair("rsa"); err == nil {
+		t.Error("不支持的算法应返回错误")
+	}
+	//私钥与公钥的算法不一致时不能签名
+	p256, _ := GenerateKeypair(SCHEME_P256)
+	ed, _ := GenerateKeypair(SCHEME_ED25519)
+	if _, err := (&Keypair{Public: p256.Public, Private: ed.Private}).Sign(hash); err == nil {
+		t.Error("算法不一致时签名应失败")
+	}
+	if SignatureVerify([]byte("rsa:abc"), []byte("rsa:abc"), hash) {
+		t.Error("不支持的算法不应验证通过")
+	}
+}
+
+//测试Base58编码保留开头的0字节
+func TestBase58LeadingZeros(t *testing.T) {
+	for _, d := range [][]byte{{0}, {0, 0, 1}, {0, 255, 0}, {1, 2, 3}} {
+		s := encodeBase58(d)
+		back, err := decodeBase58(s)
+		if err != nil || !bytes.Equal(back, d) {
+			t.Error("Base58编码错误", d, string(s), back)
+		}
+	}
+	if _, err := decodeBase58([]byte("0OIl")); err == nil {
+		t.Error("不在字母表中的字符应返回错误")
+	}
+}
+
+//测试P-224规范编码：x、y、私钥、r和s开头有0字节时都能正确编码和解码
+func TestCanonicalP224Encoding(t *testing.T) {
+	curve := elliptic.P224()
+	s := signatureSchemes[SCHEME_P224]
+	hash := SHA256([]byte("canonical"))
+
+	//查找x和y开头有0字节的私钥，私钥1开头有27个0字节
+	cases := map[string]*big.Int{"d": big.NewInt(1)}
+	for i := int64(2); len(cases) < 3; i++ {
+		x, y := curve.ScalarBaseMult(big.NewInt(i).Bytes())
+		if _, ok := cases["x"]; !ok && x.BitLen() <= 216 {
+			cases["x"] = big.NewInt(i)
+		}
+		if _, ok := cases["y"]; !ok && y.BitLen() <= 216 {
+			cases["y"] = big.NewInt(i)
+		}
+	}
+	for name, d := range cases {
+		kp := NewKeypairFromPrivate(d)
+		ks, public, err := decodeSchemeData(kp.Public)
+		if err != nil || ks != s || len(public) != KEY_SIZE+1 {
+			t.Fatal("公钥编码错误", name, err)
+		}
+		x, y := elliptic.UnmarshalCompressed(curve, public)
+		ex, ey := curve.ScalarBaseMult(d.Bytes())
+		if x == nil || x.Cmp(ex) != 0 || y.Cmp(ey) != 0 {
+			t.Error("公钥解码错误", name)
+		}
+		_, private, err := decodeSchemeData(kp.Private)
+		if err != nil || len(private) != KEY_SIZE || new(big.Int).SetBytes(private).Cmp(d) != 0 {
+			t.Error("私钥编码错误", name, err)
+		}
+		signature, err := kp.Sign(hash)
+		if err != nil || !SignatureVerify(kp.Public, signature, hash) {
+			t.Error("签名验证失败", name, err)
+		}
+	}
+
+	//签名直到r和s开头都出现过0字节
+	kp := NewKeypairFromPrivate(cases["x"])
+	found := map[int]bool{}
+	for i := 0; len(found) < 2; i++ {
+		if i == 10000 {
+			t.Fatal("没有找到开头为0字节的签名")
+		}
+		signature, err := kp.Sign(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, raw, _ := decodeSchemeData(signature)
+		if len(raw) != 2*KEY_SIZE || !SignatureVerify(kp.Public, signature, hash) {
+			t.Fatal("签名编码错误", string(signature))
+		}
+		for _, start := range []int{0, KEY_SIZE} {
+			if raw[start] == 0 {
+				found[start] = true
+			}
+		}
+	}
+}
+
+//测试各签名算法的编码往返：解码后的私钥计算出相同的公钥，签名都能验证
+func TestSignatureRoundTrip(t *testing.T) {
+	for _, name := range SignatureSchemeNames() {
+		for i := 0; i < 200; i++ {
+			kp, err := GenerateKeypair(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, private, err := decodeSchemeData(kp.Private)
+			if err != nil || s.Name() != name {
+				t.Fatal("私钥解码错误", name, err)
+			}
+			public, err := s.Public(private)
+			if err != nil || !bytes.Equal(encodeSchemeData(s, public), kp.Public) {
+				t.Fatal("由私钥计算的公钥不一致", name, string(kp.Public))
+			}
+			hash := SHA256([]byte{byte(i)})
+			signature, err := kp.Sign(hash)
+			if err != nil || !SignatureVerify(kp.Public, signature, hash) {
+				t.Fatal("签名验证失败", name, string(kp.Public), err)
+			}
+		}
+	}
+}
+
+//测试旧版P-224编码：能正确拆分的公钥签名总能验证，不能拆分的公钥不能签名，可以转换为规范编码
+func TestLegacyP224(t *testing.T) {
+	legacy := legacyP224Scheme{}
+	hash := SHA256([]byte("legacy"))
+	valid, broken := 0, 0
+	for i := int64(1); i <= 1000; i++ {
+		d := big.NewInt(i * 7919)
+		public, _ := legacy.Public(d.Bytes())
+		kp := &Keypair{Public: encodeSchemeData(legacy, public), Private: base58.EncodeBig(nil, d)}
+		if kp.Scheme() != SCHEME_P224_LEGACY {
+			t.Fatal("没有前缀的密钥应为旧版编码", string(kp.Public))
+		}
+		signature, err := kp.Sign(hash)
+		if err == ErrLegacyKey {
+			broken++
+		} else if err != nil || !SignatureVerify(kp.Public, signature, hash) {
+			t.Fatal("旧版密钥签名验证失败", i, err)
+		} else {
+			valid++
+		}
+
+		ck, err := kp.Canonical()
+		if err != nil || ck.Scheme() != SCHEME_P224 || !bytes.Equal(ck.Private, NewKeypairFromPrivate(d).Private) {
+			t.Fatal("转换为规范编码失败", err)
+		}
+		if signature, err := ck.Sign(hash); err != nil || !SignatureVerify(ck.Public, signature, hash) || SignatureVerify(kp.Public, signature, hash) {
+			t.Fatal("规范编码的签名不应能用旧版公钥验证", err)
+		}
+	}
+	if valid == 0 || broken == 0 {
+		t.Error("应同时有能拆分和不能拆分的旧版公钥", valid, broken)
+	}
+	if _, err := GenerateKeypair(SCHEME_P224_LEGACY); err == nil {
+		t.Error("不能生成旧版编码的密钥")
+	}
+	if _, err := GenerateNewKeypair().Canonical(); err == nil {
+		t.Error("规范编码的密钥不需要转换")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+//版本消息，节点连接后首先互相发送版本消息，收到对方的版本消息后回复确认
+//双方都收到版本消息和确认消息后握手完成，之后才能发送其他消息
+type VersionMessage struct {
+	ProtocolVersion uint32   //协议版本
+	NetworkId       uint32   //网络标识
+	Height          uint32   //最新区块高度
+	Hash            []byte   //最新区块哈希值，空链时为空
+	Work            *big.Int //主链的累计工作量，空链时为空
+	ListenAddress   string   //监听地址
+	Nonce           uint64   //随机数，用于发现连接到自己
+	Services        uint64   //服务标志，轻节点不提供完整区块
+}
+
+//根据本节点信息创建版本消息
+func NewVersionMessage() *VersionMessage {
+	tip := self.Blockchain.Tip()
+	services := uint64(SERVICE_FULL_NODE)
+	if self.Blockchain.HeadersOnly {
+		services = 0
+	}
+	return &VersionMessage{
+		ProtocolVersion: PROTOCOL_VERSION,
+		NetworkId:       chainParams.NetworkId,
+		Height:          tip.Height,
+		Hash:            tip.Hash,
+		Work:            tip.Work,
+		ListenAddress:   self.Network.Address,
+		Nonce:           self.Network.Nonce,
+		Services:        services,
+	}
+}
+
+//序列化版本消息
+func (v *VersionMessage) MarshalBinary() ([]byte, error) {
+	if len(v.ListenAddress) > 255 {
+		return nil, errors.New("监听地址过长")
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, v.ProtocolVersion)
+	binary.Write(buf, binary.LittleEndian, v.NetworkId)
+	tip, err := PeerTip{v.Height, v.Hash, v.Work}.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(tip)
+	buf.WriteByte(byte(len(v.ListenAddress)))
+	buf.WriteString(v.ListenAddress)
+	binary.Write(buf, binary.LittleEndian, v.Nonce)
+	binary.Write(buf, binary.LittleEndian, v.Services)
+	return buf.Bytes(), nil
+}
+
+//反序列化版本消息
+func (v *VersionMessage) UnmarshalBinary(d []byte) error {
+	if len(d) < 4+4+TIP_SIZE+1 {
+		return errors.New("版本消息长度不足")
+	}
+	buf := bytes.NewBuffer(d)
+	v.ProtocolVersion = binary.LittleEndian.Uint32(buf.Next(4))
+	v.NetworkId = binary.LittleEndian.Uint32(buf.Next(4))
+	tip, err := DecodeTip(buf.Next(TIP_SIZE))
+	if err != nil {
+		return err
+	}
+	v.Height, v.Hash, v.Work = tip.Height, tip.Hash, tip.Work
+
+	l, _ := buf.ReadByte()
+	if buf.Len() != int(l)+8+8 {
+		return errors.New("版本消息长度错误")
+	}
+	v.ListenAddress = string(buf.Next(int(l)))
+	v.Nonce = binary.LittleEndian.Uint64(buf.Next(8))
+	v.Services = binary.LittleEndian.Uint64(buf.Next(8))
+	return nil
+}
+
+//检查对方的版本消息
+func (n *Network) CheckVersion(v *VersionMessage) error {
+	if v.ProtocolVersion < MIN_PROTOCOL_VERSION {
+		return fmt.Errorf("协议版本过低：%d", v.ProtocolVersion)
+	}
+	if v.NetworkId != chainParams.NetworkId {
+		return fmt.Errorf("网络标识不一致：%d", v.NetworkId)
+	}
+	if v.Nonce == n.Nonce {
+		return errors.New("连接到了自己")
+	}
+	return nil
+}
+
+//与节点握手，超时或失败时返回错误
+func (n *Network) Handshake(node *Node, reader *MessageReader) error {
+	node.TCPConn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT * time.Second))
+	defer node.TCPConn.SetDeadline(time.Time{})
+
+	mes := NewMessage(MESSAGE_VERSION)
+	mes.Data, _ = NewVersionMessage().MarshalBinary()
+	if err := node.Send(*mes); err != nil {
+		return err
+	}
+
+	var version *VersionMessage
+	verack := false
+	for version == nil || !verack {
+		m, err := reader.ReadMessage()
+		if IsRecoverableMessageError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		switch m.Identifier {
+		case MESSAGE_VERSION:
+			if version != nil {
+				return errors.New("重复的版本消息")
+			}
+			v := new(VersionMessage)
+			if err := v.UnmarshalBinary(m.Data); err != nil {
+				return err
+			}
+			if err := n.CheckVersion(v); err != nil {
+				if v.Nonce == n.Nonce && node.Address != "" && n.AddressBook != nil {
+					n.AddressBook.Remove(node.Address)
+				}
+				return err
+			}
+			version = v
+			if err := node.Send(*NewMessage(MESSAGE_VERACK)); err != nil {
+				return err
+			}
+		case MESSAGE_VERACK:
+			verack = true
+		default:
+			return errors.New("握手完成前收到其他消息")
+		}
+	}
+
+	return n.completeHandshake(node, version)
+}
+
+//握手完成，记录节点监听地址，并通知区块同步对方的最新区块
+func (n *Network) completeHandshake(node *Node, v *VersionMessage) error {
+	//被动连接的节点使用对方的IP和声明的监听端口
+	address := node.Address
+	if address == "" {
+		if _, port, err := net.SplitHostPort(v.ListenAddress); err == nil {
+			host, _, _ := net.SplitHostPort(node.TCPConn.RemoteAddr().String())
+			address, _ = NormalizeAddress(net.JoinHostPort(host, port))
+		}
+	}
+
+	n.mutex.Lock()
+	for _, nd := range n.Nodes {
+		if nd != node && nd.Version != nil && address != "" && nd.Address == address {
+			n.mutex.Unlock()
+			return errors.New("重复连接的节点：" + address)
+		}
+	}
+	node.Address, node.Version = address, v
+	n.mutex.Unlock()
+
+	fmt.Println("节点握手完成：", node.TCPConn.RemoteAddr(), "监听地址", node.Address, "高度", v.Height)
+	if node.Address != "" && n.AddressBook != nil {
+		n.AddressBook.Seen(node.Address)
+	}
+	self.Blockchain.Sync.UpdatePeer(node, PeerTip{v.Height, v.Hash, v.Work})
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestVersionMarshalling(t *testing.T) {
+	v := &VersionMessage{ProtocolVersion: PROTOCOL_VERSION, NetworkId: NETWORK_ID, Height: 100,
+		Hash: append([]byte{0, 0}, []byte(RandomString(30))...), Work: big.NewInt(123456), ListenAddress: "10.0.5.33:9207", Nonce: 1234567, Services: SERVICE_FULL_NODE}
+	d, err := v.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newV := new(VersionMessage)
+	if err := newV.UnmarshalBinary(d); err != nil || !reflect.DeepEqual(v, newV) {
+		t.Error("版本消息序列化，反序列化失败", err)
+	}
+	if err := newV.UnmarshalBinary(d[:len(d)-1]); err == nil {
+		t.Error("长度错误的版本消息未被拒绝")
+	}
+	if err := newV.UnmarshalBinary(d[:len(d)-8]); err == nil {
+		t.Error("缺少服务标志的版本消息未被拒绝")
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	n := &Network{Nonce: 42}
+	valid := VersionMessage{ProtocolVersion: PROTOCOL_VERSION, NetworkId: NETWORK_ID, Nonce: 1}
+	if err := n.CheckVersion(&valid); err != nil {
+		t.Error(err)
+	}
+
+	old := valid
+	old.ProtocolVersion = MIN_PROTOCOL_VERSION - 1
+	other := valid
+	other.NetworkId = NETWORK_ID + 1
+	selfConn := valid
+	selfConn.Nonce = n.Nonce
+	for _, v := range []VersionMessage{old, other, selfConn} {
+		if err := n.CheckVersion(&v); err == nil {
+			t.Error("无效的版本消息未被拒绝", v)
+		}
+	}
+}
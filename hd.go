@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+const (
+	HD_HARDENED       = 0x80000000      //索引不小于该值时为强化派生，需要父私钥
+	HD_MASTER_KEY     = "yibc seed"     //由种子计算主密钥时HMAC-SHA512的密钥
+	HD_COIN_TYPE      = 9207            //派生路径中的币种
+	HD_MNEMONIC_WORDS = 12              //默认助记词个数，对应128位熵
+	HD_MNEMONIC_ENV   = "YIBC_MNEMONIC" //恢复钱包时从环境变量读取助记词
+)
+
+var ErrInvalidChild = errors.New("派生的私钥无效，请使用下一个索引")
+
+//分层确定性派生的扩展私钥，按BIP-32的方式派生，曲线为P-224，私钥为28字节
+type ExtendedKey struct {
+	Key       *big.Int //私钥
+	ChainCode []byte   //链码，32字节
+	Depth     byte
+	Index     uint32
+}
+
+//生成助记词，words为12、15、18、21或24
+func NewMnemonic(words int) (string, error) {
+	if words%3 != 0 || words < 12 || words > 24 {
+		return "", errors.New("助记词个数须为12、15、18、21或24")
+	}
+	entropy, err := bip39.NewEntropy(words / 3 * 32)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+//由助记词和可选的密码计算64字节种子，与BIP-39相同：PBKDF2-HMAC-SHA512，盐为"mnemonic"+密码，迭代2048次
+func MnemonicToSeed(mnemonic, password string) ([]byte, error) {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, password)
+	if err != nil {
+		return nil, errors.New("助记词无效：" + err.Error())
+	}
+	return seed, nil
+}
+
+//由种子计算主密钥：I = HMAC-SHA512("yibc seed", 种子)，前28字节为私钥，后32字节为链码
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.New("种子长度须为16到64字节")
+	}
+	mac := hmac.New(sha512.New, []byte(HD_MASTER_KEY))
+	mac.Write(seed)
+	I := mac.Sum(nil)
+	key := new(big.Int).SetBytes(I[:KEY_SIZE])
+	if key.Sign() == 0 || key.Cmp(elliptic.P224().Params().N) >= 0 {
+		return nil, errors.New("种子无效")
+	}
+	return &ExtendedKey{Key: key, ChainCode: I[32:]}, nil
+}
+
+//派生第i个子私钥
+//强化派生时 I = HMAC-SHA512(链码, 0x00 + 私钥(28字节) + i)，否则 I = HMAC-SHA512(链码, 压缩公钥(29字节) + i)，
+//子私钥为 (I的前28字节 + 私钥) mod n，链码为I的后32字节；前28字节不小于n或子私钥为0时返回ErrInvalidChild
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	curve := elliptic.P224()
+	n := curve.Params().N
+	data := []byte{}
+	if i >= HD_HARDENED {
+		data = append([]byte{0}, FitBytesInto(k.Key.Bytes(), KEY_SIZE)...)
+	} else {
+		x, y := curve.ScalarBaseMult(k.Key.Bytes())
+		data = elliptic.MarshalCompressed(curve, x, y)
+	}
+	data = binary.BigEndian.AppendUint32(data, i)
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(data)
+	I := mac.Sum(nil)
+	il := new(big.Int).SetBytes(I[:KEY_SIZE])
+	if il.Cmp(n) >= 0 {
+		return nil, ErrInvalidChild
+	}
+	key := il.Add(il, k.Key)
+	key.Mod(key, n)
+	if key.Sign() == 0 {
+		return nil, ErrInvalidChild
+	}
+	return &ExtendedKey{Key: key, ChainCode: I[32:], Depth: k.Depth + 1, Index: i}, nil
+}
+
+//按路径派生，如m/44'/9207'/0'/0/0，带'或h的索引为强化派生
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParseHDPath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range indexes {
+		if k, err = k.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+//派生的密钥对
+func (k *ExtendedKey) Keypair() *Keypair {
+	return NewKeypairFromPrivate(k.Key)
+}
+
+//解析派生路径
+func ParseHDPath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, errors.New("派生路径须以m开头：" + path)
+	}
+	indexes := []uint32{}
+	for _, p := range parts[1:] {
+		hardened := strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h")
+		if hardened {
+			p = p[:len(p)-1]
+		}
+		i, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || i >= HD_HARDENED {
+			return nil, errors.New("派生路径格式错误：" + path)
+		}
+		if hardened {
+			i += HD_HARDENED
+		}
+		indexes = append(indexes, uint32(i))
+	}
+	return indexes, nil
+}
+
+//钱包中第index个密钥的派生路径
+func HDKeyPath(index uint32) string {
+	return fmt.Sprintf("m/44'/%d'/0'/0/%d", HD_COIN_TYPE, index)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestMnemonic(t *testing.T) {
+	//BIP-39测试向量
+	seed, err := MnemonicToSeed(testMnemonic, "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(seed) != "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04" {
+		t.Error("种子错误", hex.EncodeToString(seed))
+	}
+	if _, err := MnemonicToSeed(strings.Repeat("abandon ", 12), ""); err == nil {
+		t.Error("校验和错误的助记词应无效")
+	}
+
+	m, err := NewMnemonic(24)
+	if err != nil || len(strings.Fields(m)) != 24 {
+		t.Fatal("生成助记词失败", err)
+	}
+	if _, err := MnemonicToSeed(m, ""); err != nil {
+		t.Error("生成的助记词应有效", err)
+	}
+	if _, err := NewMnemonic(13); err == nil {
+		t.Error("助记词个数错误时应失败")
+	}
+}
+
+func TestHDDerivation(t *testing.T) {
+	//派生结果与独立实现的计算结果一致
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors := []struct{ path, key, chain string }{
+		{"m", "b22e41dc99ea3327451a22dbe4de485dea19791bf8b3d764496e379d", "c656efc1e0750f35b3daf6550aae1eea2b619aebcf2deebaec600e215f80490a"},
+		{"m/0'", "859d9c5593934ec7342f38d860c24288679485fea52c3797310be612", "d176764a417199984db6faad6add8e031a1e1fb286d10f376734716746d1ed2b"},
+		{"m/0'/1", "75d08bbddc873d1837764cbbd2735003dd56e19158086c1843de5e77", "65580b79457040399887d6faab1ec76cec4777a122b889500563201cf47cd1c8"},
+		{"m/0'/1/2'", "8f12782c6351680e64ee301c53b15ece04cc8d9b6d426b38e88708b1", "c497d19a1e73f390083246c9aa7596f3edc363fd1e36a81155828d99fc68f8f9"},
+		{"m/0'/1/2'/2", "3829619b87ce1c3f59000486c224d7ef76c439dd1c4cdcbf4e52fa2a", "4970bedc97a704e6c117926042783c04efe3cf4cb42546d2771c93620c876b8b"},
+		{"m/0h/1/2h/2/1000000000", "184d7aa9b553e9953bf88ff697678cfb55430dc677851ba38475196d", "09e5ebb3c635326efb8495390f7736672bbd8179571448cf6564fee2649cb9b5"},
+	}
+	for _, v := range vectors {
+		k, err := master.Derive(v.path)
+		if err != nil {
+			t.Fatal(v.path, err)
+		}
+		if hex.EncodeToString(FitBytesInto(k.Key.Bytes(), KEY_SIZE)) != v.key || hex.EncodeToString(k.ChainCode) != v.chain {
+			t.Error("派生结果错误", v.path)
+		}
+	}
+	for _, path := range []string{"", "0/1", "m/x", "m/2147483648", "m/1''"} {
+		if _, err := ParseHDPath(path); err == nil {
+			t.Error("派生路径应无效", path)
+		}
+	}
+}
+
+func TestWalletRestore(t *testing.T) {
+	w, _ := OpenWallet(t.TempDir(), fixedPassphrase("secret"))
+	if err := w.InitMnemonic(testMnemonic); err != nil {
+		t.Fatal(err)
+	}
+	first, err := w.Generate(w.NextName(), SCHEME_P224)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := w.Generate(w.NextName(), SCHEME_P224)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := new(big.Int).SetString("954eea3c378647a72e5e48a775ee84c446ca66039329757a5f29ea56", 16)
+	if !reflect.DeepEqual(first, NewKeypairFromPrivate(d)) || w.Paths[WALLET_DEFAULT_KEY] != "m/44'/9207'/0'/0/0" {
+		t.Error("第一个密钥应由m/44'/9207'/0'/0/0派生")
+	}
+
+	//用助记词恢复到另一个钱包
+	restored, _ := OpenWallet(t.TempDir(), fixedPassphrase("other"))
+	if err := restored.Restore(testMnemonic, 2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.Names(), []string{WALLET_DEFAULT_KEY, "key1"}) {
+		t.Fatal("恢复的密钥名称错误", restored.Names())
+	}
+	if k, _ := restored.Keypair("key1"); !reflect.DeepEqual(k, second) {
+		t.Error("恢复的密钥错误")
+	}
+	if m, err := restored.Mnemonic(); err != nil || m != testMnemonic {
+		t.Error("获取助记词失败", err)
+	}
+	if err := restored.Restore(testMnemonic, 1); err == nil {
+		t.Error("不能恢复到非空钱包")
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"reflect"
 	"time"
 )
@@ -46,6 +49,58 @@ func StripByte(d []byte, b byte) []byte {
 	return nil
 }
 
+//写入变长字段：长度 + 数据，用于公钥和签名；长度小于0xfd时为1字节，否则为0xfd + 2字节长度(小端)
+func writeVarBytes(buf *bytes.Buffer, d []byte) error {
+	if len(d) > MAX_KEY_SIZE {
+		return errors.New("公钥或签名过长")
+	}
+	if len(d) < VAR_LENGTH_PREFIX {
+		buf.WriteByte(byte(len(d)))
+	} else {
+		buf.WriteByte(VAR_LENGTH_PREFIX)
+		binary.Write(buf, binary.LittleEndian, uint16(len(d)))
+	}
+	buf.Write(d)
+	return nil
+}
+
+//读取变长字段，长度为0时返回nil
+func readVarBytes(buf *bytes.Buffer) ([]byte, error) {
+	l, err := buf.ReadByte()
+	if err != nil {
+		return nil, errors.New("变长字段长度不足")
+	}
+	n := int(l)
+	switch {
+	case l == VAR_LENGTH_PREFIX:
+		var n16 uint16
+		if err := binary.Read(buf, binary.LittleEndian, &n16); err != nil {
+			return nil, errors.New("变长字段长度不足")
+		}
+		//长度须使用最短的编码，保证同一数据只有一种序列化结果
+		if n = int(n16); n < VAR_LENGTH_PREFIX {
+			return nil, errors.New("变长字段长度编码错误")
+		}
+	case l > VAR_LENGTH_PREFIX:
+		return nil, errors.New("变长字段长度编码错误")
+	}
+	if n > MAX_KEY_SIZE {
+		return nil, errors.New("公钥或签名过长")
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	return readBytes(buf, n)
+}
+
+//读取n个字节，不足时返回错误
+func readBytes(buf *bytes.Buffer, n int) ([]byte, error) {
+	if buf.Len() < n {
+		return nil, errors.New("数据长度不足")
+	}
+	return buf.Next(n), nil
+}
+
 // From http://devpy.wordpress.com/2013/10/24/create-random-string-in-golang/
 func RandomString(n int) string {
 
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	KEYSTORE_VERSION  = 1
+	KEYSTORE_KDF      = "scrypt"
+	KEYSTORE_CIPHER   = "aes-256-gcm"
+	KEYSTORE_SCRYPT_N = 1 << 15 //scrypt参数，解密一次约需100ms和32MB内存
+	KEYSTORE_SCRYPT_R = 8
+	KEYSTORE_SCRYPT_P = 1
+	KEYSTORE_KEY_SIZE = 32 //AES-256密钥长度
+
+	KEYSTORE_PASSPHRASE_ENV     = "YIBC_PASSPHRASE"     //从环境变量读取密码，用于无人值守运行
+	KEYSTORE_NEW_PASSPHRASE_ENV = "YIBC_NEW_PASSPHRASE" //修改密码时的新密码
+)
+
+var ErrWrongPassphrase = errors.New("密码错误或密钥文件已损坏")
+
+//用密码加密的数据
+//用scrypt从密码派生的密钥以AES-256-GCM加密，附加数据参与认证但不加密
+type EncryptedData struct {
+	KDF        string       `json:"kdf"`
+	KDFParams  ScryptParams `json:"kdfparams"`
+	Cipher     string       `json:"cipher"`
+	Nonce      string       `json:"nonce"`      //十六进制
+	Ciphertext string       `json:"ciphertext"` //十六进制，包含认证标签
+}
+
+type ScryptParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt"` //十六进制
+}
+
+//加密的密钥文件，公钥不加密，作为附加数据参与认证，查看公钥不需要密码
+type EncryptedKeypair struct {
+	Version int    `json:"version"`
+	Public  string `json:"public"`
+	EncryptedData
+}
+
+//用密码加密数据，每次使用新的盐和随机数
+func EncryptData(plaintext, aad, passphrase []byte) (*EncryptedData, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("密码不能为空")
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	e := &EncryptedData{
+		KDF:       KEYSTORE_KDF,
+		KDFParams: ScryptParams{N: KEYSTORE_SCRYPT_N, R: KEYSTORE_SCRYPT_R, P: KEYSTORE_SCRYPT_P, Salt: hex.EncodeToString(salt)},
+		Cipher:    KEYSTORE_CIPHER,
+	}
+	aead, err := e.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	e.Nonce = hex.EncodeToString(nonce)
+	e.Ciphertext = hex.EncodeToString(aead.Seal(nil, nonce, plaintext, aad))
+	return e, nil
+}
+
+//用密码解密数据，密码错误或数据被修改时返回ErrWrongPassphrase
+func (e *EncryptedData) Decrypt(aad, passphrase []byte) ([]byte, error) {
+	if e.KDF != KEYSTORE_KDF || e.Cipher != KEYSTORE_CIPHER {
+		return nil, fmt.Errorf("不支持的加密方式：%s，%s", e.KDF, e.Cipher)
+	}
+	aead, err := e.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(e.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, errors.New("随机数格式错误")
+	}
+	ciphertext, err := hex.DecodeString(e.Ciphertext)
+	if err != nil {
+		return nil, errors.New("密文格式错误")
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+//从密码派生密钥，创建AES-GCM
+func (e *EncryptedData) aead(passphrase []byte) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(e.KDFParams.Salt)
+	if err != nil {
+		return nil, errors.New("盐格式错误")
+	}
+	p := e.KDFParams
+	key, err := scrypt.Key(passphrase, salt, p.N, p.R, p.P, KEYSTORE_KEY_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//用密码加密密钥对
+func EncryptKeypair(k *Keypair, passphrase []byte) (*EncryptedKeypair, error) {
+	e, err := EncryptData(k.Private, k.Public, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedKeypair{Version: KEYSTORE_VERSION, Public: string(k.Public), EncryptedData: *e}, nil
+}
+
+//用密码解密密钥对
+func (e *EncryptedKeypair) Decrypt(passphrase []byte) (*Keypair, error) {
+	if e.Version != KEYSTORE_VERSION {
+		return nil, fmt.Errorf("不支持的密钥文件版本：%d", e.Version)
+	}
+	private, err := e.EncryptedData.Decrypt([]byte(e.Public), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &Keypair{Public: []byte(e.Public), Private: private}, nil
+}
+
+//读取的密钥文件，Encrypted和Plain最多一个不为空，都为空时文件不存在或为空
+type keyFile struct {
+	Encrypted *EncryptedKeypair
+	Plain     *Keypair //旧版明文密钥
+}
+
+func readKeyFile(file string) (*keyFile, error) {
+	d, err := os.ReadFile(file)
+	if os.IsNotExist(err) || (err == nil && len(d) == 0) {
+		return &keyFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	version := struct {
+		Version int `json:"version"`
+	}{}
+	if err := json.Unmarshal(d, &version); err != nil {
+		return nil, err
+	}
+	if version.Version == 0 {
+		k := &Keypair{}
+		if err := json.Unmarshal(d, k); err != nil {
+			return nil, err
+		}
+		if k.Public == nil || k.Private == nil {
+			return &keyFile{}, nil
+		}
+		return &keyFile{Plain: k}, nil
+	}
+	e := &EncryptedKeypair{}
+	if err := json.Unmarshal(d, e); err != nil {
+		return nil, err
+	}
+	return &keyFile{Encrypted: e}, nil
+}
+
+//先写入临时文件再重命名，写入中断时不会损坏原有的密钥文件
+func writeKeyFile(file string, e interface{}) error {
+	tmp, err := writeTempKeyFile(file, e)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+//将密钥写入file对应的临时文件，返回临时文件名，由调用者重命名
+func writeTempKeyFile(file string, e interface{}) (string, error) {
+	d, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, d, 0600); err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
+
+//获取密码，confirm为true时用于设置新密码
+type PassphraseFunc func(confirm bool) ([]byte, error)
+
+//从环境变量env读取密码，没有时在终端提示输入，设置新密码时须输入两次
+func PromptPassphrase(env string) PassphraseFunc {
+	return func(confirm bool) ([]byte, error) {
+		if p := os.Getenv(env); p != "" {
+			return []byte(p), nil
+		}
+		prompt := "请输入密钥密码："
+		if confirm {
+			prompt = "请设置密钥密码："
+		}
+		p, err := readPassphrase(prompt)
+		if err != nil || !confirm {
+			return p, err
+		}
+		again, err := readPassphrase("请再次输入密码：")
+		if err != nil {
+			return nil, err
+		}
+		if string(again) != string(p) {
+			return nil, errors.New("两次输入的密码不一致")
+		}
+		return p, nil
+	}
+}
+
+//终端中输入密码不回显，标准输入不是终端时逐字节读取一行，不影响之后对标准输入的读取
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		return term.ReadPassword(fd)
+	}
+	line, b := []byte{}, make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 0 || b[0] == '\n' {
+			if n == 0 && len(line) == 0 && err != nil {
+				return nil, err
+			}
+			break
+		}
+		line = append(line, b[0])
+	}
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
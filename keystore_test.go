@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+//返回固定密码
+func fixedPassphrase(p string) PassphraseFunc {
+	return func(confirm bool) ([]byte, error) { return []byte(p), nil }
+}
+
+func TestKeystoreEncryptDecrypt(t *testing.T) {
+	kp := newTestKeypair()
+	e, err := EncryptKeypair(kp, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Public != string(kp.Public) || e.Ciphertext == "" {
+		t.Fatal("加密的密钥格式错误")
+	}
+	k, err := e.Decrypt([]byte("secret"))
+	if err != nil || !reflect.DeepEqual(k, kp) {
+		t.Fatal("解密失败", err)
+	}
+	if _, err := e.Decrypt([]byte("wrong")); err != ErrWrongPassphrase {
+		t.Error("错误的密码应解密失败", err)
+	}
+	//公钥参与认证，被修改时解密失败
+	tampered := *e
+	tampered.Public = string(newTestKeypair().Public)
+	if _, err := tampered.Decrypt([]byte("secret")); err != ErrWrongPassphrase {
+		t.Error("公钥被修改时应解密失败", err)
+	}
+	if _, err := EncryptKeypair(kp, nil); err == nil {
+		t.Error("空密码应加密失败")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//账本，根据主链上的区块计算每个账户的余额，由区块链在主链变化时连接或回滚区块
+//账户按地址记录(AccountOf)，公钥和它的地址是同一账户
+//有账户模式(AccountLedger)和UTXO模式(UTXOLedger)两种，同一网络的节点须使用相同模式
+type Ledger interface {
+	//获取地址或公钥的余额
+	Balance(public []byte) uint64
+	//检查交易在账本上是否有效，pending为发送方在交易池中已花费的金额
+	CheckTransaction(t Transaction, pending uint64) error
+	//连接区块，区块中有无效交易时返回错误，账本不变
+	ConnectBlock(b Block) error
+	//回滚最后连接的区块
+	DisconnectBlock(b Block)
+}
+
+//根据模式名称新建账本：account或utxo
+func NewLedger(mode string) (Ledger, error) {
+	switch mode {
+	case LEDGER_MODE_ACCOUNT:
+		return NewAccountLedger(), nil
+	case LEDGER_MODE_UTXO:
+		return NewUTXOLedger(), nil
+	}
+	return nil, errors.New("未知的账本模式：" + mode)
+}
+
+//账户账本，根据主链上的区块计算每个公钥的余额
+//记账者获得区块奖励和区块中所有交易的手续费；已打包的交易哈希值被记录下来，防止交易被重放
+type AccountLedger struct {
+	balances     map[string]uint64 //地址 -> 余额
+	transactions map[string]bool   //主链上已打包的交易哈希值
+
+	mutex sync.RWMutex
+}
+
+//新建账户账本
+func NewAccountLedger() *AccountLedger {
+	return &AccountLedger{balances: map[string]uint64{}, transactions: map[string]bool{}}
+}
+
+//获取地址或公钥的余额
+func (l *AccountLedger) Balance(public []byte) uint64 {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.balances[AccountOf(public)]
+}
+
+//检查交易是否已打包在主链上
+func (l *AccountLedger) HasTransaction(hash []byte) bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.transactions[string(hash)]
+}
+
+//检查发送方在已花费pending之后，余额是否还足够支付交易
+func (l *AccountLedger) CheckTransaction(t Transaction, pending uint64) error {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if len(t.Inputs) != 0 || len(t.Outputs) != 0 {
+		return errors.New("账户模式不支持UTXO交易")
+	}
+	if l.transactions[string(t.Hash())] {
+		return errors.New("交易已打包")
+	}
+	balance := l.balances[AccountOf(t.Header.From)]
+	if pending+t.Cost() < pending || pending+t.Cost() > balance {
+		return fmt.Errorf("余额不足：%d，需要 %d", balance, pending+t.Cost())
+	}
+	return nil
+}
+
+//将区块连接到账本，逐个执行交易，最后将区块奖励和手续费记入记账者
+//任何交易余额不足或重复时返回错误，账本不变
+func (l *AccountLedger) ConnectBlock(b Block) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	changed := map[string]uint64{}
+	balance := func(key []byte) uint64 {
+		if v, ok := changed[AccountOf(key)]; ok {
+			return v
+		}
+		return l.balances[AccountOf(key)]
+	}
+	included := map[string]bool{}
+	fees := uint64(BLOCK_REWARD)
+
+	for _, t := range *b.TransactionSlice {
+		hash := string(t.Hash())
+		if l.transactions[hash] || included[hash] {
+			return fmt.Errorf("交易 %x 重复", t.Hash())
+		}
+		included[hash] = true
+
+		if len(t.Inputs) != 0 || len(t.Outputs) != 0 {
+			return fmt.Errorf("交易 %x 账户模式不支持UTXO交易", t.Hash())
+		}
+		if t.Cost() < t.Header.Amount || balance(t.Header.From) < t.Cost() {
+			return fmt.Errorf("交易 %x 余额不足", t.Hash())
+		}
+		changed[AccountOf(t.Header.From)] = balance(t.Header.From) - t.Cost()
+		if t.Header.Amount > 0 {
+			to := balance(t.Header.To)
+			if to+t.Header.Amount < to {
+				return fmt.Errorf("交易 %x 金额溢出", t.Hash())
+			}
+			changed[AccountOf(t.Header.To)] = to + t.Header.Amount
+		}
+		if fees+t.Header.Fee < fees {
+			return fmt.Errorf("交易 %x 手续费溢出", t.Hash())
+		}
+		fees += t.Header.Fee
+	}
+
+	origin := balance(b.BlockHeader.Origin)
+	if origin+fees < origin {
+		return errors.New("区块奖励溢出")
+	}
+	changed[AccountOf(b.BlockHeader.Origin)] = origin + fees
+
+	for k, v := range changed {
+		l.setBalance(k, v)
+	}
+	for hash := range included {
+		l.transactions[hash] = true
+	}
+	return nil
+}
+
+//将区块从账本中回滚，区块必须是最后连接的区块
+func (l *AccountLedger) DisconnectBlock(b Block) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	fees := uint64(BLOCK_REWARD)
+	for _, t := range *b.TransactionSlice {
+		fees += t.Header.Fee
+	}
+	origin := AccountOf(b.BlockHeader.Origin)
+	l.setBalance(origin, l.balances[origin]-fees)
+
+	//按相反顺序回滚交易
+	ts := *b.TransactionSlice
+	for i := len(ts) - 1; i >= 0; i-- {
+		t := ts[i]
+		if t.Header.Amount > 0 {
+			to := AccountOf(t.Header.To)
+			l.setBalance(to, l.balances[to]-t.Header.Amount)
+		}
+		from := AccountOf(t.Header.From)
+		l.setBalance(from, l.balances[from]+t.Cost())
+		delete(l.transactions, string(t.Hash()))
+	}
+}
+
+//余额为0的账户不保存
+func (l *AccountLedger) setBalance(key string, v uint64) {
+	if v == 0 {
+		delete(l.balances, key)
+		return
+	}
+	l.balances[key] = v
+}
+
+//将金额格式化为币数，如150000000格式化为1.5
+func FormatAmount(v uint64) string {
+	s := fmt.Sprintf("%d.%08d", v/COIN, v%COIN)
+	return strings.TrimRight(strings.TrimRight(s, "0"), ".")
+}
+
+//解析币数，最多8位小数，如1.5解析为150000000
+func ParseAmount(s string) (uint64, error) {
+	parts := strings.SplitN(s, ".", 2)
+	whole, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	frac := uint64(0)
+	if len(parts) == 2 {
+		if len(parts[1]) == 0 || len(parts[1]) > 8 {
+			return 0, errors.New("金额小数位数错误：" + s)
+		}
+		frac, err = strconv.ParseUint(parts[1]+strings.Repeat("0", 8-len(parts[1])), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if whole > (math.MaxUint64-frac)/COIN {
+		return 0, errors.New("金额过大：" + s)
+	}
+	return whole*COIN + frac, nil
+}
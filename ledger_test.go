@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+)
+
+//生成由miner记账、包含交易ts的区块，区块未经挖矿
+func newLedgerTestBlock(miner *Keypair, ts ...Transaction) Block {
+	b := NewBlock(nil)
+	b.BlockHeader.Origin = miner.Public
+	for _, t := range ts {
+		t := t
+		b.AddTransaction(&t)
+	}
+	return b
+}
+
+func TestLedgerConnectDisconnect(t *testing.T) {
+	miner, bob := newTestKeypair(), newTestKeypair()
+	l := NewAccountLedger()
+
+	b1 := newLedgerTestBlock(miner)
+	if err := l.ConnectBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	if l.Balance(miner.Public) != BLOCK_REWARD {
+		t.Error("区块奖励未记入记账者", l.Balance(miner.Public))
+	}
+
+	tr := NewTransferTransaction(miner.Public, bob.Public, 10*COIN, COIN, nil)
+	b2 := newLedgerTestBlock(bob, *tr)
+	if err := l.ConnectBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+	if l.Balance(miner.Public) != BLOCK_REWARD-11*COIN || l.Balance(bob.Public) != BLOCK_REWARD+11*COIN {
+		t.Error("转账后余额错误", l.Balance(miner.Public), l.Balance(bob.Public))
+	}
+	if !l.HasTransaction(tr.Hash()) {
+		t.Error("交易未记录")
+	}
+
+	//重放已打包的交易
+	if err := l.ConnectBlock(newLedgerTestBlock(bob, *tr)); err == nil {
+		t.Error("重放的交易不应连接成功")
+	}
+
+	l.DisconnectBlock(b2)
+	if l.Balance(miner.Public) != BLOCK_REWARD || l.Balance(bob.Public) != 0 || l.HasTransaction(tr.Hash()) {
+		t.Error("回滚区块后余额错误")
+	}
+}
+
+//转账给地址进入公钥所属的账户，可以用公钥花费
+func TestLedgerAddresses(t *testing.T) {
+	miner, bob := newTestKeypair(), newTestKeypair()
+	bobAddress := NewAddress(bob.Public)
+
+	l := NewAccountLedger()
+	b1 := newLedgerTestBlock(miner)
+	tr := NewTransferTransaction(miner.Public, bobAddress, 10*COIN, COIN, nil)
+	if err := l.ConnectBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.ConnectBlock(newLedgerTestBlock(miner, *tr)); err != nil {
+		t.Fatal(err)
+	}
+	if l.Balance(bob.Public) != 10*COIN || l.Balance(bobAddress) != 10*COIN || l.Balance(NewAddress(miner.Public)) != 2*BLOCK_REWARD-10*COIN {
+		t.Error("地址的余额错误", l.Balance(bob.Public), l.Balance(bobAddress))
+	}
+	if err := l.CheckTransaction(*NewTransferTransaction(bob.Public, miner.Public, 9*COIN, COIN, nil), 0); err != nil {
+		t.Error("应能花费转入地址的余额", err)
+	}
+
+	u := NewUTXOLedger()
+	if err := u.ConnectBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	pay := newTestUTXOTransaction(miner, []OutPoint{CoinbaseOutPoint(b1)},
+		[]TxOutput{{Amount: BLOCK_REWARD - COIN, To: bobAddress}}, COIN)
+	b2 := newLedgerTestBlock(miner, pay)
+	if err := u.ConnectBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+	if u.Balance(bob.Public) != BLOCK_REWARD-COIN || len(u.Unspent(bobAddress)) != 1 {
+		t.Error("锁定到地址的输出应属于公钥", u.Balance(bob.Public))
+	}
+	spend := newTestUTXOTransaction(bob, []OutPoint{{pay.Hash(), 0}}, []TxOutput{{Amount: BLOCK_REWARD - COIN, To: miner.Public}}, 0)
+	if err := u.CheckTransaction(spend, 0); err != nil {
+		t.Error("应能用公钥花费锁定到地址的输出", err)
+	}
+	steal := newTestUTXOTransaction(miner, []OutPoint{{pay.Hash(), 0}}, []TxOutput{{Amount: BLOCK_REWARD - COIN, To: miner.Public}}, 0)
+	if err := u.CheckTransaction(steal, 0); err == nil {
+		t.Error("其他公钥不能花费锁定到地址的输出")
+	}
+}
+
+func TestLedgerRejectsOverspend(t *testing.T) {
+	miner, bob := newTestKeypair(), newTestKeypair()
+	l := NewAccountLedger()
+	l.ConnectBlock(newLedgerTestBlock(miner))
+
+	t1 := NewTransferTransaction(miner.Public, bob.Public, BLOCK_REWARD, 0, nil)
+	t2 := NewTransferTransaction(miner.Public, bob.Public, 1, 0, []byte("2"))
+	if err := l.ConnectBlock(newLedgerTestBlock(bob, *t1, *t2)); err == nil {
+		t.Error("余额不足的区块不应连接成功")
+	}
+	if l.Balance(miner.Public) != BLOCK_REWARD || l.Balance(bob.Public) != 0 {
+		t.Error("连接失败后账本不应改变")
+	}
+
+	if err := l.CheckTransaction(*t1, 0); err != nil {
+		t.Error(err)
+	}
+	if err := l.CheckTransaction(*t2, BLOCK_REWARD); err == nil {
+		t.Error("余额不足的交易应被拒绝")
+	}
+}
+
+func TestMempoolRejectsOverspend(t *testing.T) {
+	miner, bob := newTestKeypair(), newTestKeypair()
+	l := NewAccountLedger()
+	l.ConnectBlock(newLedgerTestBlock(miner))
+	mp := NewMempool(l)
+
+	sign := func(tr *Transaction) Transaction {
+		tr.Header.Nonce = tr.GenerateNonce(TRANSACTION_POW)
+		tr.Signature = tr.Sign(miner)
+		return *tr
+	}
+	t1 := sign(NewTransferTransaction(miner.Public, bob.Public, 30*COIN, 0, nil))
+	t2 := sign(NewTransferTransaction(miner.Public, bob.Public, 30*COIN, 0, []byte("2")))
+	if err := mp.Add(t1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(t2); err == nil {
+		t.Error("交易池中交易总额超过余额时应拒绝")
+	}
+
+	//t1被打包后，t2可以加入
+	b := newLedgerTestBlock(bob, t1)
+	l.ConnectBlock(b)
+	mp.ApplyReorg(ReorgEvent{Connected: BlockSlice{b}})
+	if mp.Len() != 0 {
+		t.Error("已打包的交易未移除")
+	}
+	if err := mp.Add(t2); err == nil {
+		t.Error("余额不足的交易应被拒绝")
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for s, v := range map[string]uint64{"0": 0, "1": COIN, "1.5": COIN + COIN/2, "0.00000001": 1} {
+		if a, err := ParseAmount(s); err != nil || a != v {
+			t.Error("解析金额错误", s, a, err)
+		}
+		if FormatAmount(v) != s {
+			t.Error("格式化金额错误", v, FormatAmount(v))
+		}
+	}
+	for _, s := range []string{"", "-1", "1.", "0.000000001", "184467440738"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Error("应解析失败", s)
+		}
+	}
+}
+
+func TestBlockchainRejectsOverspendingBlock(t *testing.T) {
+	bc := NewBlockchain()
+	bs := newBranch(nil, 2, "ledger")
+	bs[1].AddTransaction(NewTransferTransaction([]byte("nobody"), []byte("bob"), 1, 0, nil))
+
+	if _, err := bc.AddBlock(bs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.AddBlock(bs[1]); err == nil {
+		t.Fatal("余额不足的区块不应加入主链")
+	}
+	if bc.Tree.Get(bs[1].Hash()) != nil || bc.Tree.Best.Height != 0 || len(bc.BlockSlice) != 1 {
+		t.Error("账本验证失败的区块应从区块树中移除")
+	}
+}
@@ -3,67 +3,280 @@ package main
 
 import (
 	"bufio"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 var (
-	//flags
-	address = flag.String("ip", fmt.Sprintf("%s:%s", GetIpAddress()[0], BLOCKCHAIN_PORT), "Public facing ip address")
-	self    = struct {
-		*Keypair
+	//node flags
+	nodeFlags      = flag.NewFlagSet("node", flag.ExitOnError)
+	address        = nodeFlags.String("ip", "", "Public facing ip address, defaults to the first local ip and the network's port")
+	network        = nodeFlags.String("network", "mainnet", "Network: mainnet, testnet or regtest")
+	rpcAddress     = nodeFlags.String("rpc", "", "Address of the HTTP JSON API, defaults to 127.0.0.1 and the network's API port; \"off\" disables it")
+	apiSend        = nodeFlags.Bool("apisend", false, "Enable POST /send, which spends from the node's wallet")
+	persistMempool = nodeFlags.Bool("mempool", true, "Dump mempool to disk on shutdown and restore it on startup")
+	ledgerMode     = nodeFlags.String("ledger", LEDGER_MODE_ACCOUNT, "Ledger mode: account or utxo")
+	spv            = nodeFlags.Bool("spv", false, "Run as a header-only light node that verifies own transactions with Merkel proofs")
+	self           = struct {
+		*Keypair          //默认交易密钥
+		Miner    *Keypair //挖矿密钥，区块的记账者
+		Wallet   *Wallet
 		*Blockchain
 		*Network
 	}{}
 )
 
-func init() {
-	flag.Parse()
+//第一个参数为子命令，没有子命令或以"-"开头时运行节点
+func main() {
+	name, args := "node", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := FindCommand(name)
+	if cmd == nil {
+		fmt.Fprintln(os.Stderr, "未知的子命令：", name)
+		PrintUsage()
+		os.Exit(2)
+	}
+	if err := cmd.Run(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func main() {
+//运行节点，直到收到退出信号
+func RunNode(args []string) error {
+	nodeFlags.Parse(args)
+	if err := SelectParams(*network); err != nil {
+		return err
+	}
+	if *address == "" {
+		*address = fmt.Sprintf("%s:%s", GetIpAddress()[0], chainParams.Port)
+	}
+	if *rpcAddress == "" {
+		*rpcAddress = chainParams.APIAddress()
+	}
+
 	//Setup keys
-	keypair, _ := OpenConfiguration(HOME_DIRECTORY_CONFIG)
-	if keypair == nil {
+	wallet, err := OpenWallet(HOME_DIRECTORY_CONFIG, PromptPassphrase(KEYSTORE_PASSPHRASE_ENV))
+	if err != nil {
+		return errors.New("打开钱包失败：" + err.Error())
+	}
+	//钱包为空时生成助记词，由助记词派生第一个密钥
+	if len(wallet.Names()) == 0 {
 		fmt.Println("生成密钥对。。。。")
-		keypair = GenerateNewKeypair()
-		WriteConfiguration(HOME_DIRECTORY_CONFIG, keypair)
+		mnemonic := ""
+		if !wallet.HasMnemonic() {
+			if mnemonic, err = NewMnemonic(HD_MNEMONIC_WORDS); err == nil {
+				err = wallet.InitMnemonic(mnemonic)
+			}
+		}
+		if err == nil {
+			_, err = wallet.Generate(WALLET_DEFAULT_KEY, SCHEME_P224)
+		}
+		if err != nil {
+			return errors.New("保存密钥失败：" + err.Error())
+		}
+		if mnemonic != "" {
+			printMnemonic(mnemonic)
+		}
+	}
+	if err := wallet.Unlock(); err != nil {
+		return errors.New("解锁钱包失败：" + err.Error())
 	}
-	self.Keypair = keypair
+	self.Wallet = wallet
+	self.Keypair, _ = wallet.Keypair(wallet.Default)
+	self.Miner, _ = wallet.Keypair(wallet.Mining)
+	fmt.Printf("交易密钥：%s，挖矿密钥：%s\n", wallet.Default, wallet.Mining)
 
 	//Setup Network
-	self.Network = SetupNetwork(*address, BLOCKCHAIN_PORT)
+	self.Network = SetupNetwork(*address, chainParams.Port)
+	book, err := OpenAddressBook(HOME_DIRECTORY_CONFIG)
+	logOnError(err)
+	self.Network.AddressBook = book
+
+	//Setup blockchain
+	var blockchain *Blockchain
+	if *spv {
+		blockchain, err = SetupHeaderChain(HOME_DIRECTORY_CONFIG)
+	} else {
+		ledger, lerr := NewLedger(*ledgerMode)
+		if lerr != nil {
+			return lerr
+		}
+		blockchain, err = SetupBlockChain(HOME_DIRECTORY_CONFIG, ledger)
+	}
+	if err != nil {
+		return errors.New("打开区块文件失败：" + err.Error())
+	}
+	self.Blockchain = blockchain
+	if *persistMempool && blockchain.Mempool != nil {
+		n, err := blockchain.Mempool.Restore(HOME_DIRECTORY_CONFIG)
+		logOnError(err)
+		fmt.Println("已恢复交易池：", n)
+		blockchain.CurrentBlock = blockchain.CreateNewBlock()
+	}
+
 	go self.Network.Run()
-	for _, n := range SEED_NODES() {
+	//优先连接地址簿中最近连接成功的节点，再连接种子节点
+	for _, n := range append(book.Sample(MAX_PEERS, false), chainParams.SeedNodes...) {
 		self.Network.ConnectionsQueue <- n
 	}
-
-	//Setup blockchain
-	self.Blockchain = SetupBlockChain()
-	go self.Blockchain.Run()
+	if blockchain.HeadersOnly {
+		go self.Blockchain.RunHeaders()
+	} else {
+		go self.Blockchain.Run()
+	}
+	go self.Blockchain.Sync.Run()
+	if *rpcAddress != "off" {
+		go StartAPI(*rpcAddress, HOME_DIRECTORY_CONFIG, *apiSend)
+	}
 
 	//Read Stdin to create transations
 	stdin := ReadStdin()
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	for {
 		select {
 		case str := <-stdin:
-			self.Blockchain.TransactionsQueue <- CreateTransaction(str)
+			HandleCommand(str)
 		case msg := <-self.Network.IncomingMessages:
 			HandleIncomingMessage(msg)
+		case ev := <-self.Blockchain.ReorgEvents:
+			fmt.Printf("区块链重组：分叉点 %x，回滚 %d 个区块，连接 %d 个区块\n", ev.Fork, len(ev.Disconnected), len(ev.Connected))
+		case <-quit:
+			Shutdown()
+			return nil
 		}
 	}
 }
 
-func CreateTransaction(txt string) *Transaction {
-	t := NewTransaction(self.Keypair.Public, nil, []byte(txt))
+//退出前保存交易池和地址簿，关闭区块文件
+func Shutdown() {
+	fmt.Println("正在退出......")
+	if *persistMempool && self.Blockchain.Mempool != nil {
+		logOnError(self.Blockchain.Mempool.Dump(HOME_DIRECTORY_CONFIG))
+	}
+	if self.Network.AddressBook != nil {
+		logOnError(self.Network.AddressBook.Save())
+	}
+	self.Blockchain.mutex.Lock()
+	logOnError(self.Blockchain.Store.Close())
+}
+
+//处理标准输入的命令：
+//send <地址|公钥> <金额> [手续费] 转账，balance [地址|公钥] 查询余额，
+//proof <交易哈希值> 向网络请求交易证明，txs 列出轻节点跟踪的交易，其他输入作为交易数据
+func HandleCommand(str string) {
+	args := strings.Fields(str)
+	switch {
+	case len(args) > 0 && args[0] == "balance":
+		if self.Blockchain.Ledger == nil {
+			fmt.Println("轻节点没有账本，不能查询余额")
+			return
+		}
+		public := self.Keypair.Public
+		if len(args) > 1 {
+			public = []byte(args[1])
+		}
+		fmt.Printf("余额：%s\n", FormatAmount(self.Blockchain.Ledger.Balance(public)))
+	case len(args) == 2 && args[0] == "proof":
+		hash, err := hex.DecodeString(args[1])
+		if err != nil || len(hash) != 32 {
+			fmt.Println("交易哈希值格式错误")
+			return
+		}
+		mes := NewMessage(MESSAGE_GET_PROOF)
+		mes.Data = hash
+		self.Network.BroadcastQueue <- *mes
+	case len(args) == 1 && args[0] == "txs" && self.Blockchain.Tracker != nil:
+		for _, tt := range self.Blockchain.Tracker.List() {
+			if tt.BlockHash == nil {
+				fmt.Printf("%x 未确认\n", tt.Transaction.Hash())
+			} else {
+				fmt.Printf("%x 已确认，区块 %x\n", tt.Transaction.Hash(), tt.BlockHash)
+			}
+		}
+	case len(args) >= 3 && len(args) <= 4 && args[0] == "send":
+		amount, err := ParseAmount(args[2])
+		fee := uint64(0)
+		if err == nil && len(args) == 4 {
+			fee, err = ParseAmount(args[3])
+		}
+		if err != nil {
+			fmt.Println("金额格式错误：", err)
+			return
+		}
+		to, err := ParseRecipient(args[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		t, err := CreateTransferTransaction(self.Keypair, to, amount, fee, nil)
+		if err != nil {
+			fmt.Println("创建转账交易失败：", err)
+			return
+		}
+		self.Blockchain.TransactionsQueue <- t
+	default:
+		self.Blockchain.TransactionsQueue <- CreateTransaction(self.Keypair, str)
+	}
+}
+
+//使用keypair创建并签名只包含数据的交易
+func CreateTransaction(keypair *Keypair, txt string) *Transaction {
+	t := NewTransaction(keypair.Public, nil, []byte(txt))
 	t.Header.Nonce = t.GenerateNonce(TRANSACTION_POW)
-	t.Signature = t.Sign(self.Keypair)
+	t.Signature = t.Sign(keypair)
 	return t
 }
 
+//使用keypair创建转账交易，UTXO模式下从未被交易池花费的输出中选取输入，找零给自己
+func CreateTransferTransaction(keypair *Keypair, to []byte, amount, fee uint64, payload []byte) (*Transaction, error) {
+	t, err := BuildTransferTransaction(keypair.Public, to, amount, fee, payload)
+	if err != nil {
+		return nil, err
+	}
+	t.Signature = t.Sign(keypair)
+	return t, nil
+}
+
+//创建from发出的未签名转账交易并计算工作量，用于多重签名等由其他人签名的交易
+func BuildTransferTransaction(from, to []byte, amount, fee uint64, payload []byte) (*Transaction, error) {
+	t := NewTransferTransaction(from, to, amount, fee, payload)
+	if l, ok := self.Blockchain.Ledger.(*UTXOLedger); ok {
+		if amount+fee < amount {
+			return nil, errors.New("金额过大")
+		}
+		ins, change, err := l.SelectInputs(from, amount+fee, self.Blockchain.Mempool.IsSpent)
+		if err != nil {
+			return nil, err
+		}
+		outs := []TxOutput{{Amount: amount, To: to}}
+		if change > 0 {
+			outs = append(outs, TxOutput{Amount: change, To: from})
+		}
+		t = NewUTXOTransaction(from, ins, outs, fee, payload)
+	}
+	t.Header.Nonce = t.GenerateNonce(TRANSACTION_POW)
+	return t, nil
+}
+
 //处理传入信息：交易信息和区块信息
 func HandleIncomingMessage(msg Message) {
+	//轻节点不转发别人的交易，也不提供区块和交易证明
+	if self.Blockchain.HeadersOnly {
+		switch msg.Identifier {
+		case MESSAGE_SEND_TRANSACTION, MESSAGE_GET_BLOCK, MESSAGE_GET_BLOCKS, MESSAGE_GET_HEADERS, MESSAGE_GET_PROOF, MESSAGE_GET_TX_PROOFS:
+			return
+		}
+	}
 	switch msg.Identifier {
 	case MESSAGE_SEND_TRANSACTION:
 		t := new(Transaction)
@@ -81,6 +294,30 @@ func HandleIncomingMessage(msg Message) {
 			break
 		}
 		self.Blockchain.BlocksQueue <- *b
+	case MESSAGE_GET_BLOCK:
+		b := self.Blockchain.GetBlock(msg.Data)
+		if b == nil || msg.Reply == nil {
+			break
+		}
+		reply := NewMessage(MESSAGE_SEND_BLOCK)
+		reply.Data, _ = b.MarshalBinary()
+		msg.Respond(*reply)
+	case MESSAGE_GET_BLOCKS:
+		HandleGetBlocks(msg)
+	case MESSAGE_SEND_BLOCKS, MESSAGE_SEND_HEADERS:
+		self.Blockchain.Sync.HandleBlocks(msg)
+	case MESSAGE_GET_HEADERS:
+		HandleGetHeaders(msg)
+	case MESSAGE_GET_NODES:
+		self.Network.HandleGetNodes(msg)
+	case MESSAGE_SEND_NODES:
+		self.Network.HandleSendNodes(msg)
+	case MESSAGE_GET_PROOF:
+		HandleGetProof(msg)
+	case MESSAGE_SEND_PROOF:
+		HandleProof(msg)
+	case MESSAGE_GET_TX_PROOFS:
+		HandleGetTxProofs(msg)
 	}
 }
 
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+//交易池中的交易
+type mempoolEntry struct {
+	Transaction
+	hash []byte
+	size int //序列化后的字节数
+}
+
+//交易池，保存已验证、尚未打包的交易
+//交易池有数量和字节数限制，已满时优先级低的交易被移除；交易时间过早的交易过期移除
+//同一发送方在交易池中的交易总额不能超过其在账本中的余额，同一输出不能被交易池中的两个交易花费
+type Mempool struct {
+	entries map[string]*mempoolEntry
+	size    int               //所有交易的字节数
+	pending map[string]uint64 //发送方在交易池中的交易总额
+	spends  map[string][]byte //交易池中交易花费的输出 -> 交易哈希值
+	ledger  Ledger            //为nil时不检查余额
+
+	maxCount int
+	maxSize  int
+
+	mutex sync.RWMutex
+}
+
+//新建交易池
+func NewMempool(ledger Ledger) *Mempool {
+	return &Mempool{
+		entries:  map[string]*mempoolEntry{},
+		pending:  map[string]uint64{},
+		spends:   map[string][]byte{},
+		ledger:   ledger,
+		maxCount: MEMPOOL_MAX_COUNT,
+		maxSize:  MEMPOOL_MAX_SIZE,
+	}
+}
+
+//交易数量
+func (mp *Mempool) Len() int {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	return len(mp.entries)
+}
+
+//检查交易是否在交易池中
+func (mp *Mempool) Exists(hash []byte) bool {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	return mp.entries[string(hash)] != nil
+}
+
+//根据哈希值获取交易
+func (mp *Mempool) Get(hash []byte) *Transaction {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	if e := mp.entries[string(hash)]; e != nil {
+		t := e.Transaction
+		return &t
+	}
+	return nil
+}
+
+//验证并添加交易，交易池已满时移除优先级最低的交易，新交易优先级最低时拒绝
+func (mp *Mempool) Add(t Transaction) error {
+	if !t.VerifyTransaction(TRANSACTION_POW) {
+		return errors.New("交易验证失败")
+	}
+	now := time.Now().Unix()
+	if int64(t.Header.TimeStamp) < now-MEMPOOL_EXPIRY {
+		return errors.New("交易已过期")
+	}
+	if int64(t.Header.TimeStamp) > now+MEMPOOL_MAX_FUTURE_TIME {
+		return errors.New("交易时间超前")
+	}
+	d, err := t.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	e := &mempoolEntry{Transaction: t, hash: t.Hash(), size: len(d)}
+	if mp.entries[string(e.hash)] != nil {
+		return errors.New("交易已存在")
+	}
+	if e.size > mp.maxSize {
+		return errors.New("交易过大")
+	}
+	for _, in := range t.Inputs {
+		if mp.spends[in.Key()] != nil {
+			return fmt.Errorf("双重花费：输出 %x:%d 已被交易池中的交易花费", in.Hash, in.Index)
+		}
+	}
+	if mp.ledger != nil {
+		if err := mp.ledger.CheckTransaction(t, mp.pending[string(t.Header.From)]); err != nil {
+			return err
+		}
+	}
+	for len(mp.entries) >= mp.maxCount || mp.size+e.size > mp.maxSize {
+		lowest := mp.sorted()[len(mp.entries)-1]
+		if !mp.higherPriority(e, lowest) {
+			return errors.New("交易池已满")
+		}
+		mp.remove(lowest.hash)
+	}
+
+	mp.entries[string(e.hash)] = e
+	mp.size += e.size
+	mp.pending[string(e.Header.From)] += e.Cost()
+	for _, in := range t.Inputs {
+		mp.spends[in.Key()] = e.hash
+	}
+	return nil
+}
+
+//检查输出是否已被交易池中的交易花费
+func (mp *Mempool) IsSpent(op OutPoint) bool {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	return mp.spends[op.Key()] != nil
+}
+
+//移除交易
+func (mp *Mempool) Remove(hash []byte) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	mp.remove(hash)
+}
+
+//移除已被打包进区块的交易
+func (mp *Mempool) RemoveBlock(b Block) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	for _, t := range *b.TransactionSlice {
+		mp.remove(t.Hash())
+	}
+}
+
+//根据主链变化更新交易池：回滚区块中的交易重新放入，新连接区块中的交易移除
+//账本须已更新到新的主链，余额不再足够的交易被移除
+func (mp *Mempool) ApplyReorg(event ReorgEvent) {
+	for _, b := range event.Disconnected {
+		for _, t := range *b.TransactionSlice {
+			mp.Add(t)
+		}
+	}
+	for _, b := range event.Connected {
+		mp.RemoveBlock(b)
+	}
+	mp.Revalidate()
+}
+
+//按优先级重新检查每个交易的余额，移除已打包或余额不足的交易
+func (mp *Mempool) Revalidate() {
+	if mp.ledger == nil {
+		return
+	}
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	pending := map[string]uint64{}
+	for _, e := range mp.sorted() {
+		from := string(e.Header.From)
+		if mp.ledger.CheckTransaction(e.Transaction, pending[from]) != nil {
+			mp.remove(e.hash)
+			continue
+		}
+		pending[from] += e.Cost()
+	}
+	mp.pending = pending
+}
+
+//移除过期的交易
+func (mp *Mempool) Expire(now time.Time) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	for _, e := range mp.entries {
+		if int64(e.Header.TimeStamp) < now.Unix()-MEMPOOL_EXPIRY {
+			mp.remove(e.hash)
+		}
+	}
+}
+
+//按优先级选取最多max个交易
+func (mp *Mempool) Select(max int) TransactionSlice {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+
+	ts := TransactionSlice{}
+	for _, e := range mp.sorted() {
+		if len(ts) >= max {
+			break
+		}
+		ts = append(ts, e.Transaction)
+	}
+	return ts
+}
+
+//将交易池保存到文件，先写入临时文件再重命名，写入中断时不损坏原文件
+func (mp *Mempool) Dump(dir string) error {
+	d, err := mp.Select(mp.maxCount).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	dir = getDirectoryWithBaseDir(dir)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	file := path.Join(dir, BLOCKCHAIN_MEMPOOL_FILENAME)
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, d, 0660); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+//从文件恢复交易池，重新验证每个交易，返回恢复的交易数
+func (mp *Mempool) Restore(dir string) (int, error) {
+	file := path.Join(getDirectoryWithBaseDir(dir), BLOCKCHAIN_MEMPOOL_FILENAME)
+	d, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	ts := new(TransactionSlice)
+	if err := ts.UnmarshalBinary(d); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, t := range *ts {
+		if mp.Add(t) == nil {
+			n++
+		}
+	}
+	return n, os.Remove(file)
+}
+
+func (mp *Mempool) remove(hash []byte) {
+	if e := mp.entries[string(hash)]; e != nil {
+		delete(mp.entries, string(hash))
+		mp.size -= e.size
+		for _, in := range e.Inputs {
+			delete(mp.spends, in.Key())
+		}
+		if from := string(e.Header.From); mp.pending[from] > e.Cost() {
+			mp.pending[from] -= e.Cost()
+		} else {
+			delete(mp.pending, from)
+		}
+	}
+}
+
+//按优先级从高到低排序的交易
+func (mp *Mempool) sorted() []*mempoolEntry {
+	es := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, e := range mp.entries {
+		es = append(es, e)
+	}
+	sort.Slice(es, func(i, j int) bool { return mp.higherPriority(es[i], es[j]) })
+	return es
+}
+
+//交易优先级：每字节手续费高的交易优先，相同时时间早的交易优先
+func (mp *Mempool) higherPriority(a, b *mempoolEntry) bool {
+	ra, rb := float64(a.Header.Fee)/float64(a.size), float64(b.Header.Fee)/float64(b.size)
+	if ra != rb {
+		return ra > rb
+	}
+	if a.Header.TimeStamp != b.Header.TimeStamp {
+		return a.Header.TimeStamp < b.Header.TimeStamp
+	}
+	return bytes.Compare(a.hash, b.hash) < 0
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+//生成测试使用的密钥对，规范编码的密钥签名总能验证，不需要重试
+func newTestKeypair() *Keypair {
+	return GenerateNewKeypair()
+}
+
+//生成已签名的交易
+func newTestTransaction(kp *Keypair, timeStamp uint32) Transaction {
+	tr := NewTransaction(kp.Public, nil, []byte(RandomString(RandomInt(1, 256))))
+	tr.Header.TimeStamp = timeStamp
+	tr.Header.Nonce = tr.GenerateNonce(TRANSACTION_POW)
+	tr.Signature = tr.Sign(kp)
+	return *tr
+}
+
+func TestMempoolAdd(t *testing.T) {
+	kp := newTestKeypair()
+	mp := NewMempool(nil)
+	now := uint32(time.Now().Unix())
+
+	tr := newTestTransaction(kp, now)
+	if err := mp.Add(tr); err != nil {
+		t.Fatal(err)
+	}
+	if !mp.Exists(tr.Hash()) || mp.Get(tr.Hash()) == nil || mp.Len() != 1 {
+		t.Error("交易未加入交易池")
+	}
+	if err := mp.Add(tr); err == nil {
+		t.Error("重复的交易不应加入交易池")
+	}
+
+	invalid := newTestTransaction(kp, now)
+	invalid.Signature = tr.Signature
+	if err := mp.Add(invalid); err == nil {
+		t.Error("签名错误的交易不应加入交易池")
+	}
+	if err := mp.Add(newTestTransaction(kp, now-MEMPOOL_EXPIRY-1)); err == nil {
+		t.Error("过期的交易不应加入交易池")
+	}
+}
+
+func TestMempoolEviction(t *testing.T) {
+	kp := newTestKeypair()
+	mp := NewMempool(nil)
+	mp.maxCount = 2
+	now := uint32(time.Now().Unix())
+
+	t1, t2, t3 := newTestTransaction(kp, now-20), newTestTransaction(kp, now-10), newTestTransaction(kp, now)
+	mp.Add(t2)
+	mp.Add(t3)
+	if err := mp.Add(t1); err != nil {
+		t.Fatal("优先级更高的交易应替换优先级最低的交易", err)
+	}
+	if mp.Len() != 2 || mp.Exists(t3.Hash()) {
+		t.Error("未移除优先级最低的交易")
+	}
+	if err := mp.Add(t3); err == nil {
+		t.Error("交易池已满时优先级最低的交易不应加入")
+	}
+
+	ts := mp.Select(BLOCK_MAX_TRANSACTIONS)
+	if len(ts) != 2 || ts[0].Header.TimeStamp != t1.Header.TimeStamp {
+		t.Error("交易未按优先级排序")
+	}
+}
+
+func TestMempoolExpire(t *testing.T) {
+	kp := newTestKeypair()
+	mp := NewMempool(nil)
+	tr := newTestTransaction(kp, uint32(time.Now().Unix()))
+	mp.Add(tr)
+
+	mp.Expire(time.Now())
+	if mp.Len() != 1 {
+		t.Error("未过期的交易被移除")
+	}
+	mp.Expire(time.Now().Add((MEMPOOL_EXPIRY + 1) * time.Second))
+	if mp.Len() != 0 {
+		t.Error("过期的交易未移除")
+	}
+}
+
+func TestMempoolReorg(t *testing.T) {
+	kp := newTestKeypair()
+	mp := NewMempool(nil)
+	now := uint32(time.Now().Unix())
+	t1, t2 := newTestTransaction(kp, now), newTestTransaction(kp, now)
+
+	connected := NewBlock(nil)
+	connected.AddTransaction(&t1)
+	disconnected := NewBlock(nil)
+	disconnected.AddTransaction(&t2)
+
+	mp.Add(t1)
+	mp.RemoveBlock(connected)
+	if mp.Exists(t1.Hash()) {
+		t.Error("已打包的交易未移除")
+	}
+
+	mp.Add(t1)
+	mp.ApplyReorg(ReorgEvent{Disconnected: BlockSlice{disconnected}, Connected: BlockSlice{connected}})
+	if mp.Exists(t1.Hash()) || !mp.Exists(t2.Hash()) {
+		t.Error("回滚的交易未放回交易池")
+	}
+}
+
+func TestMempoolDumpRestore(t *testing.T) {
+	dir := t.TempDir()
+	kp := newTestKeypair()
+	mp := NewMempool(nil)
+	now := uint32(time.Now().Unix())
+	t1, t2 := newTestTransaction(kp, now), newTestTransaction(kp, now-1)
+	mp.Add(t1)
+	mp.Add(t2)
+	if err := mp.Dump(dir); err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(getDirectoryWithBaseDir(dir), BLOCKCHAIN_MEMPOOL_FILENAME)
+	if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+		t.Error("保存后不应留下临时文件", err)
+	}
+
+	restored := NewMempool(nil)
+	n, err := restored.Restore(dir)
+	if err != nil || n != 2 {
+		t.Fatal("恢复交易池失败", n, err)
+	}
+	if !restored.Exists(t1.Hash()) || !restored.Exists(t2.Hash()) {
+		t.Error("恢复的交易不一致")
+	}
+	if n, err := NewMempool(nil).Restore(dir); n != 0 || err != nil {
+		t.Error("恢复后应删除交易池文件", n, err)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+//Merkel树内部节点和叶子的哈希值前缀，防止内部节点被当作叶子(第2版)
+const (
+	merkelLeafPrefix = 0x00
+	merkelNodePrefix = 0x01
+)
+
+//当前网络中高度为height的区块使用的Merkel树版本
+func MerkelVersion(height int) byte {
+	return chainParams.MerkelVersion(height)
+}
+
+//按版本根据交易哈希值计算Merkel根值
+func MerkelRoot(hashes [][]byte, version byte) []byte {
+	if version == MERKEL_VERSION_2 {
+		return merkelRootV2(hashes)
+	}
+	return merkelRootV1(hashes)
+}
+
+//第1版Merkel根值：个数为偶数时两两合并；个数为奇数时，前面的叶子合并成一个节点，再与最后一个叶子合并
+func merkelRootV1(hashes [][]byte) []byte {
+	var merkel func(hashes [][]byte) []byte
+	merkel = func(hashes [][]byte) []byte {
+
+		l := len(hashes)
+		if l == 0 {
+			return nil
+		}
+		if l == 1 {
+			return hashes[0]
+		} else {
+			if l%2 == 1 {
+				return merkel([][]byte{merkel(hashes[:l-1]), hashes[l-1]})
+			}
+
+			bs := make([][]byte, l/2)
+			for i, _ := range bs {
+				j, k := i*2, (i*2)+1
+				bs[i] = SHA256(append(hashes[j], hashes[k]...))
+			}
+			return merkel(bs)
+		}
+	}
+	return merkel(hashes)
+}
+
+//第2版Merkel根值，与RFC 6962相同：叶子为SHA256(0x00 + 交易哈希值)，内部节点为SHA256(0x01 + 左 + 右)，
+//n个叶子时前k个叶子为左子树，k为小于n的最大2的幂；没有交易时为SHA256(空)
+func merkelRootV2(hashes [][]byte) []byte {
+	switch len(hashes) {
+	case 0:
+		return SHA256(nil)
+	case 1:
+		return merkelLeaf(hashes[0])
+	}
+	k := merkelSplit(len(hashes))
+	return merkelNode(merkelRootV2(hashes[:k]), merkelRootV2(hashes[k:]))
+}
+
+//小于n的最大2的幂，n>1
+func merkelSplit(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+func merkelLeaf(hash []byte) []byte {
+	return SHA256(append([]byte{merkelLeafPrefix}, hash...))
+}
+
+func merkelNode(left, right []byte) []byte {
+	return SHA256(append(append([]byte{merkelNodePrefix}, left...), right...))
+}
+
+//Merkel路径上的一步：兄弟节点的哈希值，以及兄弟节点是否在左边
+type MerkelStep struct {
+	Hash []byte
+	Left bool
+}
+
+//交易包含在区块中的证明
+//从交易哈希值开始，依次与路径上的兄弟节点合并，结果应等于区块头部的Merkel根值
+type MerkelProof struct {
+	Header      *BlockHeader //包含交易的区块头部
+	Version     byte         //区块使用的Merkel树版本，由区块高度决定
+	TxHash      []byte       //交易哈希值
+	Branch      []MerkelStep //从叶子到根的路径
+	Transaction *Transaction //交易内容，可以为空
+}
+
+//按版本生成交易在区块中的Merkel证明，交易不在区块中时返回错误
+func (b *Block) MerkelProof(txHash []byte, version byte) (*MerkelProof, error) {
+	hashes := [][]byte{}
+	index := -1
+	for i, t := range *b.TransactionSlice {
+		h := t.Hash()
+		if index < 0 && bytes.Equal(h, txHash) {
+			index = i
+		}
+		hashes = append(hashes, h)
+	}
+	if index < 0 {
+		return nil, errors.New("交易不在区块中")
+	}
+	t := (*b.TransactionSlice)[index]
+	p := &MerkelProof{Header: b.BlockHeader, Version: version, TxHash: txHash, Transaction: &t}
+	p.Branch = MerkelBranch(hashes, index, version)
+	return p, nil
+}
+
+//按版本生成第index个叶子的Merkel路径，与MerkelRoot的构造方式一致
+func MerkelBranch(hashes [][]byte, index int, version byte) []MerkelStep {
+	if version == MERKEL_VERSION_2 {
+		return merkelBranchV2(hashes, index)
+	}
+	return merkelBranchV1(hashes, index)
+}
+
+func merkelBranchV1(hashes [][]byte, index int) []MerkelStep {
+	l := len(hashes)
+	if l <= 1 {
+		return nil
+	}
+	if l%2 == 1 {
+		last := hashes[l-1]
+		if index == l-1 {
+			return []MerkelStep{{merkelRootV1(hashes[:l-1]), true}}
+		}
+		return append(merkelBranchV1(hashes[:l-1], index), MerkelStep{last, false})
+	}
+
+	bs := make([][]byte, l/2)
+	for i := range bs {
+		bs[i] = SHA256(append(append([]byte{}, hashes[i*2]...), hashes[i*2+1]...))
+	}
+	step := MerkelStep{hashes[index^1], index%2 == 1}
+	return append([]MerkelStep{step}, merkelBranchV1(bs, index/2)...)
+}
+
+func merkelBranchV2(hashes [][]byte, index int) []MerkelStep {
+	if len(hashes) <= 1 {
+		return nil
+	}
+	k := merkelSplit(len(hashes))
+	if index < k {
+		return append(merkelBranchV2(hashes[:k], index), MerkelStep{merkelRootV2(hashes[k:]), false})
+	}
+	return append(merkelBranchV2(hashes[k:], index-k), MerkelStep{merkelRootV2(hashes[:k]), true})
+}
+
+//验证交易的Merkel证明是否与区块头部的Merkel根值一致，包含交易内容时还验证交易哈希值
+func (p *MerkelProof) Verify() bool {
+	if p.Header == nil || len(p.TxHash) != 32 {
+		return false
+	}
+	if p.Transaction != nil && !bytes.Equal(p.Transaction.Hash(), p.TxHash) {
+		return false
+	}
+
+	var h []byte
+	var node func(left, right []byte) []byte
+	switch p.Version {
+	case MERKEL_VERSION_1:
+		h = p.TxHash
+		node = func(left, right []byte) []byte { return SHA256(append(append([]byte{}, left...), right...)) }
+	case MERKEL_VERSION_2:
+		h = merkelLeaf(p.TxHash)
+		node = merkelNode
+	default:
+		return false
+	}
+	for _, s := range p.Branch {
+		if s.Left {
+			h = node(s.Hash, h)
+		} else {
+			h = node(h, s.Hash)
+		}
+	}
+	return bytes.Equal(h, p.Header.MerkelRoot)
+}
+
+//序列化Merkel证明：区块头部 + 版本(1字节) + 交易哈希值(32字节) + 步数(1字节) + 每步 方向(1字节) + 哈希值(32字节) [+ 交易]
+func (p *MerkelProof) MarshalBinary() ([]byte, error) {
+	if len(p.Branch) > 255 {
+		return nil, errors.New("Merkel路径过长")
+	}
+	header, err := p.Header.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(header)
+	buf.WriteByte(p.Version)
+	buf.Write(FitBytesInto(p.TxHash, 32))
+	buf.WriteByte(byte(len(p.Branch)))
+	for _, s := range p.Branch {
+		left := byte(0)
+		if s.Left {
+			left = 1
+		}
+		buf.WriteByte(left)
+		buf.Write(FitBytesInto(s.Hash, 32))
+	}
+	if p.Transaction != nil {
+		d, err := p.Transaction.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(d)
+	}
+	return buf.Bytes(), nil
+}
+
+//反序列化Merkel证明
+func (p *MerkelProof) UnmarshalBinary(d []byte) error {
+	if len(d) < BLOCK_HEADER_SIZE+1+32+1 {
+		return errors.New("Merkel证明长度不足")
+	}
+	buf := bytes.NewBuffer(d)
+	p.Header = new(BlockHeader)
+	if err := p.Header.readBinary(buf); err != nil {
+		return err
+	}
+	if buf.Len() < 1+32+1 {
+		return errors.New("Merkel证明长度不足")
+	}
+	p.Version, _ = buf.ReadByte()
+	p.TxHash = buf.Next(32)
+	n, _ := buf.ReadByte()
+	if buf.Len() < int(n)*(1+32) {
+		return errors.New("Merkel证明长度错误")
+	}
+	p.Branch = nil
+	for i := 0; i < int(n); i++ {
+		left, _ := buf.ReadByte()
+		p.Branch = append(p.Branch, MerkelStep{Hash: buf.Next(32), Left: left == 1})
+	}
+
+	p.Transaction = nil
+	if buf.Len() > 0 {
+		t := new(Transaction)
+		rem, err := t.UnmarshalBinary(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if len(rem) != 0 {
+			return errors.New("Merkel证明长度错误")
+		}
+		p.Transaction = t
+	}
+	return nil
+}
+
+//回复交易证明请求，请求数据为 交易哈希值(32字节) [+ 区块哈希值(32字节)]
+//未指定区块时在主链上查找交易，找不到交易时不回复
+func HandleGetProof(msg Message) {
+	if msg.Reply == nil || (len(msg.Data) != 32 && len(msg.Data) != 64) {
+		return
+	}
+	var b *Block
+	if len(msg.Data) == 64 {
+		b = self.Blockchain.GetBlock(msg.Data[32:])
+	} else {
+		b = self.Blockchain.FindTransaction(msg.Data[:32])
+	}
+	if b == nil {
+		return
+	}
+	proof, err := b.MerkelProof(msg.Data[:32], MerkelVersion(self.Blockchain.Height(b.Hash())))
+	if err != nil {
+		return
+	}
+
+	reply := NewMessage(MESSAGE_SEND_PROOF)
+	reply.Data, err = proof.MarshalBinary()
+	if err != nil {
+		networkError(err)
+		return
+	}
+	msg.Respond(*reply)
+}
+
+//回复与公钥相关的交易证明请求，请求数据为公钥，每个交易回复一个MESSAGE_SEND_PROOF
+func HandleGetTxProofs(msg Message) {
+	if msg.Reply == nil || len(msg.Data) == 0 {
+		return
+	}
+	for _, hash := range self.Blockchain.KeyTransactions(msg.Data, MAX_TX_PROOFS) {
+		b := self.Blockchain.FindTransaction(hash)
+		if b == nil {
+			continue
+		}
+		proof, err := b.MerkelProof(hash, MerkelVersion(self.Blockchain.Height(b.Hash())))
+		if err != nil {
+			continue
+		}
+		reply := NewMessage(MESSAGE_SEND_PROOF)
+		if reply.Data, err = proof.MarshalBinary(); err != nil {
+			networkError(err)
+			continue
+		}
+		msg.Respond(*reply)
+	}
+}
+
+//处理收到的交易证明，验证后输出结果，轻节点记录自己的交易
+//本地有该区块时，证明的Merkel树版本须与区块高度一致
+func HandleProof(msg Message) {
+	p := new(MerkelProof)
+	if err := p.UnmarshalBinary(msg.Data); err != nil {
+		networkError(err)
+		return
+	}
+	blockHash := p.Header.Hash()
+	height := self.Blockchain.Height(blockHash)
+	if !p.Verify() || !CheckBlockProofofWork(p.Header.Bits, blockHash) || (height >= 0 && p.Version != MerkelVersion(height)) {
+		fmt.Printf("交易 %x 的证明验证失败\n", p.TxHash)
+		return
+	}
+	if tracker := self.Blockchain.Tracker; tracker != nil {
+		if err := tracker.Confirm(p, self.Blockchain.MainChainHeight); err != nil {
+			fmt.Printf("交易 %x 未确认：%v\n", p.TxHash, err)
+		}
+		return
+	}
+	fmt.Printf("交易 %x 已包含在区块 %x 中\n", p.TxHash, blockHash)
+}
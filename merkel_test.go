@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+//生成包含n个交易、使用第version版Merkel树的区块
+func newMerkelTestBlock(n int, version byte) Block {
+	b := NewBlock(nil)
+	for i := 0; i < n; i++ {
+		tr := NewTransaction(nil, nil, []byte(RandomString(16)))
+		tr.Header.TimeStamp = uint32(i)
+		b.AddTransaction(tr)
+	}
+	b.BlockHeader.MerkelRoot = b.GenerateMerkelRoot(version)
+	return b
+}
+
+func TestMerkelProof(t *testing.T) {
+	for _, version := range []byte{MERKEL_VERSION_1, MERKEL_VERSION_2} {
+		for n := 1; n <= 17; n++ {
+			b := newMerkelTestBlock(n, version)
+			for i, tr := range *b.TransactionSlice {
+				p, err := b.MerkelProof(tr.Hash(), version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !p.Verify() {
+					t.Errorf("第%d版%d个交易中第%d个交易的证明验证失败", version, n, i)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkelRootV2(t *testing.T) {
+	a, b, c := SHA256([]byte("a")), SHA256([]byte("b")), SHA256([]byte("c"))
+	leaf := func(h []byte) []byte { return SHA256(append([]byte{0}, h...)) }
+	node := func(l, r []byte) []byte { return SHA256(append(append([]byte{1}, l...), r...)) }
+
+	if !reflect.DeepEqual(MerkelRoot([][]byte{a, b, c}, MERKEL_VERSION_2), node(node(leaf(a), leaf(b)), leaf(c))) {
+		t.Error("第2版Merkel根值计算错误")
+	}
+	if !reflect.DeepEqual(MerkelRoot(nil, MERKEL_VERSION_2), SHA256(nil)) {
+		t.Error("没有交易时第2版Merkel根值应为SHA256(空)")
+	}
+
+	//第1版中内部节点可以被当作叶子，得到相同的根值
+	inner := SHA256(append(append([]byte{}, a...), b...))
+	if !reflect.DeepEqual(MerkelRoot([][]byte{a, b, c}, MERKEL_VERSION_1), MerkelRoot([][]byte{inner, c}, MERKEL_VERSION_1)) {
+		t.Error("第1版Merkel根值计算方式被改变")
+	}
+	if reflect.DeepEqual(MerkelRoot([][]byte{a, b, c}, MERKEL_VERSION_2), MerkelRoot([][]byte{node(leaf(a), leaf(b)), c}, MERKEL_VERSION_2)) {
+		t.Error("第2版中内部节点不应被当作叶子")
+	}
+	if reflect.DeepEqual(MerkelRoot([][]byte{a, b, c}, MERKEL_VERSION_2), MerkelRoot([][]byte{a, b, c, c}, MERKEL_VERSION_2)) {
+		t.Error("重复最后一个叶子不应得到相同的根值")
+	}
+}
+
+func TestMerkelVersionActivation(t *testing.T) {
+	//所有网络都从创世区块开始使用第2版，使用较高的激活高度测试第1版区块
+	const activation = 20000
+	params := MainNetParams
+	params.MerkelV2Height, params.genesis = activation, nil
+	withParams(t, &params)
+	if MainNetParams.MerkelVersion(0) != MERKEL_VERSION_2 {
+		t.Error("主网应从创世区块开始使用第2版Merkel树")
+	}
+	if MerkelVersion(activation-1) != MERKEL_VERSION_1 || MerkelVersion(activation) != MERKEL_VERSION_2 {
+		t.Error("Merkel树版本激活高度错误")
+	}
+
+	b := newMerkelTestBlock(3, MERKEL_VERSION_2)
+	if b.VerifyMerkelRoot(activation-1) || !b.VerifyMerkelRoot(activation) {
+		t.Error("第2版Merkel根值只应在激活高度之后有效")
+	}
+
+	//激活前的区块使用第2版Merkel根值时应被拒绝
+	bc := NewBlockchain()
+	g := newBranch(nil, 1, "merkel")[0]
+	g.BlockHeader.MerkelRoot = g.GenerateMerkelRoot(MERKEL_VERSION_2)
+	if _, err := bc.AddBlock(g); err == nil {
+		t.Error("Merkel根值版本错误的区块不应被接受")
+	}
+
+	p, _ := b.MerkelProof((*b.TransactionSlice)[0].Hash(), MERKEL_VERSION_2)
+	p.Version = MERKEL_VERSION_1
+	if p.Verify() {
+		t.Error("版本错误的证明不应验证通过")
+	}
+}
+
+func TestMerkelProofRejectsTampering(t *testing.T) {
+	b := newMerkelTestBlock(7, MERKEL_VERSION_2)
+	tr := (*b.TransactionSlice)[3]
+	p, _ := b.MerkelProof(tr.Hash(), MERKEL_VERSION_2)
+
+	p.TxHash = (*b.TransactionSlice)[4].Hash()
+	if p.Verify() {
+		t.Error("替换交易哈希值后证明不应验证通过")
+	}
+	p.TxHash = tr.Hash()
+	p.Branch[0].Left = !p.Branch[0].Left
+	if p.Verify() {
+		t.Error("修改路径后证明不应验证通过")
+	}
+
+	if _, err := b.MerkelProof(SHA256([]byte("missing")), MERKEL_VERSION_2); err == nil {
+		t.Error("不在区块中的交易不应生成证明")
+	}
+}
+
+func TestMerkelProofMarshalling(t *testing.T) {
+	b := newMerkelTestBlock(5, MERKEL_VERSION_2)
+	p, _ := b.MerkelProof((*b.TransactionSlice)[2].Hash(), MERKEL_VERSION_2)
+
+	d, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	np := new(MerkelProof)
+	if err := np.UnmarshalBinary(d); err != nil {
+		t.Fatal(err)
+	}
+	if !np.Verify() || np.Version != MERKEL_VERSION_2 || !reflect.DeepEqual(np.Branch, p.Branch) || !reflect.DeepEqual((&Block{BlockHeader: np.Header}).Hash(), b.Hash()) {
+		t.Error("序列化，反序列化失败")
+	}
+	if err := np.UnmarshalBinary(d[:len(d)-1]); err == nil {
+		t.Error("长度错误的证明应反序列化失败")
+	}
+}
+
+func TestTxIndex(t *testing.T) {
+	bc := NewBlockchain()
+	mainBranch := newBranch(nil, 2, "main")
+	side := newBranch(mainBranch[0].Hash(), 2, "side")
+	for _, b := range append(mainBranch, side...) {
+		bc.AddBlock(b)
+	}
+
+	tr := (*mainBranch[1].TransactionSlice)[0]
+	if bc.FindTransaction(tr.Hash()) != nil {
+		t.Error("重组后回滚区块中的交易应从索引中移除")
+	}
+	tr = (*side[1].TransactionSlice)[0]
+	b := bc.FindTransaction(tr.Hash())
+	if b == nil || !reflect.DeepEqual(b.Hash(), side[1].Hash()) {
+		t.Fatal("主链上的交易未索引")
+	}
+	if p, err := b.MerkelProof(tr.Hash(), MERKEL_VERSION_2); err != nil || !p.Verify() {
+		t.Error("主链上的交易证明验证失败", err)
+	}
+}
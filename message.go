@@ -1,8 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"io"
+	"log"
+)
+
+var (
+	ErrMessageTooLarge = errors.New("消息长度超过限制")
+	ErrBadChecksum     = errors.New("消息校验和错误")
+	ErrBadMessageType  = errors.New("消息类型与帧头部不一致")
 )
 
 //消息结构
@@ -11,7 +21,8 @@ type Message struct {
 	Options    []byte //消息类型，包括交易和区块信息
 	Data       []byte //消息内容
 
-	Reply chan Message
+	Reply chan Message //回复消息通道
+	Node  *Node        //消息来源节点
 }
 
 //新建消息
@@ -19,6 +30,18 @@ func NewMessage(id byte) *Message {
 	return &Message{Identifier: id}
 }
 
+//通过Reply回复消息来源节点，节点已断开时丢弃回复
+func (m Message) Respond(r Message) {
+	var done chan struct{}
+	if m.Node != nil {
+		done = m.Node.done
+	}
+	select {
+	case m.Reply <- r:
+	case <-done:
+	}
+}
+
 //将消息序列化
 func (m *Message) MarshalBinary() ([]byte, error) {
 	buf := new(bytes.Buffer)
@@ -44,3 +67,99 @@ func (m *Message) UnmarshalBinary(d []byte) error {
 
 	return nil
 }
+
+//消息帧格式：
+//魔数(4字节) + 消息类型(1字节) + 消息长度(4字节) + 校验和(4字节) + 消息序列化数据
+//校验和为消息序列化数据SHA256哈希值的前4个字节
+func (m *Message) MarshalFrame() ([]byte, error) {
+	payload, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > MAX_MESSAGE_SIZE {
+		return nil, ErrMessageTooLarge
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, chainParams.Magic)
+	buf.WriteByte(m.Identifier)
+	binary.Write(buf, binary.LittleEndian, uint32(len(payload)))
+	buf.Write(SHA256(payload)[:4])
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+//消息读取器，从数据流中按帧读取消息
+type MessageReader struct {
+	r *bufio.Reader
+}
+
+//新建消息读取器
+func NewMessageReader(r io.Reader) *MessageReader {
+	return &MessageReader{bufio.NewReader(r)}
+}
+
+//读取一条消息
+//魔数不匹配时向后查找下一个魔数；长度超过限制时返回ErrMessageTooLarge，数据流无法继续读取；
+//校验和错误或消息类型不一致时丢弃该帧并返回错误，可以继续读取下一条消息
+func (mr *MessageReader) ReadMessage() (*Message, error) {
+	if err := mr.seekMagic(); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, MESSAGE_FRAME_HEADER_SIZE)
+	if _, err := io.ReadFull(mr.r, header); err != nil {
+		return nil, err
+	}
+	identifier := header[4]
+	length := binary.LittleEndian.Uint32(header[5:9])
+	checksum := header[9:13]
+	if length > MAX_MESSAGE_SIZE {
+		return nil, ErrMessageTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(mr.r, payload); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(checksum, SHA256(payload)[:4]) {
+		return nil, ErrBadChecksum
+	}
+
+	m := new(Message)
+	if err := m.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+	if m.Identifier != identifier {
+		return nil, ErrBadMessageType
+	}
+	return m, nil
+}
+
+//跳过魔数之前的数据
+func (mr *MessageReader) seekMagic() error {
+	magic := make([]byte, 4)
+	binary.BigEndian.PutUint32(magic, chainParams.Magic)
+
+	skipped := 0
+	for {
+		b, err := mr.r.Peek(4)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(b, magic) {
+			break
+		}
+		mr.r.Discard(1)
+		skipped++
+	}
+	if skipped > 0 {
+		log.Println("跳过无法识别的数据", skipped, "字节")
+	}
+	return nil
+}
+
+//判断读取消息的错误是否可以继续读取下一条消息
+func IsRecoverableMessageError(err error) bool {
+	return err == ErrBadChecksum || err == ErrBadMessageType
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
 	"reflect"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestMessageMarshalling(t *testing.T) {
@@ -24,3 +30,94 @@ func TestMessageMarshalling(t *testing.T) {
 		t.Error("Marshall unmarshall message error")
 	}
 }
+
+func TestMessageFraming(t *testing.T) {
+	mes1 := &Message{Identifier: MESSAGE_SEND_BLOCK, Data: []byte(RandomString(RandomInt(1024, 1024*4)))}
+	mes2 := &Message{Identifier: MESSAGE_GET_NODES, Options: []byte{1}, Data: []byte{}}
+	f1, _ := mes1.MarshalFrame()
+	f2, _ := mes2.MarshalFrame()
+
+	//连续的两条消息，逐字节读取
+	r := NewMessageReader(iotest.OneByteReader(bytes.NewReader(append(f1, f2...))))
+	for _, mes := range []*Message{mes1, mes2} {
+		m, err := r.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, mes) {
+			t.Error("消息帧读取错误")
+		}
+	}
+	if _, err := r.ReadMessage(); err != io.EOF {
+		t.Error("数据读完后应返回EOF", err)
+	}
+}
+
+func TestMessageFramingErrors(t *testing.T) {
+	mes := &Message{Identifier: MESSAGE_SEND_TRANSACTION, Data: []byte("hello")}
+	frame, _ := mes.MarshalFrame()
+
+	//校验和错误的帧被丢弃，之后的消息可以继续读取
+	corrupt := append([]byte{}, frame...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	//魔数之前的无效数据被跳过
+	stream := append(append([]byte("garbage"), corrupt...), frame...)
+
+	r := NewMessageReader(bytes.NewReader(stream))
+	if _, err := r.ReadMessage(); err != ErrBadChecksum || !IsRecoverableMessageError(err) {
+		t.Error("校验和错误未被发现", err)
+	}
+	if m, err := r.ReadMessage(); err != nil || !reflect.DeepEqual(m, mes) {
+		t.Error("校验和错误之后的消息读取失败", err)
+	}
+
+	//长度超过限制
+	oversized := append([]byte{}, frame[:MESSAGE_FRAME_HEADER_SIZE]...)
+	binary.LittleEndian.PutUint32(oversized[5:9], MAX_MESSAGE_SIZE+1)
+	r = NewMessageReader(bytes.NewReader(oversized))
+	if _, err := r.ReadMessage(); err != ErrMessageTooLarge || IsRecoverableMessageError(err) {
+		t.Error("超长消息未被拒绝", err)
+	}
+
+	//数据不完整
+	r = NewMessageReader(bytes.NewReader(frame[:len(frame)-2]))
+	if _, err := r.ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Error("不完整的消息未被发现", err)
+	}
+}
+
+//握手失败或节点断开后HandleNode返回，之后的回复被丢弃，不会阻塞消息处理
+func TestRespondAfterDisconnect(t *testing.T) {
+	prevBlockchain, prevNetwork := self.Blockchain, self.Network
+	self.Blockchain, self.Network = NewBlockchain(), SetupNetwork("127.0.0.1:0", chainParams.Port)
+	t.Cleanup(func() { self.Blockchain, self.Network = prevBlockchain, prevNetwork })
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &Node{TCPConn: conn.(*net.TCPConn)}
+	HandleNode(node)
+
+	m := Message{Reply: make(chan Message), Node: node}
+	done := make(chan bool)
+	go func() {
+		m.Respond(*NewMessage(MESSAGE_GET_NODES))
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("节点断开后回复不应阻塞")
+	}
+}
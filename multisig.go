@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+const (
+	SCHEME_MULTISIG   = "multisig" //多重签名公钥和签名的前缀
+	MULTISIG_MAX_KEYS = 16         //多重签名公钥最多包含的公钥数
+)
+
+//m-of-n多重签名公钥，交易须由其中至少M个公钥签名
+//编码为"multisig:" + Base58(M(1字节) + N(1字节) + N个变长公钥)，公钥的顺序决定签名的位置，顺序不同时账户不同
+type MultisigKey struct {
+	M    int
+	Keys [][]byte //带算法前缀的公钥
+}
+
+//多重签名，每个公钥对应一个位置，未签名的位置为空
+//编码为"multisig:" + Base58(N(1字节) + N个变长签名)
+type MultisigSignature [][]byte
+
+//创建多重签名公钥，公钥不能重复，也不能是多重签名公钥
+func NewMultisigKey(m int, keys [][]byte) (*MultisigKey, error) {
+	if len(keys) == 0 || len(keys) > MULTISIG_MAX_KEYS {
+		return nil, fmt.Errorf("多重签名须包含1到%d个公钥", MULTISIG_MAX_KEYS)
+	}
+	if m < 1 || m > len(keys) {
+		return nil, fmt.Errorf("需要的签名数须为1到%d", len(keys))
+	}
+	for i, key := range keys {
+		if IsMultisig(key) {
+			return nil, errors.New("多重签名不能包含多重签名公钥")
+		}
+		if _, _, err := decodeSchemeData(key); err != nil {
+			return nil, fmt.Errorf("第%d个公钥无效：%v", i+1, err)
+		}
+		for _, k := range keys[:i] {
+			if bytes.Equal(k, key) {
+				return nil, fmt.Errorf("第%d个公钥重复", i+1)
+			}
+		}
+	}
+	return &MultisigKey{M: m, Keys: keys}, nil
+}
+
+//是否为多重签名公钥或签名
+func IsMultisig(d []byte) bool {
+	return bytes.HasPrefix(d, []byte(SCHEME_MULTISIG+string(SCHEME_SEPARATOR)))
+}
+
+//解析多重签名公钥，只接受规范编码
+func ParseMultisigKey(public []byte) (*MultisigKey, error) {
+	buf, err := decodeMultisigData(public)
+	if err != nil {
+		return nil, err
+	}
+	header, err := readBytes(buf, 2)
+	if err != nil {
+		return nil, errors.New("多重签名公钥格式错误")
+	}
+	keys := make([][]byte, header[1])
+	for i := range keys {
+		if keys[i], err = readVarBytes(buf); err != nil {
+			return nil, errors.New("多重签名公钥格式错误")
+		}
+	}
+	if buf.Len() != 0 {
+		return nil, errors.New("多重签名公钥格式错误")
+	}
+	k, err := NewMultisigKey(int(header[0]), keys)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(k.Public(), public) {
+		return nil, errors.New("多重签名公钥编码不规范")
+	}
+	return k, nil
+}
+
+//编码后的公钥，用作交易发送方，地址由编码后的公钥计算
+func (k *MultisigKey) Public() []byte {
+	buf := bytes.NewBuffer([]byte{byte(k.M), byte(len(k.Keys))})
+	for _, key := range k.Keys {
+		writeVarBytes(buf, key)
+	}
+	return encodeMultisigData(buf.Bytes())
+}
+
+//公钥在多重签名中的位置，不存在时返回-1
+func (k *MultisigKey) Index(public []byte) int {
+	for i, key := range k.Keys {
+		if bytes.Equal(key, public) {
+			return i
+		}
+	}
+	return -1
+}
+
+//解析属于该公钥的签名，签名为空时返回没有签名的多重签名
+func (k *MultisigKey) Signature(sig []byte) (MultisigSignature, error) {
+	if len(sig) == 0 {
+		return make(MultisigSignature, len(k.Keys)), nil
+	}
+	s, err := ParseMultisigSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+	if len(s) != len(k.Keys) {
+		return nil, errors.New("多重签名的签名数与公钥数不一致")
+	}
+	return s, nil
+}
+
+//验证签名：每个非空签名都须有效，且有效签名不少于M个
+func (k *MultisigKey) Verify(sig, hash []byte) bool {
+	s, err := k.Signature(sig)
+	if err != nil || s.Count() < k.M {
+		return false
+	}
+	for i, ss := range s {
+		if ss != nil && !SignatureVerify(k.Keys[i], ss, hash) {
+			return false
+		}
+	}
+	return true
+}
+
+//解析多重签名，只接受规范编码
+func ParseMultisigSignature(sig []byte) (MultisigSignature, error) {
+	buf, err := decodeMultisigData(sig)
+	if err != nil {
+		return nil, err
+	}
+	n, err := buf.ReadByte()
+	if err != nil || n == 0 || n > MULTISIG_MAX_KEYS {
+		return nil, errors.New("多重签名格式错误")
+	}
+	s := make(MultisigSignature, n)
+	for i := range s {
+		if s[i], err = readVarBytes(buf); err != nil {
+			return nil, errors.New("多重签名格式错误")
+		}
+	}
+	if buf.Len() != 0 || !bytes.Equal(s.Bytes(), sig) {
+		return nil, errors.New("多重签名格式错误")
+	}
+	return s, nil
+}
+
+//编码后的签名
+func (s MultisigSignature) Bytes() []byte {
+	buf := bytes.NewBuffer([]byte{byte(len(s))})
+	for _, ss := range s {
+		writeVarBytes(buf, ss)
+	}
+	return encodeMultisigData(buf.Bytes())
+}
+
+//已有的签名数
+func (s MultisigSignature) Count() int {
+	c := 0
+	for _, ss := range s {
+		if ss != nil {
+			c++
+		}
+	}
+	return c
+}
+
+//合并另一个签名者的部分签名，同一位置的签名不同时返回错误
+func (s MultisigSignature) Combine(o MultisigSignature) error {
+	if len(s) != len(o) {
+		return errors.New("多重签名的签名数不一致")
+	}
+	for i, ss := range o {
+		switch {
+		case ss == nil:
+		case s[i] == nil:
+			s[i] = ss
+		case !bytes.Equal(s[i], ss):
+			return fmt.Errorf("第%d个签名不一致", i+1)
+		}
+	}
+	return nil
+}
+
+//使用keypair为多重签名交易签名，签名放入keypair的公钥在发送方中的位置，保留其他签名者的签名
+func (t *Transaction) SignMultisig(keypair *Keypair) error {
+	k, err := ParseMultisigKey(t.Header.From)
+	if err != nil {
+		return err
+	}
+	i := k.Index(keypair.Public)
+	if i < 0 {
+		return errors.New("密钥不是该多重签名的签名者")
+	}
+	s, err := k.Signature(t.Signature)
+	if err != nil {
+		return err
+	}
+	if s[i], err = keypair.Sign(t.Hash()); err != nil {
+		return err
+	}
+	t.Signature = s.Bytes()
+	return nil
+}
+
+//多重签名交易已有的签名数和需要的签名数
+func (t *Transaction) MultisigStatus() (int, int, error) {
+	k, err := ParseMultisigKey(t.Header.From)
+	if err != nil {
+		return 0, 0, err
+	}
+	s, err := k.Signature(t.Signature)
+	if err != nil {
+		return 0, 0, err
+	}
+	return s.Count(), k.M, nil
+}
+
+//合并多个签名者分别签名的同一笔多重签名交易，不检查签名是否有效
+func CombineMultisigTransactions(txs ...*Transaction) (*Transaction, error) {
+	if len(txs) == 0 {
+		return nil, errors.New("没有要合并的交易")
+	}
+	k, err := ParseMultisigKey(txs[0].Header.From)
+	if err != nil {
+		return nil, err
+	}
+	hash := txs[0].Hash()
+	s := make(MultisigSignature, len(k.Keys))
+	for _, t := range txs {
+		if !bytes.Equal(t.Hash(), hash) || !bytes.Equal(t.Payload, txs[0].Payload) {
+			return nil, errors.New("只能合并同一笔交易的签名")
+		}
+		ts, err := k.Signature(t.Signature)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Combine(ts); err != nil {
+			return nil, err
+		}
+	}
+	combined := *txs[0]
+	combined.Signature = s.Bytes()
+	return &combined, nil
+}
+
+func encodeMultisigData(raw []byte) []byte {
+	return append([]byte(SCHEME_MULTISIG+string(SCHEME_SEPARATOR)), encodeBase58(raw)...)
+}
+
+func decodeMultisigData(d []byte) (*bytes.Buffer, error) {
+	if !IsMultisig(d) {
+		return nil, errors.New("不是多重签名数据")
+	}
+	raw, err := decodeBase58(d[len(SCHEME_MULTISIG)+1:])
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(raw), nil
+}
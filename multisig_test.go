@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+//生成m-of-n多重签名公钥和签名者的密钥对，签名者使用不同的签名算法
+func newTestMultisig(t *testing.T, m, n int) (*MultisigKey, []*Keypair) {
+	schemes := []string{SCHEME_P224, SCHEME_SECP256K1, SCHEME_ED25519, SCHEME_P256}
+	kps, keys := []*Keypair{}, [][]byte{}
+	for i := 0; i < n; i++ {
+		kp, err := GenerateKeypair(schemes[i%len(schemes)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		kps, keys = append(kps, kp), append(keys, kp.Public)
+	}
+	k, err := NewMultisigKey(m, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k, kps
+}
+
+func TestMultisigKey(t *testing.T) {
+	k, kps := newTestMultisig(t, 2, 3)
+	public := k.Public()
+	if !IsMultisig(public) {
+		t.Error("多重签名公钥缺少前缀", string(public))
+	}
+	parsed, err := ParseMultisigKey(public)
+	if err != nil || !reflect.DeepEqual(parsed, k) {
+		t.Fatal("解析多重签名公钥失败", err)
+	}
+	if k.Index(kps[2].Public) != 2 || k.Index(newTestKeypair().Public) != -1 {
+		t.Error("公钥位置错误")
+	}
+	if r, err := ParseRecipient(string(public)); err != nil || !bytes.Equal(r, public) {
+		t.Error("多重签名公钥应可作为接收方", err)
+	}
+	if AccountOf(public) != string(NewAddress(public)) {
+		t.Error("多重签名公钥的账户应为其地址")
+	}
+
+	keys := [][]byte{kps[0].Public, kps[1].Public}
+	invalid := map[string]func() error{
+		"m为0":  func() error { _, err := NewMultisigKey(0, keys); return err },
+		"m大于n": func() error { _, err := NewMultisigKey(3, keys); return err },
+		"公钥重复": func() error { _, err := NewMultisigKey(1, [][]byte{keys[0], keys[0]}); return err },
+		"嵌套":   func() error { _, err := NewMultisigKey(1, [][]byte{keys[0], public}); return err },
+		"公钥无效": func() error { _, err := NewMultisigKey(1, [][]byte{keys[0], []byte("p224:0OIl")}); return err },
+		"公钥过多": func() error { _, err := NewMultisigKey(1, make([][]byte, MULTISIG_MAX_KEYS+1)); return err },
+		"截断":   func() error { _, err := ParseMultisigKey(public[:len(public)-1]); return err },
+	}
+	for name, f := range invalid {
+		if f() == nil {
+			t.Error("应返回错误：", name)
+		}
+	}
+}
+
+func TestMultisigTransaction(t *testing.T) {
+	pow := ArrayOfBytes(TRANSACTION_POW_COMPLEXITY, POW_PREFIX)
+	k, kps := newTestMultisig(t, 2, 3)
+	tr := NewTransferTransaction(k.Public(), newTestKeypair().Public, 10*COIN, COIN, []byte("multisig"))
+	tr.Header.Nonce = tr.GenerateNonce(pow)
+	if tr.VerifyTransaction(pow) {
+		t.Error("未签名的多重签名交易不应验证通过")
+	}
+
+	//两个签名者分别签名未签名的交易，再合并
+	a, b := *tr, *tr
+	if err := a.SignMultisig(kps[0]); err != nil {
+		t.Fatal(err)
+	}
+	if signed, required, err := a.MultisigStatus(); err != nil || signed != 1 || required != 2 {
+		t.Error("签名状态错误", signed, required, err)
+	}
+	if a.VerifyTransaction(pow) {
+		t.Error("签名不足的交易不应验证通过")
+	}
+	if err := b.SignMultisig(kps[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SignMultisig(newTestKeypair()); err == nil {
+		t.Error("不是签名者的密钥不应能签名")
+	}
+	combined, err := CombineMultisigTransactions(&a, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !combined.VerifyTransaction(pow) {
+		t.Error("签名足够的多重签名交易验证失败")
+	}
+	if !bytes.Equal(combined.Hash(), tr.Hash()) {
+		t.Error("签名改变了交易哈希值")
+	}
+
+	//依次签名与合并的结果等价
+	if err := a.SignMultisig(kps[1]); err != nil || !a.VerifyTransaction(pow) {
+		t.Error("依次签名的交易验证失败", err)
+	}
+
+	//序列化后传给其他签名者
+	data, err := combined.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newT := &Transaction{}
+	if rem, err := newT.UnmarshalBinary(data); err != nil || len(rem) != 0 || !reflect.DeepEqual(newT, combined) {
+		t.Fatal("多重签名交易序列化失败", err)
+	}
+
+	//已有的签名无效时整个签名无效
+	s, _ := k.Signature(combined.Signature)
+	s[0], _ = kps[0].Sign(SHA256([]byte("other")))
+	bad := *combined
+	bad.Signature = s.Bytes()
+	if bad.VerifyTransaction(pow) {
+		t.Error("包含无效签名的交易不应验证通过")
+	}
+	if _, err := CombineMultisigTransactions(combined, &bad); err == nil {
+		t.Error("同一位置的签名不同时不应能合并")
+	}
+
+	other := *tr
+	other.Header.Amount++
+	if _, err := CombineMultisigTransactions(&a, &other); err == nil {
+		t.Error("不同交易的签名不应能合并")
+	}
+}
+
+//包含较多公钥的多重签名超过255字节，使用2字节长度序列化
+func TestMultisigLongFields(t *testing.T) {
+	pow := ArrayOfBytes(TRANSACTION_POW_COMPLEXITY, POW_PREFIX)
+	k, kps := newTestMultisig(t, MULTISIG_MAX_KEYS, MULTISIG_MAX_KEYS)
+	tr := NewTransferTransaction(k.Public(), k.Public(), COIN, 0, []byte("long"))
+	tr.Header.Nonce = tr.GenerateNonce(pow)
+	for _, kp := range kps {
+		if err := tr.SignMultisig(kp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tr.Header.From) < VAR_LENGTH_PREFIX || len(tr.Signature) < VAR_LENGTH_PREFIX {
+		t.Fatal("公钥和签名应超过1字节长度的范围", len(tr.Header.From), len(tr.Signature))
+	}
+	if !tr.VerifyTransaction(pow) {
+		t.Error("多重签名交易验证失败")
+	}
+	data, err := tr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newT := &Transaction{}
+	if rem, err := newT.UnmarshalBinary(data); err != nil || len(rem) != 0 || !reflect.DeepEqual(newT, tr) {
+		t.Fatal("多重签名交易序列化失败", err)
+	}
+
+	//长度须使用最短的编码
+	buf := bytes.NewBuffer([]byte{VAR_LENGTH_PREFIX, 3, 0, 'a', 'b', 'c'})
+	if _, err := readVarBytes(buf); err == nil {
+		t.Error("不规范的长度编码应返回错误")
+	}
+	if _, err := readVarBytes(bytes.NewBuffer([]byte{0xfe})); err == nil {
+		t.Error("未定义的长度前缀应返回错误")
+	}
+}
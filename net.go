@@ -1,11 +1,14 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"sync"
 	"time"
 )
 
@@ -19,6 +22,11 @@ type NodeChannel chan *Node
 type Node struct {
 	*net.TCPConn
 	lastSeen int
+	Address  string          //节点的监听地址，主动连接的节点为连接地址，未知时为空
+	Version  *VersionMessage //对方的版本消息，握手完成前为空
+
+	writeMutex sync.Mutex    //保证消息完整写入
+	done       chan struct{} //HandleNode返回时关闭，停止回复并丢弃之后的回复
 }
 
 //节点映射
@@ -32,6 +40,10 @@ type Network struct {
 	ConnectionCallBack NodeChannel
 	BroadcastQueue     chan Message //广播通道
 	IncomingMessages   chan Message //接受消息通道
+	AddressBook        *AddressBook //节点地址簿
+	Nonce              uint64       //握手随机数，用于发现连接到自己
+
+	mutex sync.RWMutex //保护Nodes
 }
 
 //添加节点，先验证节点是否已存在，如果不存在则添加
@@ -48,63 +60,162 @@ func (n Nodes) AddNode(node *Node) bool {
 
 //处理节点加入
 func HandleNode(node *Node) {
-	for {
-		var bs []byte = make([]byte, 1024*1000)
-		n, err := node.TCPConn.Read(bs[0:])
-		networkError(err)
-		if err != io.EOF {
-			fmt.Println("EOF")
-			//TODO:Remove node
-			node.TCPConn.Close()
-			break
+	//回复消息通道，处理程序通过Message.Respond回复该节点，节点断开或握手失败后回复协程退出
+	reply := make(chan Message)
+	node.done = make(chan struct{})
+	defer close(node.done)
+	go func() {
+		for {
+			select {
+			case m := <-reply:
+				networkError(node.Send(m))
+			case <-node.done:
+				return
+			}
 		}
+	}()
 
-		m := new(Message)
-		err = m.UnmarshalBinary(bs[0:n])
+	reader := NewMessageReader(node.TCPConn)
+	if err := self.Network.Handshake(node, reader); err != nil {
+		fmt.Println("节点握手失败：", node.TCPConn.RemoteAddr(), err)
+		node.TCPConn.Close()
+		self.Network.RemoveNode(node)
+		return
+	}
 
-		if err != nil {
-			fmt.Println(err)
+	for {
+		m, err := reader.ReadMessage()
+		if IsRecoverableMessageError(err) {
+			networkError(err)
 			continue
 		}
-		m.Reply = make(chan Message)
-		go func(cb chan Message) {
-			for {
-				m, ok := <-cb
-				if !ok {
-					close(cb)
-					break
-				}
-				b, _ := m.MarshalBinary()
-				l := len(b)
-
-				i := 0
-				for i < l {
-					a, _ := node.TCPConn.Write(b[i:])
-					i += a
-				}
-			}
-		}(m.Reply)
+		if err != nil {
+			networkError(err)
+			fmt.Println("节点断开：", node.TCPConn.RemoteAddr())
+			node.TCPConn.Close()
+			self.Network.RemoveNode(node)
+			break
+		}
+
+		m.Reply, m.Node = reply, node
 		self.Network.IncomingMessages <- *m
 	}
 }
 
+//向节点发送消息
+func (node *Node) Send(m Message) error {
+	b, err := m.MarshalFrame()
+	if err != nil {
+		return err
+	}
+	node.writeMutex.Lock()
+	defer node.writeMutex.Unlock()
+	_, err = node.TCPConn.Write(b)
+	return err
+}
+
 //启动P2P网络
 func (n *Network) Run() {
 	fmt.Println("监听：", self.Address)
 	listenCb := StartListening(self.Address)
+	peerExchange := time.NewTicker(PEER_EXCHANGE_INTERVAL * time.Second)
 
 	for {
 		select {
 		case node := <-listenCb:
-			self.Nodes.AddNode(node)
+			n.addNode(node)
 		case node := <-n.ConnectionCallBack:
-			self.Nodes.AddNode(node)
+			n.addNode(node)
 		case message := <-n.BroadcastQueue:
 			go n.BroadcastMessage(message)
+		case <-peerExchange.C:
+			//定期向节点请求新的节点地址，并保存地址簿
+			go n.BroadcastMessage(*NewMessage(MESSAGE_GET_NODES))
+			if n.AddressBook != nil {
+				logOnError(n.AddressBook.Save())
+			}
 		}
 	}
 }
 
+//添加节点
+func (n *Network) addNode(node *Node) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.Nodes.AddNode(node)
+}
+
+//移除断开的节点
+func (n *Network) RemoveNode(node *Node) {
+	n.mutex.Lock()
+	for k, nd := range n.Nodes {
+		if nd == node {
+			delete(n.Nodes, k)
+		}
+	}
+	n.mutex.Unlock()
+
+	self.Blockchain.Sync.RemovePeer(node)
+}
+
+//检查是否已连接到节点
+func (n *Network) HasNode(address string) bool {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+
+	for k, node := range n.Nodes {
+		if k == address || node.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+//已连接的节点数
+func (n *Network) NodeCount() int {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return len(n.Nodes)
+}
+
+//获取已连接节点的监听地址
+func (n *Network) NodeAddresses() []string {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+
+	addrs := []string{}
+	for _, node := range n.Nodes {
+		if node.Version != nil && node.Address != "" {
+			addrs = append(addrs, node.Address)
+		}
+	}
+	return addrs
+}
+
+//已连接节点的信息，在锁内复制，可在其他协程中读取
+type PeerInfo struct {
+	Remote     string         //对方的TCP地址
+	Address    string         //节点的监听地址，未知时为空
+	Handshaked bool           //是否已完成握手
+	Version    VersionMessage //对方的版本消息，握手完成前为空
+}
+
+//获取已连接节点的信息，可被其他协程调用
+func (n *Network) ConnectedNodes() []PeerInfo {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+
+	peers := []PeerInfo{}
+	for _, node := range n.Nodes {
+		p := PeerInfo{Remote: node.TCPConn.RemoteAddr().String(), Address: node.Address}
+		if node.Version != nil {
+			p.Handshaked, p.Version = true, *node.Version
+		}
+		peers = append(peers, p)
+	}
+	return peers
+}
+
 //初始化网络
 func SetupNetwork(address, port string) *Network {
 	n := new(Network)
@@ -113,6 +224,10 @@ func SetupNetwork(address, port string) *Network {
 	n.ConnectionsQueue, n.ConnectionCallBack = CreateConnectionQueue()
 	n.Nodes = Nodes{}
 
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	n.Nonce = binary.LittleEndian.Uint64(nonce)
+
 	n.Address = address
 	return n
 }
@@ -124,9 +239,12 @@ func CreateConnectionQueue() (ConnectionsQueue, NodeChannel) {
 
 	go func() {
 		for {
-			address := <-in
-			address = fmt.Sprintf("%s:%s", address, BLOCKCHAIN_PORT)
-			if address != self.Network.Address && self.Nodes[address] == nil {
+			address, err := NormalizeAddress(<-in)
+			if err != nil {
+				networkError(err)
+				continue
+			}
+			if address != self.Network.Address && !self.Network.HasNode(address) {
 				go ConnectToNode(address, 5*time.Second, false, out)
 			}
 		}
@@ -148,7 +266,7 @@ func StartListening(address string) NodeChannel {
 			connection, err := l.AcceptTCP()
 			networkError(err)
 
-			cb <- &Node{connection, int(time.Now().Unix())}
+			cb <- &Node{TCPConn: connection, lastSeen: int(time.Now().Unix())}
 		}
 	}(listener)
 	return cb
@@ -156,38 +274,35 @@ func StartListening(address string) NodeChannel {
 
 //连接节点
 func ConnectToNode(dst string, timeout time.Duration, retry bool, cb NodeChannel) {
-	addrDst, err := net.ResolveTCPAddr("tcp4", dst)
-	networkError(err)
-	var con *net.TCPConn = nil
-loop:
 	for {
-		breakchannel := make(chan bool)
-		go func() {
-			con, err = net.DialTCP("tcp", nil, addrDst)
-			if err != nil {
-				cb <- &Node{con, int(time.Now().Unix())}
-				breakchannel <- true
-			}
-		}()
-
-		select {
-		case <-TimeOut(timeout):
-			if !retry {
-				break loop
-			}
-		case <-breakchannel:
-			break loop
+		con, err := net.DialTimeout("tcp4", dst, timeout)
+		if err == nil {
+			cb <- &Node{TCPConn: con.(*net.TCPConn), lastSeen: int(time.Now().Unix()), Address: dst}
+			return
 		}
+		if self.Network.AddressBook != nil {
+			self.Network.AddressBook.Failed(dst)
+		}
+		if !retry {
+			return
+		}
+		time.Sleep(timeout)
 	}
 }
 
 //向所有节点发送信息
 func (n *Network) BroadcastMessage(message Message) {
-	b, _ := message.MarshalBinary()
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
 	for k, node := range n.Nodes {
+		//握手完成前不能发送其他消息
+		if node.Version == nil {
+			continue
+		}
 		fmt.Println("广播信息......", k)
+		node := node
 		go func() {
-			_, err := node.TCPConn.Write(b)
+			err := node.Send(message)
 			if err != nil {
 				fmt.Println("广播出现故障：", node.TCPConn.RemoteAddr())
 			}
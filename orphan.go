@@ -0,0 +1,117 @@
+package main
+
+import (
+	"time"
+)
+
+//孤儿区块：前一区块尚未收到的区块
+type orphanBlock struct {
+	Block
+	hash     []byte
+	received time.Time
+}
+
+//区块孤儿池
+//以前区块哈希值为键保存孤儿区块，收到父区块后将整条孤儿链一并连接
+//孤儿池有数量和存活时间限制，防止被恶意填满
+type OrphanPool struct {
+	orphans  map[string]*orphanBlock   //区块哈希值 -> 孤儿区块
+	children map[string][]*orphanBlock //前区块哈希值 -> 孤儿区块
+}
+
+//新建孤儿池
+func NewOrphanPool() *OrphanPool {
+	return &OrphanPool{orphans: map[string]*orphanBlock{}, children: map[string][]*orphanBlock{}}
+}
+
+//孤儿区块数量
+func (op *OrphanPool) Len() int {
+	return len(op.orphans)
+}
+
+//检查孤儿区块是否存在
+func (op *OrphanPool) Exists(hash []byte) bool {
+	return op.orphans[string(hash)] != nil
+}
+
+//添加孤儿区块，超过数量限制时移除最早收到的区块
+func (op *OrphanPool) Add(b Block) {
+	hash := b.Hash()
+	if op.Exists(hash) {
+		return
+	}
+	op.Expire(time.Now())
+	for op.Len() >= ORPHAN_POOL_SIZE {
+		op.remove(op.oldest())
+	}
+
+	o := &orphanBlock{Block: b, hash: hash, received: time.Now()}
+	op.orphans[string(hash)] = o
+	op.children[string(b.PreBlock)] = append(op.children[string(b.PreBlock)], o)
+}
+
+//获取孤儿链缺失的祖先区块哈希值
+func (op *OrphanPool) MissingAncestor(b Block) []byte {
+	prev := b.PreBlock
+	for {
+		o := op.orphans[string(prev)]
+		if o == nil {
+			return prev
+		}
+		prev = o.PreBlock
+	}
+}
+
+//取出以parent为祖先的所有孤儿区块，父区块在前
+func (op *OrphanPool) Take(parent []byte) BlockSlice {
+	bs := BlockSlice{}
+	queue := [][]byte{parent}
+	for len(queue) > 0 {
+		children := op.children[string(queue[0])]
+		queue = queue[1:]
+		for _, o := range children {
+			op.remove(o)
+			bs = append(bs, o.Block)
+			queue = append(queue, o.hash)
+		}
+	}
+	return bs
+}
+
+//移除过期的孤儿区块
+func (op *OrphanPool) Expire(now time.Time) {
+	for _, o := range op.orphans {
+		if now.Sub(o.received) > ORPHAN_BLOCK_EXPIRE*time.Second {
+			op.remove(o)
+		}
+	}
+}
+
+//最早收到的孤儿区块
+func (op *OrphanPool) oldest() *orphanBlock {
+	var oldest *orphanBlock
+	for _, o := range op.orphans {
+		if oldest == nil || o.received.Before(oldest.received) {
+			oldest = o
+		}
+	}
+	return oldest
+}
+
+func (op *OrphanPool) remove(o *orphanBlock) {
+	delete(op.orphans, string(o.hash))
+
+	key := string(o.PreBlock)
+	siblings := op.children[key]
+	for i, s := range siblings {
+		if s == o {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(op.children, key)
+	} else {
+		op.children[key] = siblings
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOrphanPoolConnectChain(t *testing.T) {
+	bs := newStoreTestBlocks(4)
+	op := NewOrphanPool()
+
+	//乱序收到后三个区块
+	op.Add(bs[3])
+	op.Add(bs[1])
+	op.Add(bs[2])
+
+	if !reflect.DeepEqual(op.MissingAncestor(bs[3]), bs[0].Hash()) {
+		t.Error("缺失的祖先区块错误")
+	}
+
+	connected := op.Take(bs[0].Hash())
+	if len(connected) != 3 || op.Len() != 0 {
+		t.Fatal("孤儿链连接失败", len(connected), op.Len())
+	}
+	for i, b := range connected {
+		if !reflect.DeepEqual(b.Hash(), bs[i+1].Hash()) {
+			t.Error("孤儿区块顺序错误", i)
+		}
+	}
+}
+
+func TestOrphanPoolLimits(t *testing.T) {
+	op := NewOrphanPool()
+	for i := 0; i < ORPHAN_POOL_SIZE+10; i++ {
+		b := NewBlock([]byte(RandomString(32)))
+		b.BlockHeader.Nonce = uint32(i)
+		op.Add(b)
+	}
+	if op.Len() != ORPHAN_POOL_SIZE {
+		t.Error("孤儿池超过数量限制", op.Len())
+	}
+
+	op.Expire(time.Now().Add((ORPHAN_BLOCK_EXPIRE + 1) * time.Second))
+	if op.Len() != 0 || len(op.children) != 0 {
+		t.Error("过期孤儿区块未被移除", op.Len())
+	}
+}
+
+//区块在父区块之前到达，包括前一区块本身是孤儿区块的情况，父区块到达后所有区块都连接到主链
+func TestRunConnectsOrphanChain(t *testing.T) {
+	withParams(t, &RegTestParams)
+	prevMiner, prevNetwork := self.Miner, self.Network
+	self.Miner, self.Network = newTestKeypair(), &Network{BroadcastQueue: make(chan Message)}
+	t.Cleanup(func() { self.Miner, self.Network = prevMiner, prevNetwork })
+	go func() {
+		for range self.Network.BroadcastQueue {
+		}
+	}()
+
+	src := NewBlockchain()
+	if err := src.SetGenesis(chainParams.Genesis()); err != nil {
+		t.Fatal(err)
+	}
+	bs := BlockSlice{}
+	for i := 0; i < 3; i++ {
+		b := mineRegTestBlock(src)
+		if _, err := src.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		bs = append(bs, b)
+	}
+
+	//孙、子、父，以及子、孙、父
+	for _, order := range [][]int{{2, 1, 0}, {1, 2, 0}} {
+		bc := NewBlockchain()
+		if err := bc.SetGenesis(chainParams.Genesis()); err != nil {
+			t.Fatal(err)
+		}
+		go bc.Run()
+		for _, i := range order {
+			bc.BlocksQueue <- bs[i]
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for bc.Tip().Height != 3 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		for i, b := range bs {
+			if bc.MainChainHeight(b.Hash()) != i+1 {
+				t.Error("乱序到达的区块未连接", order, i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+)
+
+//网络参数，同一网络的节点须使用相同的参数
+//包括创世区块、消息魔数、默认端口、难度规则和种子节点，用-network选择mainnet、testnet或regtest
+type ChainParams struct {
+	Name      string   //网络名称
+	NetworkId uint32   //网络标识，握手时检查
+	Magic     uint32   //消息帧魔数
+	Port      string   //默认端口
+	APIPort   string   //HTTP接口默认端口
+	DataDir   string   //数据目录，在~/.yibc/之下，主网为空
+	SeedNodes []string //种子节点
+
+	InitialBits      uint32 //创世区块和初始的难度目标
+	PowLimitBits     uint32 //难度目标上限(最低难度)
+	RetargetInterval int    //每隔多少个区块调整一次难度
+	TargetSpacing    int64  //期望出块间隔(秒)
+	NoRetarget       bool   //不调整难度，用于本地测试
+	MerkelV2Height   int    //从该高度的区块开始使用第2版Merkel树
+	AddressVersion   byte   //地址的版本字节，决定地址的首字符
+
+	GenesisTime    uint32 //创世区块时间戳
+	GenesisMessage string //创世区块中唯一交易的数据
+
+	genesis []byte //序列化的创世区块
+}
+
+var (
+	//主网
+	MainNetParams = ChainParams{
+		Name:             "mainnet",
+		NetworkId:        NETWORK_ID,
+		Magic:            MESSAGE_MAGIC,
+		Port:             BLOCKCHAIN_PORT,
+		APIPort:          BLOCKCHAIN_API_PORT,
+		SeedNodes:        SEED_NODES(),
+		InitialBits:      BLOCK_INITIAL_BITS,
+		PowLimitBits:     BLOCK_POW_LIMIT_BITS,
+		RetargetInterval: BLOCK_RETARGET_INTERVAL,
+		TargetSpacing:    BLOCK_TARGET_SPACING,
+		MerkelV2Height:   0,
+		AddressVersion:   78, //地址以Y开头
+		GenesisTime:      1577836800,
+		GenesisMessage:   "yibc mainnet genesis",
+	}
+
+	//测试网，与主网的难度规则相同
+	TestNetParams = ChainParams{
+		Name:             "testnet",
+		NetworkId:        2,
+		Magic:            0x79696274, //"yibt"
+		Port:             "19207",
+		APIPort:          "19208",
+		DataDir:          "testnet",
+		InitialBits:      BLOCK_INITIAL_BITS,
+		PowLimitBits:     BLOCK_POW_LIMIT_BITS,
+		RetargetInterval: BLOCK_RETARGET_INTERVAL,
+		TargetSpacing:    BLOCK_TARGET_SPACING,
+		MerkelV2Height:   0,
+		AddressVersion:   127, //地址以t开头
+		GenesisTime:      1577836800,
+		GenesisMessage:   "yibc testnet genesis",
+	}
+
+	//本地回归测试网络，难度极低且不调整，没有种子节点
+	RegTestParams = ChainParams{
+		Name:             "regtest",
+		NetworkId:        3,
+		Magic:            0x79696272, //"yibr"
+		Port:             "29207",
+		APIPort:          "29208",
+		DataDir:          "regtest",
+		InitialBits:      0x207fffff,
+		PowLimitBits:     0x207fffff,
+		RetargetInterval: BLOCK_RETARGET_INTERVAL,
+		TargetSpacing:    BLOCK_TARGET_SPACING,
+		NoRetarget:       true,
+		MerkelV2Height:   0,
+		AddressVersion:   122, //地址以r开头
+		GenesisTime:      1577836800,
+		GenesisMessage:   "yibc regtest genesis",
+	}
+
+	//当前使用的网络参数
+	chainParams = &MainNetParams
+)
+
+//根据名称获取网络参数
+func ParamsForNetwork(name string) (*ChainParams, error) {
+	for _, p := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams} {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, errors.New("未知的网络：" + name)
+}
+
+//选择使用的网络，须在打开数据目录和连接网络之前调用
+func SelectParams(name string) error {
+	p, err := ParamsForNetwork(name)
+	if err != nil {
+		return err
+	}
+	chainParams = p
+	return nil
+}
+
+//本机HTTP接口的默认地址
+func (p *ChainParams) APIAddress() string {
+	return "127.0.0.1:" + p.APIPort
+}
+
+//难度目标上限
+func (p *ChainParams) PowLimit() *big.Int {
+	return CompactToBig(p.PowLimitBits)
+}
+
+//高度为height的区块使用的Merkel树版本
+func (p *ChainParams) MerkelVersion(height int) byte {
+	if height >= p.MerkelV2Height {
+		return MERKEL_VERSION_2
+	}
+	return MERKEL_VERSION_1
+}
+
+//获取创世区块，每次返回新的副本，修改返回的区块不影响之后获取的创世区块
+//创世区块由参数确定，没有记账者，不需要满足工作量证明和签名，不保存到区块文件中
+func (p *ChainParams) Genesis() Block {
+	if p.genesis == nil {
+		g := NewBlock(nil)
+		g.BlockHeader.TimeStamp = p.GenesisTime
+		g.BlockHeader.Bits = p.InitialBits
+		tr := NewTransaction(nil, nil, []byte(p.GenesisMessage))
+		tr.Header.TimeStamp = p.GenesisTime
+		g.AddTransaction(tr)
+		g.BlockHeader.MerkelRoot = g.GenerateMerkelRoot(p.MerkelVersion(0))
+		p.genesis, _ = g.MarshalBinary()
+	}
+	g := Block{}
+	g.UnmarshalBinary(p.genesis)
+	return g
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+//使用指定的网络参数执行测试，结束后恢复
+func withParams(t *testing.T, p *ChainParams) {
+	prev := chainParams
+	chainParams = p
+	t.Cleanup(func() { chainParams = prev })
+}
+
+func TestParamsForNetwork(t *testing.T) {
+	for _, name := range []string{"mainnet", "testnet", "regtest"} {
+		p, err := ParamsForNetwork(name)
+		if err != nil || p.Name != name {
+			t.Error("获取网络参数失败", name, err)
+		}
+	}
+	if _, err := ParamsForNetwork("devnet"); err == nil {
+		t.Error("未知的网络应返回错误")
+	}
+
+	seen := map[string]bool{}
+	for _, p := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams} {
+		g := p.Genesis()
+		if seen[string(g.Hash())] {
+			t.Error("不同网络的创世区块不应相同", p.Name)
+		}
+		seen[string(g.Hash())] = true
+		again := p.Genesis()
+		if !reflect.DeepEqual(g.Hash(), again.Hash()) {
+			t.Error("创世区块应固定不变", p.Name)
+		}
+		//修改返回的创世区块不影响之后获取的创世区块
+		g.BlockHeader.Bits++
+		(*g.TransactionSlice)[0].Payload[0] ^= 0xff
+		if !reflect.DeepEqual(p.Genesis(), again) {
+			t.Error("创世区块应返回副本", p.Name)
+		}
+	}
+}
+
+func TestGenesis(t *testing.T) {
+	bc := NewBlockchain()
+	if err := bc.SetGenesis(chainParams.Genesis()); err != nil {
+		t.Fatal(err)
+	}
+
+	root := newBranch(nil, 1, "root")[0]
+	if _, err := bc.AddBlock(root); err == nil {
+		t.Error("没有前一区块的区块不应被接受")
+	}
+	child := newBranch(bc.Genesis, 1, "child")[0]
+	if _, err := bc.AddBlock(child); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bc.Tree.Best.Hash, child.Hash()) || bc.Tree.Best.Height != 1 {
+		t.Error("创世区块之后的区块高度应为1")
+	}
+}
+
+//在主链末端挖出一个签名的空区块，须使用回归测试网络参数
+func mineRegTestBlock(bc *Blockchain) Block {
+	kp := newTestKeypair()
+	b := NewBlock(bc.Tree.Best.Hash)
+	b.BlockHeader.Origin = kp.Public
+	b.BlockHeader.Bits = bc.Tree.NextBits(bc.Tree.Best)
+	//连续挖出的区块时间须递增
+	b.BlockHeader.TimeStamp = uint32(time.Now().Unix())
+	if b.BlockHeader.TimeStamp <= bc.Tree.Best.TimeStamp {
+		b.BlockHeader.TimeStamp = bc.Tree.Best.TimeStamp + 1
+	}
+	b.BlockHeader.MerkelRoot = b.GenerateMerkelRoot(MerkelVersion(bc.Tree.Best.Height + 1))
+	for !CheckBlockProofofWork(b.BlockHeader.Bits, b.Hash()) {
+		b.BlockHeader.Nonce++
+	}
+	b.Signture = b.Sign(kp)
+	return b
+}
+
+func TestRegTestMining(t *testing.T) {
+	withParams(t, &RegTestParams)
+	bc := NewBlockchain()
+	if err := bc.SetGenesis(chainParams.Genesis()); err != nil {
+		t.Fatal(err)
+	}
+
+	b := mineRegTestBlock(bc)
+
+	if !b.VerifyBlock() || b.BlockHeader.Bits != RegTestParams.InitialBits {
+		t.Fatal("回归测试网络的区块验证失败")
+	}
+	if _, err := bc.AddBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	if bc.Tree.NextBits(bc.Tree.Best) != RegTestParams.InitialBits {
+		t.Error("回归测试网络不应调整难度")
+	}
+}
+
+func TestLoadBlocksAfterGenesis(t *testing.T) {
+	withParams(t, &RegTestParams)
+	dir := t.TempDir()
+	open := func() *Blockchain {
+		bc := NewBlockchain()
+		if err := bc.SetGenesis(chainParams.Genesis()); err != nil {
+			t.Fatal(err)
+		}
+		bc.Store = openTestBlockStore(t, dir)
+		if err := bc.LoadBlocks(); err != nil {
+			t.Fatal(err)
+		}
+		return bc
+	}
+
+	bc := open()
+	if len(bc.BlockSlice) != 1 || bc.Store.Len() != 0 {
+		t.Fatal("新的区块链应只包含创世区块，且创世区块不保存到区块文件中")
+	}
+	child := mineRegTestBlock(bc)
+	if _, err := bc.AddBlock(child); err != nil {
+		t.Fatal(err)
+	}
+	bc.Store.Close()
+
+	bc = open()
+	defer bc.Store.Close()
+	if len(bc.BlockSlice) != 2 || !reflect.DeepEqual(bc.BlockSlice[1].Hash(), child.Hash()) {
+		t.Error("重新加载后主链错误", len(bc.BlockSlice))
+	}
+}
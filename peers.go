@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//已知节点地址信息
+type KnownAddress struct {
+	LastSeen    int64 //最近一次连接成功的时间
+	LastAttempt int64 //最近一次尝试连接的时间
+	Failures    int   //连续连接失败次数
+}
+
+//节点地址簿，保存在~/.yibc/peers.json中
+type AddressBook struct {
+	Addresses map[string]*KnownAddress
+
+	file  string
+	mutex sync.Mutex
+}
+
+//打开节点地址簿，文件不存在或无法解析时返回空的地址簿
+func OpenAddressBook(dir string) (*AddressBook, error) {
+	dir = getDirectoryWithBaseDir(dir)
+	ab := &AddressBook{Addresses: map[string]*KnownAddress{}, file: path.Join(dir, BLOCKCHAIN_PEERS_FILENAME)}
+
+	f, err := os.Open(ab.file)
+	if os.IsNotExist(err) {
+		return ab, nil
+	}
+	if err != nil {
+		return ab, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(ab); err != nil {
+		ab.Addresses = map[string]*KnownAddress{}
+		return ab, err
+	}
+	if ab.Addresses == nil {
+		ab.Addresses = map[string]*KnownAddress{}
+	}
+	return ab, nil
+}
+
+//保存节点地址簿
+func (ab *AddressBook) Save() error {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	if err := os.MkdirAll(path.Dir(ab.file), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(ab.file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(ab); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//添加节点地址，返回是否为新地址
+func (ab *AddressBook) Add(address string) bool {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	if ab.Addresses[address] != nil {
+		return false
+	}
+	if len(ab.Addresses) >= ADDRESS_BOOK_SIZE {
+		ab.evict()
+	}
+	ab.Addresses[address] = &KnownAddress{}
+	return true
+}
+
+//记录连接成功
+func (ab *AddressBook) Seen(address string) {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	ka := ab.Addresses[address]
+	if ka == nil {
+		ka = &KnownAddress{}
+		ab.Addresses[address] = ka
+	}
+	ka.LastSeen, ka.LastAttempt, ka.Failures = time.Now().Unix(), time.Now().Unix(), 0
+}
+
+//记录连接失败，连续失败次数过多的地址将被移除
+func (ab *AddressBook) Failed(address string) {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	ka := ab.Addresses[address]
+	if ka == nil {
+		return
+	}
+	ka.LastAttempt = time.Now().Unix()
+	ka.Failures++
+	if ka.Failures >= ADDRESS_MAX_FAILURES {
+		delete(ab.Addresses, address)
+	}
+}
+
+//移除地址
+func (ab *AddressBook) Remove(address string) {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+	delete(ab.Addresses, address)
+}
+
+//获取最多max个地址，最近连接成功的地址在前
+func (ab *AddressBook) Sample(max int, seenOnly bool) []string {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	addrs := []string{}
+	for a, ka := range ab.Addresses {
+		if !seenOnly || ka.LastSeen > 0 {
+			addrs = append(addrs, a)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return ab.Addresses[addrs[i]].LastSeen > ab.Addresses[addrs[j]].LastSeen
+	})
+	if len(addrs) > max {
+		addrs = addrs[:max]
+	}
+	return addrs
+}
+
+//地址簿已满时移除失败次数最多、最久未连接成功的地址
+func (ab *AddressBook) evict() {
+	var worst string
+	for a, ka := range ab.Addresses {
+		w := ab.Addresses[worst]
+		if w == nil || ka.Failures > w.Failures || (ka.Failures == w.Failures && ka.LastSeen < w.LastSeen) {
+			worst = a
+		}
+	}
+	delete(ab.Addresses, worst)
+}
+
+//规范化节点地址为ip:port格式，没有端口时使用默认端口
+func NormalizeAddress(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, chainParams.Port
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", errors.New("无效的节点地址：" + address)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return "", errors.New("无效的节点端口：" + address)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+//序列化节点地址列表，每个地址为 长度(1字节) + 地址
+func EncodeAddresses(addrs []string) []byte {
+	buf := new(bytes.Buffer)
+	for _, a := range addrs {
+		if len(a) > 255 {
+			continue
+		}
+		buf.WriteByte(byte(len(a)))
+		buf.WriteString(a)
+	}
+	return buf.Bytes()
+}
+
+//反序列化节点地址列表
+func DecodeAddresses(d []byte) ([]string, error) {
+	addrs := []string{}
+	buf := bytes.NewBuffer(d)
+	for buf.Len() > 0 {
+		l, _ := buf.ReadByte()
+		if buf.Len() < int(l) {
+			return nil, errors.New("节点地址列表长度错误")
+		}
+		addrs = append(addrs, string(buf.Next(int(l))))
+	}
+	return addrs, nil
+}
+
+//回复节点请求，发送已连接节点和地址簿中的部分地址
+func (n *Network) HandleGetNodes(msg Message) {
+	if msg.Reply == nil {
+		return
+	}
+	addrs := n.NodeAddresses()
+	if len(addrs) < PEER_EXCHANGE_SIZE && n.AddressBook != nil {
+		for _, a := range n.AddressBook.Sample(PEER_EXCHANGE_SIZE, true) {
+			if len(addrs) >= PEER_EXCHANGE_SIZE {
+				break
+			}
+			addrs = appendIfMissing(addrs, a)
+		}
+	}
+	if len(addrs) > PEER_EXCHANGE_SIZE {
+		addrs = addrs[:PEER_EXCHANGE_SIZE]
+	}
+
+	reply := NewMessage(MESSAGE_SEND_NODES)
+	reply.Data = EncodeAddresses(addrs)
+	msg.Respond(*reply)
+}
+
+//处理收到的节点地址，新地址加入地址簿，连接数不足时尝试连接
+func (n *Network) HandleSendNodes(msg Message) {
+	addrs, err := DecodeAddresses(msg.Data)
+	if err != nil {
+		networkError(err)
+		return
+	}
+	if len(addrs) > PEER_EXCHANGE_SIZE {
+		addrs = addrs[:PEER_EXCHANGE_SIZE]
+	}
+	for _, a := range addrs {
+		address, err := NormalizeAddress(a)
+		if err != nil || address == n.Address {
+			continue
+		}
+		if n.AddressBook != nil && !n.AddressBook.Add(address) {
+			continue
+		}
+		if n.NodeCount() < MAX_PEERS {
+			n.ConnectionsQueue <- address
+		}
+	}
+}
+
+func appendIfMissing(addrs []string, a string) []string {
+	for _, b := range addrs {
+		if a == b {
+			return addrs
+		}
+	}
+	return append(addrs, a)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	cases := map[string]string{
+		"10.0.5.33":       "10.0.5.33:" + BLOCKCHAIN_PORT,
+		"172.17.0.2:9300": "172.17.0.2:9300",
+		"[::1]:9207":      "[::1]:9207",
+	}
+	for in, out := range cases {
+		a, err := NormalizeAddress(in)
+		if err != nil || a != out {
+			t.Error("地址规范化错误", in, a, err)
+		}
+	}
+	for _, in := range []string{"", "example.com", "10.0.0.1:0", "10.0.0.1:port"} {
+		if _, err := NormalizeAddress(in); err == nil {
+			t.Error("无效地址未被拒绝", in)
+		}
+	}
+}
+
+func TestAddressesMarshalling(t *testing.T) {
+	addrs := []string{"10.0.5.33:9207", "172.17.0.2:9300"}
+	decoded, err := DecodeAddresses(EncodeAddresses(addrs))
+	if err != nil || !reflect.DeepEqual(addrs, decoded) {
+		t.Error("节点地址序列化，反序列化失败")
+	}
+	if _, err := DecodeAddresses([]byte{10, 'a'}); err == nil {
+		t.Error("长度错误的地址列表未被拒绝")
+	}
+}
+
+func TestAddressBookPersistence(t *testing.T) {
+	dir := t.TempDir()
+	ab, err := OpenAddressBook(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ab.Add("10.0.0.1:9207")
+	ab.Add("10.0.0.2:9207")
+	ab.Seen("10.0.0.2:9207")
+	if ab.Add("10.0.0.1:9207") {
+		t.Error("重复地址被添加")
+	}
+	for i := 0; i < ADDRESS_MAX_FAILURES; i++ {
+		ab.Failed("10.0.0.1:9207")
+	}
+	if err := ab.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	ab, err = OpenAddressBook(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ab.Sample(10, false), []string{"10.0.0.2:9207"}) {
+		t.Error("地址簿保存，读取失败", ab.Sample(10, false))
+	}
+}
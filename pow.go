@@ -1,14 +1,13 @@
 package main
 
 import (
+	"math/big"
 	"reflect"
 )
 
 var (
 	//交易信息计算难度值
 	TRANSACTION_POW = ArrayOfBytes(TRANSACTION_POW_COMPLEXITY, POW_PREFIX)
-	//区块计算难度值
-	BLOCK_POW = ArrayOfBytes(BLOCK_POW_COMPLEXITY, POW_PREFIX)
 )
 
 //验证计算的难度值是否符合要求
@@ -19,3 +18,91 @@ func CheckProofofWork(predix, hash []byte) bool {
 	}
 	return true
 }
+
+//验证区块哈希值是否满足难度目标
+//验证方法：将哈希值看作256位大整数，不大于难度目标即满足要求。目标越小，难度越大
+func CheckBlockProofofWork(bits uint32, hash []byte) bool {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 || target.Cmp(chainParams.PowLimit()) > 0 {
+		return false
+	}
+	return new(big.Int).SetBytes(hash).Cmp(target) <= 0
+}
+
+//难度目标对应的工作量，即平均需要计算的哈希次数：2^256 / (target + 1)
+func BlockWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+//根据实际出块时间调整难度目标
+//实际时间限制在期望时间的1/4到4倍之间，新目标 = 原目标 * 实际时间 / 期望时间
+func CalculateNextBits(bits uint32, actualTimespan, targetTimespan int64) uint32 {
+	if actualTimespan < targetTimespan/BLOCK_RETARGET_MAX_ADJUST {
+		actualTimespan = targetTimespan / BLOCK_RETARGET_MAX_ADJUST
+	}
+	if actualTimespan > targetTimespan*BLOCK_RETARGET_MAX_ADJUST {
+		actualTimespan = targetTimespan * BLOCK_RETARGET_MAX_ADJUST
+	}
+
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(actualTimespan))
+	target.Div(target, big.NewInt(targetTimespan))
+	if limit := chainParams.PowLimit(); target.Cmp(limit) > 0 {
+		target.Set(limit)
+	}
+	return BigToCompact(target)
+}
+
+//将压缩格式的难度目标转换为大整数
+//压缩格式：最高字节为字节长度，低3字节为最高的有效数字，0x00800000为符号位
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	negative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var n *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		n = big.NewInt(int64(mantissa))
+	} else {
+		n = big.NewInt(int64(mantissa))
+		n.Lsh(n, 8*(exponent-3))
+	}
+	if negative {
+		n.Neg(n)
+	}
+	return n
+}
+
+//将大整数转换为压缩格式的难度目标
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(new(big.Int).Abs(n).Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(new(big.Int).Abs(n), 8*(exponent-3)).Uint64())
+	}
+
+	//最高位为符号位，需要多占一个字节
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
@@ -1,16 +1,62 @@
 package main
 
 import (
+	"math/big"
 	"testing"
 )
 
 func TestPoW(t *testing.T) {
 	b1 := CheckProofofWork([]byte{0, 0, 0, 1, 2, 3}, []byte{0, 0, 0, 1, 2, 3, 4, 5})
 	b2 := CheckProofofWork([]byte{0, 0}, []byte("hello"))
-	b3 := CheckProofofWork(BLOCK_POW, append(BLOCK_POW, 1))
+	b3 := CheckProofofWork(TRANSACTION_POW, append(TRANSACTION_POW, 1))
 	b4 := CheckProofofWork(nil, []byte("Hello"))
 
 	if !b1 || b2 || !b3 || !b4 {
 		t.Error("PoW测试未通过")
 	}
 }
+
+func TestCompactBits(t *testing.T) {
+	for _, bits := range []uint32{BLOCK_INITIAL_BITS, BLOCK_POW_LIMIT_BITS, 0x1b0404cb, 0x03123456} {
+		if BigToCompact(CompactToBig(bits)) != bits {
+			t.Errorf("难度目标转换错误 %x", bits)
+		}
+	}
+	if CompactToBig(0x1d00ffff).Cmp(new(big.Int).Lsh(big.NewInt(0xffff), 208)) != 0 {
+		t.Error("难度目标解析错误")
+	}
+}
+
+func TestBlockPoW(t *testing.T) {
+	hash := make([]byte, 32)
+	hash[3] = 0xff
+	if !CheckBlockProofofWork(BLOCK_INITIAL_BITS, hash) {
+		t.Error("满足难度目标的哈希值验证失败")
+	}
+	hash[2] = 1
+	if CheckBlockProofofWork(BLOCK_INITIAL_BITS, hash) {
+		t.Error("不满足难度目标的哈希值验证通过")
+	}
+	if CheckBlockProofofWork(BLOCK_POW_LIMIT_BITS+1, make([]byte, 32)) {
+		t.Error("低于最低难度的目标验证通过")
+	}
+}
+
+func TestCalculateNextBits(t *testing.T) {
+	target := CompactToBig(BLOCK_INITIAL_BITS)
+
+	//出块过慢，难度降低，但最多降低BLOCK_RETARGET_MAX_ADJUST倍
+	slow := CompactToBig(CalculateNextBits(BLOCK_INITIAL_BITS, 100*600, 600))
+	if slow.Cmp(new(big.Int).Mul(target, big.NewInt(BLOCK_RETARGET_MAX_ADJUST))) != 0 {
+		t.Error("难度下调错误")
+	}
+	//出块过快，难度提高
+	fast := CompactToBig(CalculateNextBits(BLOCK_INITIAL_BITS, 1, 600))
+	if fast.Cmp(new(big.Int).Div(target, big.NewInt(BLOCK_RETARGET_MAX_ADJUST))) != 0 {
+		t.Error("难度上调错误")
+	}
+	//不超过最低难度
+	if CalculateNextBits(BLOCK_POW_LIMIT_BITS, 600*4, 600) != BLOCK_POW_LIMIT_BITS {
+		t.Error("难度低于最低难度")
+	}
+}
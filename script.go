@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	SCHEME_SCRIPT = "script" //锁定脚本和解锁脚本的前缀
+
+	MAX_SCRIPT_SIZE         = 2048 //脚本的最大字节数
+	MAX_SCRIPT_ELEMENT_SIZE = 520  //推送数据的最大字节数
+	MAX_SCRIPT_STACK_SIZE   = 100  //栈中最多的元素个数
+	MAX_SCRIPT_OPS          = 201  //解锁脚本和锁定脚本中非推送操作码的最大个数
+	MAX_SCRIPT_SIGCHECKS    = 20   //最多验证的签名数，OP_CHECKMULTISIG按公钥数计算
+	MAX_SCRIPT_NUMBER_SIZE  = 8    //数字的最大字节数
+)
+
+//操作码，与比特币脚本的编号相同，没有循环和跳转，每个操作码最多执行一次
+const (
+	OP_0                   = 0x00 //推送空数据，也表示假和数字0
+	OP_PUSHDATA1           = 0x4c //后跟1字节长度和数据；0x01到0x4b直接推送之后相应字节数的数据
+	OP_PUSHDATA2           = 0x4d //后跟2字节长度(小端)和数据
+	OP_1                   = 0x51 //OP_1到OP_16推送数字1到16
+	OP_16                  = 0x60
+	OP_IF                  = 0x63
+	OP_NOTIF               = 0x64
+	OP_ELSE                = 0x67
+	OP_ENDIF               = 0x68
+	OP_VERIFY              = 0x69
+	OP_RETURN              = 0x6a
+	OP_DROP                = 0x75
+	OP_DUP                 = 0x76
+	OP_SWAP                = 0x7c
+	OP_EQUAL               = 0x87
+	OP_EQUALVERIFY         = 0x88
+	OP_SHA256              = 0xa8
+	OP_CHECKSIG            = 0xac
+	OP_CHECKSIGVERIFY      = 0xad
+	OP_CHECKMULTISIG       = 0xae
+	OP_CHECKMULTISIGVERIFY = 0xaf
+	OP_CHECKLOCKTIMEVERIFY = 0xb1
+)
+
+var opcodeNames = map[byte]string{
+	OP_0: "OP_0", OP_PUSHDATA1: "OP_PUSHDATA1", OP_PUSHDATA2: "OP_PUSHDATA2",
+	OP_IF: "OP_IF", OP_NOTIF: "OP_NOTIF", OP_ELSE: "OP_ELSE", OP_ENDIF: "OP_ENDIF", OP_VERIFY: "OP_VERIFY", OP_RETURN: "OP_RETURN",
+	OP_DROP: "OP_DROP", OP_DUP: "OP_DUP", OP_SWAP: "OP_SWAP", OP_EQUAL: "OP_EQUAL", OP_EQUALVERIFY: "OP_EQUALVERIFY",
+	OP_SHA256: "OP_SHA256", OP_CHECKSIG: "OP_CHECKSIG", OP_CHECKSIGVERIFY: "OP_CHECKSIGVERIFY",
+	OP_CHECKMULTISIG: "OP_CHECKMULTISIG", OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY", OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+var opcodesByName = map[string]byte{}
+
+func init() {
+	for op := byte(OP_1); op <= OP_16; op++ {
+		opcodeNames[op] = fmt.Sprintf("OP_%d", op-OP_1+1)
+	}
+	for op, name := range opcodeNames {
+		opcodesByName[name] = op
+	}
+}
+
+//脚本中的一条指令，推送指令带数据
+type ScriptOp struct {
+	Op   byte
+	Data []byte
+}
+
+//脚本执行环境
+type ScriptContext struct {
+	Hash []byte //交易哈希值，签名操作码验证对它的签名
+	Time uint32 //交易时间，OP_CHECKLOCKTIMEVERIFY与它比较
+}
+
+//解析脚本，推送须使用最短的编码，不能包含未定义的操作码
+func ParseScript(script []byte) ([]ScriptOp, error) {
+	if len(script) > MAX_SCRIPT_SIZE {
+		return nil, fmt.Errorf("脚本超过%d字节", MAX_SCRIPT_SIZE)
+	}
+	ops := []ScriptOp{}
+	for i := 0; i < len(script); {
+		op := script[i]
+		i++
+		n := 0
+		switch {
+		case op > OP_0 && op < OP_PUSHDATA1:
+			n = int(op)
+		case op == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, errors.New("OP_PUSHDATA1缺少长度")
+			}
+			if n = int(script[i]); n < OP_PUSHDATA1 {
+				return nil, errors.New("推送数据没有使用最短的编码")
+			}
+			i++
+		case op == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, errors.New("OP_PUSHDATA2缺少长度")
+			}
+			if n = int(binary.LittleEndian.Uint16(script[i:])); n <= 0xff {
+				return nil, errors.New("推送数据没有使用最短的编码")
+			}
+			i += 2
+		default:
+			if _, ok := opcodeNames[op]; !ok {
+				return nil, fmt.Errorf("未定义的操作码：0x%02x", op)
+			}
+			ops = append(ops, ScriptOp{Op: op})
+			continue
+		}
+		if n > MAX_SCRIPT_ELEMENT_SIZE || i+n > len(script) {
+			return nil, errors.New("推送数据过长或不完整")
+		}
+		ops = append(ops, ScriptOp{Op: op, Data: script[i : i+n]})
+		i += n
+	}
+	return ops, nil
+}
+
+//反汇编脚本：操作码为名称，大于16且不超过4字节的数字为十进制，带算法前缀的公钥和签名为字符串，其他数据为0x开头的十六进制
+func Disassemble(script []byte) (string, error) {
+	ops, err := ParseScript(script)
+	if err != nil {
+		return "", err
+	}
+	words := []string{}
+	for _, op := range ops {
+		switch {
+		case op.Op == OP_0 || op.Op >= OP_1:
+			words = append(words, opcodeNames[op.Op])
+		case isScriptText(op.Data):
+			words = append(words, string(op.Data))
+		case isDisplayNumber(op.Data):
+			n, _ := scriptNumber(op.Data)
+			words = append(words, strconv.FormatUint(n, 10))
+		default:
+			words = append(words, "0x"+hex.EncodeToString(op.Data))
+		}
+	}
+	return strings.Join(words, " "), nil
+}
+
+//汇编脚本，格式与反汇编相同，以空白分隔；数字0到16使用OP_0到OP_16
+func AssembleScript(text string) ([]byte, error) {
+	script := []byte{}
+	for _, word := range strings.Fields(text) {
+		if op, ok := opcodesByName[word]; ok && op != OP_PUSHDATA1 && op != OP_PUSHDATA2 {
+			script = append(script, op)
+			continue
+		}
+		var d []byte
+		if strings.HasPrefix(word, "0x") {
+			var err error
+			if d, err = hex.DecodeString(word[2:]); err != nil || len(d) == 0 {
+				return nil, errors.New("十六进制数据格式错误：" + word)
+			}
+		} else if n, err := strconv.ParseUint(word, 10, 64); err == nil {
+			if n <= 16 {
+				script = append(script, smallNumberOp(int(n)))
+				continue
+			}
+			d = encodeScriptNumber(n)
+		} else if isScriptText([]byte(word)) {
+			d = []byte(word)
+		} else {
+			return nil, errors.New("无法识别：" + word)
+		}
+		if len(d) > MAX_SCRIPT_ELEMENT_SIZE {
+			return nil, errors.New("推送数据过长：" + word)
+		}
+		script = appendPushData(script, d)
+	}
+	if len(script) > MAX_SCRIPT_SIZE {
+		return nil, fmt.Errorf("脚本超过%d字节", MAX_SCRIPT_SIZE)
+	}
+	return script, nil
+}
+
+//执行解锁脚本和锁定脚本：解锁脚本只能推送数据，锁定脚本在解锁脚本留下的栈上执行，结束时栈顶为真才成功
+func ExecuteScript(unlock, lock []byte, ctx ScriptContext) error {
+	unlockOps, err := ParseScript(unlock)
+	if err != nil {
+		return errors.New("解锁脚本无效：" + err.Error())
+	}
+	for _, op := range unlockOps {
+		if op.Op > OP_16 {
+			return errors.New("解锁脚本只能推送数据")
+		}
+	}
+	lockOps, err := ParseScript(lock)
+	if err != nil {
+		return errors.New("锁定脚本无效：" + err.Error())
+	}
+	vm := &scriptVM{ctx: ctx}
+	if err := vm.run(unlockOps); err != nil {
+		return err
+	}
+	if err := vm.run(lockOps); err != nil {
+		return err
+	}
+	if len(vm.stack) == 0 || !scriptBool(vm.stack[len(vm.stack)-1]) {
+		return errors.New("脚本执行结果为假")
+	}
+	return nil
+}
+
+//是否为锁定脚本或解锁脚本
+func IsScript(d []byte) bool {
+	return bytes.HasPrefix(d, []byte(SCHEME_SCRIPT+string(SCHEME_SEPARATOR)))
+}
+
+//编码脚本："script:" + Base58(脚本)，锁定脚本编码后用作交易发送方，解锁脚本编码后放在签名中
+func EncodeScript(script []byte) []byte {
+	prefix := []byte(SCHEME_SCRIPT + string(SCHEME_SEPARATOR))
+	if len(script) == 0 {
+		return prefix
+	}
+	return append(prefix, encodeBase58(script)...)
+}
+
+//解码脚本，只接受规范编码，不检查脚本内容
+func DecodeScript(d []byte) ([]byte, error) {
+	if !IsScript(d) {
+		return nil, errors.New("不是脚本")
+	}
+	body := d[len(SCHEME_SCRIPT)+1:]
+	if len(body) == 0 {
+		return nil, nil
+	}
+	script, err := decodeBase58(body)
+	if err != nil || !bytes.Equal(EncodeScript(script), d) {
+		return nil, errors.New("脚本编码错误")
+	}
+	return script, nil
+}
+
+//解析编码后的锁定脚本，须能解析且不为空
+func ParseScriptKey(public []byte) ([]byte, error) {
+	script, err := DecodeScript(public)
+	if err != nil {
+		return nil, err
+	}
+	if len(script) == 0 {
+		return nil, errors.New("锁定脚本为空")
+	}
+	if _, err := ParseScript(script); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+//执行脚本交易的解锁脚本和锁定脚本，签名为空时解锁脚本为空
+func (t *Transaction) ExecuteScript() error {
+	lock, err := ParseScriptKey(t.Header.From)
+	if err != nil {
+		return err
+	}
+	var unlock []byte
+	if len(t.Signature) > 0 {
+		if unlock, err = DecodeScript(t.Signature); err != nil {
+			return err
+		}
+		//保证同一解锁脚本只有一种签名
+		if len(unlock) == 0 {
+			return errors.New("解锁脚本为空时签名须为空")
+		}
+	}
+	return ExecuteScript(unlock, lock, ScriptContext{Hash: t.Hash(), Time: t.Header.TimeStamp})
+}
+
+//移除交易时间晚于区块时间的脚本交易，这类交易会使区块验证失败，留在交易池中等待之后的区块
+//不修改原有的交易列表
+func (b *Block) RemoveFutureScripts() {
+	ts := TransactionSlice{}
+	for _, t := range *b.TransactionSlice {
+		if !IsScript(t.Header.From) || t.Header.TimeStamp <= b.BlockHeader.TimeStamp {
+			ts = append(ts, t)
+		}
+	}
+	b.TransactionSlice = &ts
+}
+
+type scriptVM struct {
+	stack     [][]byte
+	cond      []bool //OP_IF嵌套的条件，都为真时才执行
+	ops       int
+	sigchecks int
+	ctx       ScriptContext
+}
+
+func (vm *scriptVM) run(ops []ScriptOp) error {
+	for _, op := range ops {
+		if op.Op > OP_16 {
+			if vm.ops++; vm.ops > MAX_SCRIPT_OPS {
+				return errors.New("操作码过多")
+			}
+		}
+		executing := vm.executing()
+		switch op.Op {
+		case OP_IF, OP_NOTIF:
+			c := false
+			if executing {
+				d, err := vm.pop()
+				if err != nil {
+					return err
+				}
+				c = scriptBool(d) == (op.Op == OP_IF)
+			}
+			vm.cond = append(vm.cond, c)
+			continue
+		case OP_ELSE, OP_ENDIF:
+			if len(vm.cond) == 0 {
+				return errors.New(opcodeNames[op.Op] + "没有对应的OP_IF")
+			}
+			if op.Op == OP_ELSE {
+				vm.cond[len(vm.cond)-1] = !vm.cond[len(vm.cond)-1]
+			} else {
+				vm.cond = vm.cond[:len(vm.cond)-1]
+			}
+			continue
+		}
+		if !executing {
+			continue
+		}
+		if err := vm.step(op); err != nil {
+			return fmt.Errorf("%s：%v", opcodeNames[op.Op], err)
+		}
+		if len(vm.stack) > MAX_SCRIPT_STACK_SIZE {
+			return errors.New("栈溢出")
+		}
+	}
+	if len(vm.cond) != 0 {
+		return errors.New("OP_IF没有对应的OP_ENDIF")
+	}
+	return nil
+}
+
+func (vm *scriptVM) step(op ScriptOp) error {
+	switch {
+	case op.Op <= OP_PUSHDATA2:
+		vm.push(op.Data)
+		return nil
+	case op.Op >= OP_1 && op.Op <= OP_16:
+		vm.push(encodeScriptNumber(uint64(op.Op - OP_1 + 1)))
+		return nil
+	}
+	switch op.Op {
+	case OP_VERIFY:
+		return vm.verify()
+	case OP_RETURN:
+		return errors.New("脚本终止")
+	case OP_DROP:
+		_, err := vm.pop()
+		return err
+	case OP_DUP:
+		d, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		vm.push(d)
+	case OP_SWAP:
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(b)
+		vm.push(a)
+	case OP_EQUAL, OP_EQUALVERIFY:
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(encodeScriptBool(bytes.Equal(a, b)))
+		if op.Op == OP_EQUALVERIFY {
+			return vm.verify()
+		}
+	case OP_SHA256:
+		d, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.push(SHA256(d))
+	case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+		public, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		//多重签名使用OP_CHECKMULTISIG，验证的签名数才能计入限制
+		if IsMultisig(public) {
+			return errors.New("公钥不能是多重签名公钥")
+		}
+		if err := vm.countSigchecks(1); err != nil {
+			return err
+		}
+		vm.push(encodeScriptBool(len(sig) > 0 && SignatureVerify(public, sig, vm.ctx.Hash)))
+		if op.Op == OP_CHECKSIGVERIFY {
+			return vm.verify()
+		}
+	case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+		if err := vm.checkMultisig(); err != nil {
+			return err
+		}
+		if op.Op == OP_CHECKMULTISIGVERIFY {
+			return vm.verify()
+		}
+	case OP_CHECKLOCKTIMEVERIFY:
+		d, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		locktime, err := scriptNumber(d)
+		if err != nil {
+			return err
+		}
+		if uint64(vm.ctx.Time) < locktime {
+			return fmt.Errorf("时间锁未到期，交易时间%d早于%d", vm.ctx.Time, locktime)
+		}
+	}
+	return nil
+}
+
+//栈：n个签名(未签名的位置为空) m 公钥1 ... 公钥n n，与MultisigKey相同，签名按公钥的顺序对应
+func (vm *scriptVM) checkMultisig() error {
+	d, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	n, err := scriptNumber(d)
+	if err != nil || n == 0 || n > MULTISIG_MAX_KEYS {
+		return fmt.Errorf("公钥数须为1到%d", MULTISIG_MAX_KEYS)
+	}
+	if err := vm.countSigchecks(int(n)); err != nil {
+		return err
+	}
+	keys := make([][]byte, n)
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i], err = vm.pop(); err != nil {
+			return err
+		}
+	}
+	if d, err = vm.pop(); err != nil {
+		return err
+	}
+	m, err := scriptNumber(d)
+	if err != nil {
+		return err
+	}
+	k, err := NewMultisigKey(int(m), keys)
+	if err != nil {
+		return err
+	}
+	s := make(MultisigSignature, n)
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i], err = vm.pop(); err != nil {
+			return err
+		}
+		if len(s[i]) == 0 {
+			s[i] = nil
+		}
+	}
+	vm.push(encodeScriptBool(k.Verify(s.Bytes(), vm.ctx.Hash)))
+	return nil
+}
+
+func (vm *scriptVM) executing() bool {
+	for _, c := range vm.cond {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm *scriptVM) countSigchecks(n int) error {
+	if vm.sigchecks += n; vm.sigchecks > MAX_SCRIPT_SIGCHECKS {
+		return errors.New("验证的签名过多")
+	}
+	return nil
+}
+
+func (vm *scriptVM) verify() error {
+	d, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if !scriptBool(d) {
+		return errors.New("验证失败")
+	}
+	return nil
+}
+
+func (vm *scriptVM) push(d []byte) {
+	vm.stack = append(vm.stack, d)
+}
+
+func (vm *scriptVM) pop() ([]byte, error) {
+	d, err := vm.peek()
+	if err != nil {
+		return nil, err
+	}
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return d, nil
+}
+
+func (vm *scriptVM) peek() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, errors.New("栈为空")
+	}
+	return vm.stack[len(vm.stack)-1], nil
+}
+
+//数字为无符号小端整数，最多8字节，最高字节不能为0，0为空数据
+func scriptNumber(d []byte) (uint64, error) {
+	if len(d) > MAX_SCRIPT_NUMBER_SIZE {
+		return 0, errors.New("数字过长")
+	}
+	if len(d) > 0 && d[len(d)-1] == 0 {
+		return 0, errors.New("数字没有使用最短的编码")
+	}
+	n := uint64(0)
+	for i := len(d) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(d[i])
+	}
+	return n, nil
+}
+
+func encodeScriptNumber(n uint64) []byte {
+	d := []byte{}
+	for ; n > 0; n >>= 8 {
+		d = append(d, byte(n))
+	}
+	return d
+}
+
+//任意字节不为0时为真
+func scriptBool(d []byte) bool {
+	for _, b := range d {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeScriptBool(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return nil
+}
+
+func smallNumberOp(n int) byte {
+	if n == 0 {
+		return OP_0
+	}
+	return byte(OP_1 + n - 1)
+}
+
+//使用最短的编码推送数据
+func appendPushData(script, d []byte) []byte {
+	switch {
+	case len(d) == 0:
+		return append(script, OP_0)
+	case len(d) < OP_PUSHDATA1:
+		script = append(script, byte(len(d)))
+	case len(d) <= 0xff:
+		script = append(script, OP_PUSHDATA1, byte(len(d)))
+	default:
+		script = append(script, OP_PUSHDATA2)
+		script = binary.LittleEndian.AppendUint16(script, uint16(len(d)))
+	}
+	return append(script, d...)
+}
+
+//反汇编时是否显示为数字，汇编时0到16使用OP_0到OP_16，这些数字的推送显示为十六进制才能还原
+func isDisplayNumber(d []byte) bool {
+	n, err := scriptNumber(d)
+	return err == nil && len(d) <= 4 && n > 16
+}
+
+//是否为带算法前缀的公钥或签名，反汇编时显示为字符串
+func isScriptText(d []byte) bool {
+	if bytes.IndexByte(d, SCHEME_SEPARATOR) < 0 {
+		return false
+	}
+	if IsMultisig(d) {
+		return false
+	}
+	_, _, err := decodeSchemeData(d)
+	return err == nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustAssemble(t *testing.T, text string) []byte {
+	script, err := AssembleScript(text)
+	if err != nil {
+		t.Fatal(text, err)
+	}
+	return script
+}
+
+//创建发送方为锁定脚本的交易，解锁脚本由unlock根据交易哈希值生成
+func newScriptTransaction(t *testing.T, lock string, timestamp uint32, unlock func(hash []byte) string) *Transaction {
+	pow := ArrayOfBytes(TRANSACTION_POW_COMPLEXITY, POW_PREFIX)
+	tr := NewTransferTransaction(EncodeScript(mustAssemble(t, lock)), newTestKeypair().Public, COIN, 0, []byte("script"))
+	tr.Header.TimeStamp = timestamp
+	tr.Header.Nonce = tr.GenerateNonce(pow)
+	if text := unlock(tr.Hash()); text != "" {
+		tr.Signature = EncodeScript(mustAssemble(t, text))
+	}
+	return tr
+}
+
+func TestScriptAssembleAndDisassemble(t *testing.T) {
+	kp := newTestKeypair()
+	texts := []string{
+		"OP_1 OP_16 OP_0 OP_DROP OP_DUP OP_SWAP OP_EQUAL OP_EQUALVERIFY OP_VERIFY OP_RETURN",
+		"OP_IF OP_SHA256 0x" + hex.EncodeToString(SHA256([]byte("secret"))) + " OP_EQUALVERIFY OP_ELSE 1700000000 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_ENDIF",
+		string(kp.Public) + " OP_CHECKSIG OP_NOTIF OP_2 " + string(kp.Public) + " 0x01 OP_1 OP_CHECKMULTISIG OP_ENDIF",
+		"0x" + strings.Repeat("ab", 300) + " 17 OP_CHECKSIGVERIFY OP_CHECKMULTISIGVERIFY",
+	}
+	for _, text := range texts {
+		script := mustAssemble(t, text)
+		if d, err := Disassemble(script); err != nil || d != text {
+			t.Error("反汇编结果与汇编的文本不同", d, err)
+		}
+		if _, err := ParseScriptKey(EncodeScript(script)); err != nil {
+			t.Error(err)
+		}
+	}
+	//数字0到16使用OP_0到OP_16
+	if s := mustAssemble(t, "0 16 17"); !bytes.Equal(s, []byte{OP_0, OP_16, 1, 17}) {
+		t.Errorf("数字汇编错误：%x", s)
+	}
+
+	invalid := map[string][]byte{
+		"未定义的操作码":          {0xff},
+		"推送数据不完整":          {3, 1, 2},
+		"OP_PUSHDATA1不是最短": {OP_PUSHDATA1, 3, 1, 2, 3},
+		"OP_PUSHDATA2不是最短": append([]byte{OP_PUSHDATA2, 0xff, 0}, make([]byte, 0xff)...),
+		"推送数据过长":           append([]byte{OP_PUSHDATA2, 0x09, 0x02}, make([]byte, MAX_SCRIPT_ELEMENT_SIZE+1)...),
+		"脚本过长":             bytes.Repeat([]byte{OP_1}, MAX_SCRIPT_SIZE+1),
+	}
+	for name, script := range invalid {
+		if _, err := ParseScript(script); err == nil {
+			t.Error("应返回错误：", name)
+		}
+	}
+	for _, text := range []string{"OP_UNKNOWN", "0x", "0xzz", "OP_PUSHDATA1", "abc"} {
+		if _, err := AssembleScript(text); err == nil {
+			t.Error("应返回错误：", text)
+		}
+	}
+	if _, err := ParseScriptKey(EncodeScript(nil)); err == nil {
+		t.Error("空锁定脚本应返回错误")
+	}
+}
+
+func TestScriptOpcodes(t *testing.T) {
+	hash := SHA256([]byte("secret"))
+	tests := []struct {
+		unlock, lock string
+		ok           bool
+	}{
+		{"", "OP_1", true},
+		{"", "OP_0", false},
+		{"", "", false},
+		{"OP_1", "OP_RETURN", false},
+		{"OP_1", "OP_VERIFY OP_1", true},
+		{"OP_0", "OP_VERIFY OP_1", false},
+		{"OP_2", "OP_DUP OP_EQUAL", true},
+		{"OP_2 OP_3", "OP_SWAP OP_2 OP_EQUALVERIFY OP_3 OP_EQUAL", true},
+		{"OP_1 OP_0", "OP_DROP", true},
+		{"", "OP_DROP OP_1", false},
+		{"OP_1", "OP_IF OP_2 OP_ELSE OP_0 OP_ENDIF", true},
+		{"OP_0", "OP_IF OP_2 OP_ELSE OP_0 OP_ENDIF", false},
+		{"OP_0", "OP_NOTIF OP_2 OP_ENDIF", true},
+		{"OP_0", "OP_IF OP_RETURN OP_ELSE OP_1 OP_ENDIF", true},
+		{"OP_0 OP_1", "OP_IF OP_IF OP_RETURN OP_ENDIF OP_1 OP_ENDIF", true},
+		{"OP_1", "OP_IF OP_1", false},
+		{"OP_1", "OP_ENDIF", false},
+		{"0x736563726574", "OP_SHA256 0x" + hex.EncodeToString(hash) + " OP_EQUAL", true},
+		{"0x736563726574", "OP_SHA256 0x" + hex.EncodeToString(SHA256([]byte("other"))) + " OP_EQUAL", false},
+		{"", "1000 OP_CHECKLOCKTIMEVERIFY", true},
+		{"", "1001 OP_CHECKLOCKTIMEVERIFY", false},
+		{"", "0x0001 OP_CHECKLOCKTIMEVERIFY", true},
+		{"", "0x0100 OP_CHECKLOCKTIMEVERIFY", false},
+		{"OP_1 OP_DUP", "OP_EQUAL", false},
+		{"OP_1 OP_DUP", "OP_DROP", false},
+		{"", "OP_1" + strings.Repeat(" OP_DUP OP_DROP", 100), true},
+		{"", "OP_1" + strings.Repeat(" OP_DUP OP_DROP", 101), false},
+		{"", strings.Repeat("OP_1 ", MAX_SCRIPT_STACK_SIZE), true},
+		{"", strings.Repeat("OP_1 ", MAX_SCRIPT_STACK_SIZE+1), false},
+	}
+	for _, tt := range tests {
+		err := ExecuteScript(mustAssemble(t, tt.unlock), mustAssemble(t, tt.lock), ScriptContext{Hash: hash, Time: 1000})
+		if (err == nil) != tt.ok {
+			t.Errorf("解锁脚本 %q 锁定脚本 %.60q 结果错误：%v", tt.unlock, tt.lock, err)
+		}
+	}
+}
+
+func TestScriptSignatures(t *testing.T) {
+	pow := ArrayOfBytes(TRANSACTION_POW_COMPLEXITY, POW_PREFIX)
+	alice, bob := newTestKeypair(), newTestKeypair()
+	sign := func(kp *Keypair) func(hash []byte) string {
+		return func(hash []byte) string {
+			s, _ := kp.Sign(hash)
+			return string(s)
+		}
+	}
+
+	lock := string(alice.Public) + " OP_CHECKSIG"
+	if tr := newScriptTransaction(t, lock, 1000, sign(alice)); !tr.VerifyTransaction(pow) {
+		t.Error("签名正确的脚本交易验证失败", tr.ExecuteScript())
+	}
+	if tr := newScriptTransaction(t, lock, 1000, sign(bob)); tr.VerifyTransaction(pow) {
+		t.Error("其他密钥的签名不应验证通过")
+	}
+	if tr := newScriptTransaction(t, lock, 1000, func([]byte) string { return "" }); tr.VerifyTransaction(pow) {
+		t.Error("没有签名不应验证通过")
+	}
+	//签名的是交易哈希值，修改交易后签名无效
+	tr := newScriptTransaction(t, lock, 1000, sign(alice))
+	tr.Header.Fee++
+	tr.Header.Nonce = tr.GenerateNonce(pow)
+	if tr.VerifyTransaction(pow) {
+		t.Error("修改交易后不应验证通过")
+	}
+	//解锁脚本只能推送数据
+	tr = newScriptTransaction(t, "OP_1", 1000, func([]byte) string { return "OP_1 OP_DROP" })
+	if tr.VerifyTransaction(pow) {
+		t.Error("解锁脚本包含操作码时不应验证通过")
+	}
+	tr.Signature = EncodeScript(nil)
+	if tr.VerifyTransaction(pow) {
+		t.Error("空解锁脚本的签名须为空")
+	}
+
+	//2-of-3多重签名，未签名的位置为OP_0
+	carol := newTestKeypair()
+	keys := fmt.Sprintf("%s %s %s", alice.Public, bob.Public, carol.Public)
+	multisig := "OP_2 " + keys + " OP_3 OP_CHECKMULTISIG"
+	tr = newScriptTransaction(t, multisig, 1000, func(hash []byte) string {
+		return sign(alice)(hash) + " OP_0 " + sign(carol)(hash)
+	})
+	if !tr.VerifyTransaction(pow) {
+		t.Error("多重签名脚本验证失败", tr.ExecuteScript())
+	}
+	tr = newScriptTransaction(t, multisig, 1000, func(hash []byte) string {
+		return sign(alice)(hash) + " OP_0 OP_0"
+	})
+	if tr.VerifyTransaction(pow) {
+		t.Error("签名不足的多重签名脚本不应验证通过")
+	}
+
+	//签名验证次数有限
+	check := "OP_0 " + string(alice.Public) + " OP_CHECKSIG OP_DROP "
+	if err := ExecuteScript(nil, mustAssemble(t, strings.Repeat(check, MAX_SCRIPT_SIGCHECKS)+"OP_1"), ScriptContext{}); err != nil {
+		t.Error(err)
+	}
+	if err := ExecuteScript(nil, mustAssemble(t, strings.Repeat(check, MAX_SCRIPT_SIGCHECKS+1)+"OP_1"), ScriptContext{}); err == nil {
+		t.Error("验证的签名过多时应返回错误")
+	}
+}
+
+//哈希时间锁：bob提供原像后可以花费，到期后alice可以取回
+func TestScriptHashTimeLock(t *testing.T) {
+	pow := ArrayOfBytes(TRANSACTION_POW_COMPLEXITY, POW_PREFIX)
+	alice, bob := newTestKeypair(), newTestKeypair()
+	secret := []byte("secret")
+	lock := fmt.Sprintf("OP_IF OP_SHA256 0x%x OP_EQUALVERIFY %s OP_ELSE 2000000 OP_CHECKLOCKTIMEVERIFY OP_DROP %s OP_ENDIF OP_CHECKSIG",
+		SHA256(secret), bob.Public, alice.Public)
+	claim := func(kp *Keypair, preimage []byte) func(hash []byte) string {
+		return func(hash []byte) string {
+			s, _ := kp.Sign(hash)
+			return fmt.Sprintf("%s 0x%x OP_1", s, preimage)
+		}
+	}
+	refund := func(hash []byte) string {
+		s, _ := alice.Sign(hash)
+		return string(s) + " OP_0"
+	}
+
+	if tr := newScriptTransaction(t, lock, 1000, claim(bob, secret)); !tr.VerifyTransaction(pow) {
+		t.Error("提供原像后验证失败", tr.ExecuteScript())
+	}
+	if tr := newScriptTransaction(t, lock, 1000, claim(bob, []byte("wrong"))); tr.VerifyTransaction(pow) {
+		t.Error("原像错误时不应验证通过")
+	}
+	if tr := newScriptTransaction(t, lock, 1000, refund); tr.VerifyTransaction(pow) {
+		t.Error("时间锁到期前不应能取回")
+	}
+	if tr := newScriptTransaction(t, lock, 2000000, refund); !tr.VerifyTransaction(pow) {
+		t.Error("时间锁到期后取回失败", tr.ExecuteScript())
+	}
+
+	//锁定脚本的地址可以作为接收方
+	script := EncodeScript(mustAssemble(t, lock))
+	if r, err := ParseRecipient(string(script)); err != nil || !bytes.Equal(r, script) {
+		t.Error("锁定脚本应可作为接收方", err)
+	}
+	if AccountOf(script) != string(NewAddress(script)) {
+		t.Error("锁定脚本的账户应为其地址")
+	}
+}
+
+//时间锁与交易时间比较，区块中脚本交易的时间不能晚于区块时间
+func TestScriptTransactionTimeInBlock(t *testing.T) {
+	withParams(t, &RegTestParams)
+	miner := newTestKeypair()
+	tr := newScriptTransaction(t, "2000 OP_CHECKLOCKTIMEVERIFY", 2000, func([]byte) string { return "" })
+	for _, c := range []struct {
+		time uint32
+		ok   bool
+	}{{1999, false}, {2000, true}} {
+		b := newLedgerTestBlock(miner, *tr)
+		b.BlockHeader.TimeStamp, b.BlockHeader.Bits = c.time, RegTestParams.PowLimitBits
+		for !CheckBlockProofofWork(b.BlockHeader.Bits, b.Hash()) {
+			b.BlockHeader.Nonce++
+		}
+		b.Signture = b.Sign(miner)
+		if b.VerifyBlock() != c.ok {
+			t.Error("区块时间", c.time, "验证结果错误")
+		}
+	}
+}
+
+//交易池中时间超前的脚本交易不放入挖出的区块，挖出的区块验证通过
+func TestMineWithFutureScriptTransaction(t *testing.T) {
+	withParams(t, &RegTestParams)
+	prevMiner := self.Miner
+	self.Miner = newTestKeypair()
+	defer func() { self.Miner = prevMiner }()
+
+	bc := NewBlockchain()
+	if err := bc.SetGenesis(chainParams.Genesis()); err != nil {
+		t.Fatal(err)
+	}
+	bc.Mempool = NewMempool(nil)
+	now := uint32(time.Now().Unix())
+	future := newScriptTransaction(t, "OP_1", now+60*60, func([]byte) string { return "" })
+	normal := newTestTransaction(newTestKeypair(), now)
+	for _, tr := range []Transaction{*future, normal} {
+		if err := bc.Mempool.Add(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bc.GenerateBlock() <- bc.CreateNewBlock()
+	select {
+	case b := <-bc.BlocksQueue:
+		if !b.VerifyBlock() || !b.VerifyMerkelRoot(1) {
+			t.Error("挖出的区块验证失败")
+		}
+		if b.TransactionSlice.Len() != 1 || !bytes.Equal((*b.TransactionSlice)[0].Hash(), normal.Hash()) {
+			t.Error("区块不应包含时间超前的脚本交易", b.TransactionSlice.Len())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("挖矿超时")
+	}
+	if !bc.Mempool.Exists(future.Hash()) {
+		t.Error("时间超前的脚本交易应留在交易池中")
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/tv42/base58"
+)
+
+const (
+	SCHEME_P224        = "p224"
+	SCHEME_P256        = "p256"
+	SCHEME_SECP256K1   = "secp256k1"
+	SCHEME_ED25519     = "ed25519"
+	SCHEME_P224_LEGACY = "p224-legacy" //旧版P-224编码，没有前缀，只用于已有的密钥和签名，不能生成新密钥
+
+	SCHEME_SEPARATOR = ':' //算法名称与Base58编码之间的分隔符，不在Base58字母表中
+)
+
+//签名算法，公钥、私钥和签名都是未编码的字节
+type SignatureScheme interface {
+	Name() string
+	GenerateKey() (public, private []byte, err error)
+	Public(private []byte) ([]byte, error) //由私钥计算公钥
+	Sign(private, hash []byte) ([]byte, error)
+	Verify(public, signature, hash []byte) bool
+}
+
+var ErrLegacyKey = errors.New("旧版P-224公钥编码错误，这个公钥无法验证签名")
+
+var signatureSchemes = map[string]SignatureScheme{
+	SCHEME_P224:      ecdsaScheme{SCHEME_P224, elliptic.P224()},
+	SCHEME_P256:      ecdsaScheme{SCHEME_P256, elliptic.P256()},
+	SCHEME_SECP256K1: secp256k1Scheme{},
+	SCHEME_ED25519:   ed25519Scheme{},
+}
+
+//按名称查找签名算法
+func FindSignatureScheme(name string) (SignatureScheme, error) {
+	s, ok := signatureSchemes[name]
+	if !ok {
+		return nil, fmt.Errorf("不支持的签名算法：%s，可选：%s", name, strings.Join(SignatureSchemeNames(), "、"))
+	}
+	return s, nil
+}
+
+//支持的签名算法名称
+func SignatureSchemeNames() []string {
+	names := []string{}
+	for name := range signatureSchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//编码公钥、私钥或签名：算法名称 + ":" + Base58，旧版P-224没有前缀
+func encodeSchemeData(s SignatureScheme, d []byte) []byte {
+	if s.Name() == SCHEME_P224_LEGACY {
+		return encodeBase58(d)
+	}
+	return append([]byte(s.Name()+string(SCHEME_SEPARATOR)), encodeBase58(d)...)
+}
+
+//解码公钥、私钥或签名，返回算法和未编码的字节，没有前缀的为旧版P-224
+func decodeSchemeData(d []byte) (SignatureScheme, []byte, error) {
+	var s SignatureScheme = legacyP224Scheme{}
+	body := string(d)
+	if i := strings.IndexByte(body, SCHEME_SEPARATOR); i >= 0 {
+		var err error
+		if s, err = FindSignatureScheme(body[:i]); err != nil {
+			return nil, nil, err
+		}
+		body = body[i+1:]
+	}
+	raw, err := decodeBase58([]byte(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, raw, nil
+}
+
+//Base58编码，开头的每个0字节编码为"1"，保留定长数据开头的0字节
+func encodeBase58(d []byte) []byte {
+	zeros := 0
+	for zeros < len(d) && d[zeros] == 0 {
+		zeros++
+	}
+	return base58.EncodeBig(ArrayOfBytes(zeros, '1'), new(big.Int).SetBytes(d[zeros:]))
+}
+
+func decodeBase58(s []byte) ([]byte, error) {
+	if len(s) == 0 {
+		return nil, errors.New("Base58数据为空")
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	n, err := base58.DecodeToBig(s[zeros:])
+	if err != nil {
+		return nil, errors.New("Base58格式错误")
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+//NIST曲线ECDSA，公钥为压缩格式，私钥和签名的r、s都是曲线阶的字节长度，开头补0：
+//P-224公钥29字节，私钥28字节，签名56字节；P-256公钥33字节，私钥32字节，签名64字节
+type ecdsaScheme struct {
+	name  string
+	curve elliptic.Curve
+}
+
+func (e ecdsaScheme) Name() string { return e.name }
+
+//私钥、r和s的字节长度
+func (e ecdsaScheme) size() int {
+	return (e.curve.Params().N.BitLen() + 7) / 8
+}
+
+func (e ecdsaScheme) GenerateKey() ([]byte, []byte, error) {
+	k, err := ecdsa.GenerateKey(e.curve, rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return elliptic.MarshalCompressed(e.curve, k.X, k.Y), k.D.FillBytes(make([]byte, e.size())), nil
+}
+
+func (e ecdsaScheme) Public(private []byte) ([]byte, error) {
+	k, err := e.privateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return elliptic.MarshalCompressed(e.curve, k.X, k.Y), nil
+}
+
+func (e ecdsaScheme) Sign(private, hash []byte) ([]byte, error) {
+	k, err := e.privateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, k, hash)
+	if err != nil {
+		return nil, err
+	}
+	return append(r.FillBytes(make([]byte, e.size())), s.FillBytes(make([]byte, e.size()))...), nil
+}
+
+func (e ecdsaScheme) Verify(public, signature, hash []byte) bool {
+	x, y := elliptic.UnmarshalCompressed(e.curve, public)
+	size := e.size()
+	if x == nil || len(signature) != 2*size {
+		return false
+	}
+	r, s := new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:])
+	return ecdsa.Verify(&ecdsa.PublicKey{Curve: e.curve, X: x, Y: y}, hash, r, s)
+}
+
+func (e ecdsaScheme) privateKey(private []byte) (*ecdsa.PrivateKey, error) {
+	d := new(big.Int).SetBytes(private)
+	if len(private) != e.size() || d.Sign() == 0 || d.Cmp(e.curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("%s私钥无效", e.name)
+	}
+	x, y := e.curve.ScalarBaseMult(private)
+	return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: e.curve, X: x, Y: y}, D: d}, nil
+}
+
+//旧版P-224编码，公钥为bigJoin(x, y)，签名为bigJoin(r, s)，私钥为d，都是去掉开头0字节的大整数
+//x或y开头有0字节时公钥可能不能正确拆分，这样的公钥无法验证签名；签名时重新签名直到r和s都是28字节
+type legacyP224Scheme struct{}
+
+func (legacyP224Scheme) Name() string { return SCHEME_P224_LEGACY }
+
+func (legacyP224Scheme) GenerateKey() ([]byte, []byte, error) {
+	return nil, nil, errors.New("旧版P-224编码不能生成新密钥")
+}
+
+func (legacyP224Scheme) Public(private []byte) ([]byte, error) {
+	x, y := elliptic.P224().ScalarBaseMult(private)
+	return bigJoin(26, x, y).Bytes(), nil
+}
+
+func (legacyP224Scheme) Sign(private, hash []byte) ([]byte, error) {
+	curve := elliptic.P224()
+	d := new(big.Int).SetBytes(private)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("P-224私钥无效")
+	}
+	x, y := curve.ScalarBaseMult(private)
+	if pub := splitBig(bigJoin(26, x, y), 2); pub[0].Cmp(x) != 0 || pub[1].Cmp(y) != 0 {
+		return nil, ErrLegacyKey
+	}
+	key := ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+	for {
+		r, s, err := ecdsa.Sign(rand.Reader, &key, hash)
+		if err != nil {
+			return nil, err
+		}
+		if len(r.Bytes()) == KEY_SIZE && len(s.Bytes()) == KEY_SIZE {
+			return bigJoin(KEY_SIZE, r, s).Bytes(), nil
+		}
+	}
+}
+
+func (legacyP224Scheme) Verify(public, signature, hash []byte) bool {
+	pub := splitBig(new(big.Int).SetBytes(public), 2)
+	key := ecdsa.PublicKey{Curve: elliptic.P224(), X: pub[0], Y: pub[1]}
+	sig := splitBig(new(big.Int).SetBytes(signature), 2)
+	return ecdsa.Verify(&key, hash, sig[0], sig[1])
+}
+
+//secp256k1 ECDSA，与比特币相同的曲线，公钥为压缩格式(33字节)，私钥32字节，签名为DER格式，s取较小值
+type secp256k1Scheme struct{}
+
+func (secp256k1Scheme) Name() string { return SCHEME_SECP256K1 }
+
+func (secp256k1Scheme) GenerateKey() ([]byte, []byte, error) {
+	k, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	return k.PubKey().SerializeCompressed(), k.Serialize(), nil
+}
+
+func (secp256k1Scheme) Public(private []byte) ([]byte, error) {
+	k, err := secp256k1PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return k.PubKey().SerializeCompressed(), nil
+}
+
+func (secp256k1Scheme) Sign(private, hash []byte) ([]byte, error) {
+	k, err := secp256k1PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return secpecdsa.Sign(k, hash).Serialize(), nil
+}
+
+func (secp256k1Scheme) Verify(public, signature, hash []byte) bool {
+	pub, err := secp256k1.ParsePubKey(public)
+	if err != nil {
+		return false
+	}
+	sig, err := secpecdsa.ParseDERSignature(signature)
+	if err != nil {
+		return false
+	}
+	return sig.Verify(hash, pub)
+}
+
+func secp256k1PrivateKey(private []byte) (*secp256k1.PrivateKey, error) {
+	d := new(big.Int).SetBytes(private)
+	if len(private) != 32 || d.Sign() == 0 || d.Cmp(secp256k1.S256().N) >= 0 {
+		return nil, errors.New("secp256k1私钥无效")
+	}
+	return secp256k1.PrivKeyFromBytes(private), nil
+}
+
+//Ed25519，公钥32字节，私钥为32字节种子，签名64字节
+type ed25519Scheme struct{}
+
+func (ed25519Scheme) Name() string { return SCHEME_ED25519 }
+
+func (ed25519Scheme) GenerateKey() ([]byte, []byte, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return public, private.Seed(), nil
+}
+
+func (ed25519Scheme) Public(private []byte) ([]byte, error) {
+	if len(private) != ed25519.SeedSize {
+		return nil, errors.New("Ed25519私钥无效")
+	}
+	return ed25519.NewKeyFromSeed(private).Public().(ed25519.PublicKey), nil
+}
+
+func (ed25519Scheme) Sign(private, hash []byte) ([]byte, error) {
+	if len(private) != ed25519.SeedSize {
+		return nil, errors.New("Ed25519私钥无效")
+	}
+	return ed25519.Sign(ed25519.NewKeyFromSeed(private), hash), nil
+}
+
+func (ed25519Scheme) Verify(public, signature, hash []byte) bool {
+	return len(public) == ed25519.PublicKeySize && ed25519.Verify(public, hash, signature)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+//轻节点跟踪的交易
+type TrackedTransaction struct {
+	Transaction *Transaction
+	BlockHash   []byte //包含交易的主链区块哈希值，未确认时为空
+}
+
+//轻节点交易跟踪，记录自己提交的交易和全节点发来的与自己相关的交易
+//交易只有在Merkel证明验证通过、且区块头部在主链上时才算确认
+type TxTracker struct {
+	txs map[string]*TrackedTransaction
+
+	mutex sync.Mutex
+}
+
+//新建交易跟踪
+func NewTxTracker() *TxTracker {
+	return &TxTracker{txs: map[string]*TrackedTransaction{}}
+}
+
+//记录提交的未确认交易
+func (tr *TxTracker) AddPending(t Transaction) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	if tr.txs[string(t.Hash())] == nil {
+		tr.txs[string(t.Hash())] = &TrackedTransaction{Transaction: &t}
+	}
+}
+
+//根据Merkel证明确认交易，证明中没有交易内容时交易须已被跟踪
+//mainHeight返回区块在主链上的高度，不在主链上时返回-1，证明的Merkel树版本须与高度一致
+func (tr *TxTracker) Confirm(p *MerkelProof, mainHeight func(hash []byte) int) error {
+	if !p.Verify() {
+		return errors.New("Merkel证明验证失败")
+	}
+	blockHash := p.Header.Hash()
+	height := mainHeight(blockHash)
+	if height < 0 {
+		return errors.New("区块不在主链上")
+	}
+	if p.Version != MerkelVersion(height) {
+		return errors.New("Merkel树版本与区块高度不符")
+	}
+
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tt := tr.txs[string(p.TxHash)]
+	if tt == nil {
+		if p.Transaction == nil {
+			return errors.New("未知的交易")
+		}
+		tt = &TrackedTransaction{Transaction: p.Transaction}
+		tr.txs[string(p.TxHash)] = tt
+	}
+	if !bytes.Equal(tt.BlockHash, blockHash) {
+		tt.BlockHash = blockHash
+		fmt.Printf("交易 %x 已确认，区块 %x\n", p.TxHash, blockHash)
+	}
+	return nil
+}
+
+//区块头部链重组时，回滚区块中的交易变为未确认
+func (tr *TxTracker) ApplyReorg(event ReorgEvent) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	for _, b := range event.Disconnected {
+		hash := b.Hash()
+		for _, tt := range tr.txs {
+			if bytes.Equal(tt.BlockHash, hash) {
+				tt.BlockHash = nil
+			}
+		}
+	}
+}
+
+//获取跟踪的交易，按时间排序
+func (tr *TxTracker) List() []TrackedTransaction {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	ts := []TrackedTransaction{}
+	for _, tt := range tr.txs {
+		ts = append(ts, *tt)
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].Transaction.Header.TimeStamp < ts[j].Transaction.Header.TimeStamp
+	})
+	return ts
+}
+
+//启动轻节点
+//提交的交易验证后直接广播；收到的区块只验证并保存头部；定期向全节点请求自己的交易证明
+func (bc *Blockchain) RunHeaders() {
+	proofs := time.NewTicker(SPV_PROOF_INTERVAL * time.Second)
+	for {
+		select {
+		//提交交易
+		case tr := <-bc.TransactionsQueue:
+			if !tr.VerifyTransaction(TRANSACTION_POW) {
+				fmt.Println("交易验证失败：", tr)
+				continue
+			}
+			bc.Tracker.AddPending(*tr)
+			mes := NewMessage(MESSAGE_SEND_TRANSACTION)
+			mes.Data, _ = tr.MarshalBinary()
+			self.Network.BroadcastQueue <- *mes
+
+		//区块头部处理
+		case b := <-bc.BlocksQueue:
+			b = b.HeaderOnly()
+			if bc.HasBlock(b.Hash()) {
+				continue
+			}
+			if !b.VerifyHeader() {
+				fmt.Println("区块头部未验证通过")
+				continue
+			}
+			if bc.addOrphan(b) {
+				continue
+			}
+
+			bc.processHeader(b)
+			for _, orphan := range bc.Orphans.Take(b.Hash()) {
+				bc.processHeader(orphan)
+			}
+
+		case <-proofs.C:
+			bc.RequestTxProofs()
+		}
+	}
+}
+
+//将区块头部加入区块头部链，主链变化时请求交易证明
+func (bc *Blockchain) processHeader(b Block) {
+	event, err := bc.AddBlock(b)
+	if err != nil {
+		fmt.Println("添加区块头部失败：", err)
+		return
+	}
+	if event == nil {
+		return
+	}
+	if len(event.Disconnected) > 0 {
+		bc.Tracker.ApplyReorg(*event)
+		select {
+		case bc.ReorgEvents <- *event:
+		default:
+			log.Println("重组事件通道已满，丢弃事件")
+		}
+	}
+	//同步历史区块头部期间不请求
+	if !bc.Sync.Syncing() {
+		bc.RequestTxProofs()
+	}
+}
+
+//向全节点请求与钱包中各公钥相关的交易证明
+func (bc *Blockchain) RequestTxProofs() {
+	publics := [][]byte{self.Keypair.Public}
+	if self.Wallet != nil {
+		publics = publics[:0]
+		for _, name := range self.Wallet.Names() {
+			publics = append(publics, self.Wallet.Public(name))
+		}
+	}
+	for _, public := range publics {
+		mes := NewMessage(MESSAGE_GET_TX_PROOFS)
+		mes.Data = public
+		self.Network.BroadcastQueue <- *mes
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+//新建只保存区块头部的区块链
+func newTestHeaderChain() *Blockchain {
+	bc := NewBlockchain()
+	bc.Ledger, bc.Mempool = nil, nil
+	bc.HeadersOnly = true
+	bc.Tracker = NewTxTracker()
+	return bc
+}
+
+func TestHeadersMarshalling(t *testing.T) {
+	bs := newBranch(nil, 3, "headers")
+	d, err := EncodeHeaders(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, err := DecodeHeaders(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hs) != len(bs) {
+		t.Fatal("区块头部个数错误", len(hs))
+	}
+	for i := range hs {
+		if !reflect.DeepEqual(hs[i].Hash(), bs[i].Hash()) || hs[i].TransactionSlice.Len() != 0 {
+			t.Error("区块头部序列化，反序列化失败", i)
+		}
+	}
+	if _, err := DecodeHeaders(d[:len(d)-1]); err == nil {
+		t.Error("长度错误的区块头部列表应反序列化失败")
+	}
+}
+
+func TestTxTracker(t *testing.T) {
+	bc := newTestHeaderChain()
+	mainBranch := newBranch(nil, 2, "main")
+	for _, b := range mainBranch {
+		if _, err := bc.AddBlock(b.HeaderOnly()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tr := (*mainBranch[1].TransactionSlice)[0]
+	p, _ := mainBranch[1].MerkelProof(tr.Hash(), MERKEL_VERSION_2)
+	bare := *p
+	bare.Transaction = nil
+	if err := bc.Tracker.Confirm(&bare, bc.MainChainHeight); err == nil {
+		t.Error("没有交易内容的未知交易不应确认")
+	}
+	if err := bc.Tracker.Confirm(p, bc.MainChainHeight); err != nil {
+		t.Fatal(err)
+	}
+	ts := bc.Tracker.List()
+	if len(ts) != 1 || !reflect.DeepEqual(ts[0].BlockHash, mainBranch[1].Hash()) {
+		t.Fatal("交易未确认")
+	}
+
+	//分支更长时，回滚区块中的交易变为未确认，不在主链上的证明不能确认交易
+	side := newBranch(mainBranch[0].Hash(), 2, "side")
+	var event *ReorgEvent
+	for _, b := range side {
+		ev, err := bc.AddBlock(b.HeaderOnly())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev != nil && len(ev.Disconnected) > 0 {
+			event = ev
+		}
+	}
+	if event == nil {
+		t.Fatal("区块头部链未重组")
+	}
+	bc.Tracker.ApplyReorg(*event)
+	if ts := bc.Tracker.List(); ts[0].BlockHash != nil {
+		t.Error("回滚区块中的交易应变为未确认")
+	}
+	if err := bc.Tracker.Confirm(p, bc.MainChainHeight); err == nil {
+		t.Error("不在主链上的区块中的交易不应确认")
+	}
+}
+
+func TestKeyTransactions(t *testing.T) {
+	bc := NewBlockchain()
+	bs := newBranch(nil, 3, "keys")
+	alice := [][]byte{}
+	for i := range bs {
+		if i > 0 {
+			bs[i].PreBlock = bs[i-1].Hash()
+		}
+		tr := NewTransaction([]byte("alice"), nil, []byte(RandomString(8)))
+		bs[i].AddTransaction(tr)
+		alice = append(alice, tr.Hash())
+		bs[i].BlockHeader.MerkelRoot = bs[i].GenerateMerkelRoot(MERKEL_VERSION_2)
+		if _, err := bc.AddBlock(bs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hashes := bc.KeyTransactions([]byte("alice"), 2)
+	if len(hashes) != 2 {
+		t.Fatal("交易个数错误", len(hashes))
+	}
+	if !reflect.DeepEqual(hashes[0], alice[2]) || !reflect.DeepEqual(hashes[1], alice[1]) {
+		t.Error("交易应由新到旧排列")
+	}
+	if len(bc.KeyTransactions([]byte("bob"), 2)) != 0 {
+		t.Error("无关公钥不应有交易")
+	}
+}
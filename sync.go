@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+//节点最新区块信息
+type PeerTip struct {
+	Height uint32   //最新区块高度
+	Hash   []byte   //最新区块哈希值，空链时为空
+	Work   *big.Int //主链的累计工作量，空链时为空
+}
+
+//区块同步
+//节点握手时互相通知最新区块，发现对方的链更长时，按批次向对方请求缺失的区块，
+//收到的区块按顺序交给区块链验证，同步得到的历史区块不再广播
+type Syncer struct {
+	peers     map[*Node]PeerTip
+	syncPeer  *Node           //正在同步的节点
+	lastReply time.Time       //最近一次收到同步节点回复的时间
+	synced    map[string]bool //已交给区块链、尚未处理的同步区块
+
+	mutex sync.Mutex
+}
+
+//新建区块同步
+func NewSyncer() *Syncer {
+	return &Syncer{peers: map[*Node]PeerTip{}, synced: map[string]bool{}}
+}
+
+//是否正在同步区块
+func (s *Syncer) Syncing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.syncPeer != nil
+}
+
+//区块是否由同步得到，同时清除记录；同步的区块不广播，挖出和其他节点广播的区块照常广播
+func (s *Syncer) TakeSynced(hash []byte) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	synced := s.synced[string(hash)]
+	delete(s.synced, string(hash))
+	return synced
+}
+
+//移除断开的节点，正在同步的节点断开时换一个节点同步
+func (s *Syncer) RemovePeer(node *Node) {
+	s.mutex.Lock()
+	delete(s.peers, node)
+	restart := s.syncPeer == node
+	if restart {
+		s.syncPeer = nil
+	}
+	s.mutex.Unlock()
+
+	if restart {
+		s.startSync()
+	}
+}
+
+//定期检查同步节点是否超时未回复
+func (s *Syncer) Run() {
+	for range time.Tick(SYNC_TIMEOUT * time.Second) {
+		s.mutex.Lock()
+		peer := s.syncPeer
+		timeout := peer != nil && time.Since(s.lastReply) > SYNC_TIMEOUT*time.Second
+		s.mutex.Unlock()
+
+		if timeout {
+			fmt.Println("同步节点超时未回复：", peer.TCPConn.RemoteAddr())
+			s.RemovePeer(peer)
+		}
+	}
+}
+
+//更新节点的最新区块，对方的链更长时开始同步；不提供区块的轻节点不作为同步节点
+func (s *Syncer) UpdatePeer(node *Node, tip PeerTip) {
+	if node.Version != nil && node.Version.Services&SERVICE_FULL_NODE == 0 {
+		return
+	}
+	s.mutex.Lock()
+	s.peers[node] = tip
+	s.mutex.Unlock()
+
+	s.startSync()
+}
+
+//如果有节点的链比本地工作量更大，选择工作量最大的节点开始同步
+func (s *Syncer) startSync() {
+	local := self.Blockchain.Tip()
+
+	s.mutex.Lock()
+	if s.syncPeer != nil {
+		s.mutex.Unlock()
+		return
+	}
+	var best *Node
+	for node, tip := range s.peers {
+		if tip.Longer(local) && (best == nil || tip.Longer(s.peers[best])) {
+			best = node
+		}
+	}
+	s.syncPeer, s.lastReply = best, time.Now()
+	s.mutex.Unlock()
+
+	if best != nil {
+		fmt.Println("开始同步区块：", best.TCPConn.RemoteAddr(), "本地高度", local.Height)
+		s.requestBlocks(best, self.Blockchain.BlockLocator())
+	}
+}
+
+//向节点请求区块定位器之后的区块，轻节点只请求区块头部
+func (s *Syncer) requestBlocks(node *Node, locator [][]byte) {
+	mes := NewMessage(MESSAGE_GET_BLOCKS)
+	if self.Blockchain.HeadersOnly {
+		mes = NewMessage(MESSAGE_GET_HEADERS)
+	}
+	mes.Data = bytes.Join(locator, nil)
+	if err := node.Send(*mes); err != nil {
+		networkError(err)
+		s.RemovePeer(node)
+	}
+}
+
+//处理同步节点返回的一批区块，按顺序交给区块链验证，批次已满时继续请求
+func (s *Syncer) HandleBlocks(msg Message) {
+	s.mutex.Lock()
+	isSyncPeer := msg.Node != nil && msg.Node == s.syncPeer
+	if isSyncPeer {
+		s.lastReply = time.Now()
+	}
+	s.mutex.Unlock()
+	if !isSyncPeer {
+		return
+	}
+
+	var bs BlockSlice
+	var err error
+	batch := SYNC_BATCH_SIZE
+	if msg.Identifier == MESSAGE_SEND_HEADERS {
+		bs, err = DecodeHeaders(msg.Data)
+		batch = SYNC_HEADERS_BATCH_SIZE
+	} else {
+		bs, err = DecodeBlocks(msg.Data)
+	}
+	if err != nil {
+		networkError(err)
+		s.RemovePeer(msg.Node)
+		return
+	}
+	for _, b := range bs {
+		//轻节点不广播区块，不需要记录
+		if !self.Blockchain.HeadersOnly {
+			s.mutex.Lock()
+			s.synced[string(b.Hash())] = true
+			s.mutex.Unlock()
+		}
+		self.Blockchain.BlocksQueue <- b
+	}
+
+	if len(bs) == batch {
+		//区块可能还在验证中，从收到的最后一个区块继续请求
+		locator := append([][]byte{bs[len(bs)-1].Hash()}, self.Blockchain.BlockLocator()...)
+		s.requestBlocks(msg.Node, locator)
+		return
+	}
+
+	//不满一批说明对方已没有更多区块，清除握手时记录的最新区块，
+	//对方声明的工作量多于实际提供的区块或区块验证失败时，不会反复向其请求同样的区块
+	fmt.Println("区块同步完成：", msg.Node.TCPConn.RemoteAddr())
+	s.mutex.Lock()
+	s.syncPeer = nil
+	if _, ok := s.peers[msg.Node]; ok {
+		s.peers[msg.Node] = PeerTip{}
+	}
+	s.mutex.Unlock()
+	//还有更长的节点时继续同步
+	s.startSync()
+}
+
+//回复区块请求，发送定位器之后主链上的区块
+func HandleGetBlocks(msg Message) {
+	if msg.Reply == nil {
+		return
+	}
+	locator := [][]byte{}
+	for d := msg.Data; len(d) >= 32; d = d[32:] {
+		locator = append(locator, d[:32])
+	}
+
+	reply := NewMessage(MESSAGE_SEND_BLOCKS)
+	data, err := EncodeBlocks(self.Blockchain.BlocksAfter(locator, SYNC_BATCH_SIZE))
+	if err != nil {
+		networkError(err)
+		return
+	}
+	reply.Data = data
+	msg.Respond(*reply)
+}
+
+//回复区块头部请求，发送定位器之后主链上的区块头部
+func HandleGetHeaders(msg Message) {
+	if msg.Reply == nil {
+		return
+	}
+	locator := [][]byte{}
+	for d := msg.Data; len(d) >= 32; d = d[32:] {
+		locator = append(locator, d[:32])
+	}
+
+	reply := NewMessage(MESSAGE_SEND_HEADERS)
+	data, err := EncodeHeaders(self.Blockchain.BlocksAfter(locator, SYNC_HEADERS_BATCH_SIZE))
+	if err != nil {
+		networkError(err)
+		return
+	}
+	reply.Data = data
+	msg.Respond(*reply)
+}
+
+//对方的链是否比本地更长，按累计工作量比较，高度相同工作量不同时工作量大的更长
+func (t PeerTip) Longer(local PeerTip) bool {
+	if len(t.Hash) == 0 {
+		return false
+	}
+	return len(local.Hash) == 0 || t.work().Cmp(local.work()) > 0
+}
+
+func (t PeerTip) work() *big.Int {
+	if t.Work == nil {
+		return new(big.Int)
+	}
+	return t.Work
+}
+
+//序列化最新区块信息：高度(4字节) + 哈希值(32字节) + 累计工作量(32字节)
+func (t PeerTip) MarshalBinary() ([]byte, error) {
+	if t.work().BitLen() > 256 {
+		return nil, errors.New("累计工作量过大")
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, t.Height)
+	buf.Write(FitBytesInto(t.Hash, 32))
+	buf.Write(FitBytesInto(t.work().Bytes(), 32))
+	return buf.Bytes(), nil
+}
+
+//反序列化最新区块信息
+func DecodeTip(d []byte) (PeerTip, error) {
+	t := PeerTip{}
+	if len(d) < TIP_SIZE {
+		return t, errors.New("最新区块信息长度不足")
+	}
+	t.Height = binary.LittleEndian.Uint32(d[:4])
+	//区块哈希值前面通常为0，不能去掉前导0
+	if len(StripByte(d[4:36], 0)) != 0 {
+		t.Hash = d[4:36]
+		t.Work = new(big.Int).SetBytes(d[36:TIP_SIZE])
+	}
+	return t, nil
+}
+
+//序列化区块列表，每个区块为 长度(4字节) + 区块数据
+func EncodeBlocks(bs BlockSlice) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, b := range bs {
+		d, err := b.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		binary.Write(buf, binary.LittleEndian, uint32(len(d)))
+		buf.Write(d)
+	}
+	return buf.Bytes(), nil
+}
+
+//反序列化区块列表
+func DecodeBlocks(d []byte) (BlockSlice, error) {
+	bs := BlockSlice{}
+	buf := bytes.NewBuffer(d)
+	for buf.Len() > 0 {
+		if buf.Len() < 4 {
+			return nil, errors.New("区块列表长度错误")
+		}
+		l := int(binary.LittleEndian.Uint32(buf.Next(4)))
+		if buf.Len() < l {
+			return nil, errors.New("区块列表长度错误")
+		}
+		b := new(Block)
+		if err := b.UnmarshalBinary(buf.Next(l)); err != nil {
+			return nil, err
+		}
+		bs = append(bs, *b)
+	}
+	return bs, nil
+}
+
+//序列化区块头部列表，每个区块为 头部 + 签名
+func EncodeHeaders(bs BlockSlice) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, b := range bs {
+		d, err := b.BlockHeader.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(d)
+		if err := writeVarBytes(buf, b.Signture); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+//反序列化区块头部列表，返回只有头部的区块
+func DecodeHeaders(d []byte) (BlockSlice, error) {
+	buf := bytes.NewBuffer(d)
+	bs := BlockSlice{}
+	for buf.Len() > 0 {
+		b := &Block{BlockHeader: new(BlockHeader), TransactionSlice: new(TransactionSlice)}
+		if err := b.BlockHeader.readBinary(buf); err != nil {
+			return nil, err
+		}
+		signature, err := readVarBytes(buf)
+		if err != nil {
+			return nil, errors.New("区块头部列表长度错误")
+		}
+		b.Signture = signature
+		bs = append(bs, *b)
+	}
+	return bs, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestTipMarshalling(t *testing.T) {
+	tip := PeerTip{Height: 12, Hash: append([]byte{0, 0, 0}, []byte(RandomString(29))...), Work: big.NewInt(5000)}
+	d, _ := tip.MarshalBinary()
+	newTip, err := DecodeTip(d)
+	if err != nil || !reflect.DeepEqual(tip, newTip) {
+		t.Error("最新区块信息序列化，反序列化失败")
+	}
+
+	empty, _ := PeerTip{}.MarshalBinary()
+	newTip, err = DecodeTip(empty)
+	if err != nil || newTip.Hash != nil || newTip.Longer(tip) || !tip.Longer(newTip) {
+		t.Error("空链的最新区块信息错误")
+	}
+
+	//高度较低但工作量更大的链更长
+	lower := PeerTip{Height: 10, Hash: tip.Hash, Work: big.NewInt(6000)}
+	if !lower.Longer(tip) || tip.Longer(lower) {
+		t.Error("应按累计工作量比较链的长短")
+	}
+	higher := PeerTip{Height: 20, Hash: tip.Hash, Work: big.NewInt(5000)}
+	if higher.Longer(tip) {
+		t.Error("工作量相同时不应更长")
+	}
+}
+
+//同步得到的区块只标记一次，其他区块照常广播
+func TestTakeSynced(t *testing.T) {
+	s := NewSyncer()
+	s.synced["a"] = true
+	if !s.TakeSynced([]byte("a")) || s.TakeSynced([]byte("a")) || s.TakeSynced([]byte("b")) {
+		t.Error("同步区块标记错误")
+	}
+}
+
+func TestBlocksMarshalling(t *testing.T) {
+	bs := newStoreTestBlocks(3)
+	d, err := EncodeBlocks(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newBs, err := DecodeBlocks(d)
+	if err != nil || len(newBs) != len(bs) {
+		t.Fatal("区块列表序列化，反序列化失败", err)
+	}
+	for i := range bs {
+		if !reflect.DeepEqual(bs[i].Hash(), newBs[i].Hash()) {
+			t.Error("区块不一致", i)
+		}
+	}
+	if _, err := DecodeBlocks(d[:len(d)-1]); err == nil {
+		t.Error("不完整的区块列表未被拒绝")
+	}
+}
+
+func TestBlocksAfterLocator(t *testing.T) {
+	bc := NewBlockchain()
+	chain := newBranch(nil, 30, "sync")
+	for _, b := range chain {
+		if _, err := bc.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if tip := bc.Tip(); tip.Height != 29 || tip.Work.Cmp(bc.Tree.Best.Work) != 0 {
+		t.Error("最新区块信息应包含主链的累计工作量")
+	}
+	locator := bc.BlockLocator()
+	if !reflect.DeepEqual(locator[0], chain[29].Hash()) || !reflect.DeepEqual(locator[len(locator)-1], chain[0].Hash()) {
+		t.Error("区块定位器应包含最新区块和第一个区块")
+	}
+
+	//对方只有前10个区块
+	bs := bc.BlocksAfter([][]byte{chain[9].Hash(), chain[0].Hash()}, 15)
+	if len(bs) != 15 || !reflect.DeepEqual(bs[0].Hash(), chain[10].Hash()) {
+		t.Error("返回的区块错误", len(bs))
+	}
+	//对方没有任何区块
+	if bs := bc.BlocksAfter(nil, 100); len(bs) != 30 {
+		t.Error("应从第一个区块开始返回", len(bs))
+	}
+	if bs := bc.BlocksAfter(locator, 100); len(bs) != 0 {
+		t.Error("已同步时不应返回区块", len(bs))
+	}
+}
+
+//节点声明的工作量多于实际提供的区块时，同步结束后不再向其重复请求
+func TestSyncPeerUnderDelivers(t *testing.T) {
+	prevBlockchain := self.Blockchain
+	self.Blockchain = NewBlockchain()
+	t.Cleanup(func() { self.Blockchain = prevBlockchain })
+	if err := self.Blockchain.SetGenesis(chainParams.Genesis()); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			defer c.Close()
+			c.Read(make([]byte, 1024))
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	node := &Node{TCPConn: conn.(*net.TCPConn)}
+
+	s := self.Blockchain.Sync
+	s.UpdatePeer(node, PeerTip{Height: 100, Hash: SHA256([]byte("claimed")), Work: big.NewInt(1 << 40)})
+	if !s.Syncing() {
+		t.Fatal("对方的链更长时应开始同步")
+	}
+	//对方没有返回任何区块
+	s.HandleBlocks(Message{Identifier: MESSAGE_SEND_BLOCKS, Node: node})
+	if s.Syncing() {
+		t.Error("对方没有更多区块时不应再向其请求")
+	}
+	if s.peers[node].Longer(self.Blockchain.Tip()) {
+		t.Error("应清除对方声明的最新区块")
+	}
+}
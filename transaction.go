@@ -11,17 +11,35 @@ import (
 //交易信息结构
 type Transaction struct {
 	Header    TranscationHeader
-	Signature []byte //签名
-	Payload   []byte //交易详情
+	Signature []byte     //签名
+	Payload   []byte     //交易详情
+	Inputs    []OutPoint //UTXO模式下花费的输出，必须属于交易发送方
+	Outputs   []TxOutput //UTXO模式下新建的输出
+}
+
+//输出位置：交易哈希值 + 输出序号
+type OutPoint struct {
+	Hash  []byte
+	Index uint32
+}
+
+//交易输出，金额锁定到接收方公钥
+type TxOutput struct {
+	Amount uint64
+	To     []byte
 }
 
 //交易信息头部结构
 type TranscationHeader struct {
 	From          []byte //交易发送方
 	To            []byte //交易接受方
+	Amount        uint64 //转账金额
+	Fee           uint64 //手续费，由打包区块的记账者获得
 	TimeStamp     uint32 //时间戳
 	PayloadHash   []byte //sha256(交易数据)
 	PayloadLength uint32 //交易数据长度
+	InputCount    uint16 //输入个数
+	OutputCount   uint16 //输出个数
 	Nonce         uint32 //随机数
 }
 
@@ -35,10 +53,54 @@ func NewTransaction(from, to, payload []byte) *Transaction {
 	return &t
 }
 
-//获取交易头部哈希值
+//创建转账交易
+func NewTransferTransaction(from, to []byte, amount, fee uint64, payload []byte) *Transaction {
+	t := NewTransaction(from, to, payload)
+	t.Header.Amount, t.Header.Fee = amount, fee
+	return t
+}
+
+//创建UTXO交易，花费inputs，输入总额须等于输出总额+手续费
+func NewUTXOTransaction(from []byte, inputs []OutPoint, outputs []TxOutput, fee uint64, payload []byte) *Transaction {
+	t := NewTransaction(from, nil, payload)
+	t.Inputs, t.Outputs = inputs, outputs
+	t.Header.InputCount, t.Header.OutputCount = uint16(len(inputs)), uint16(len(outputs))
+	t.Header.Fee = fee
+	return t
+}
+
+//交易发送方需要支付的金额：转账金额+手续费
+func (t *Transaction) Cost() uint64 {
+	return t.Header.Amount + t.Header.Fee
+}
+
+//获取与交易相关的账户地址：发送方、接收方和输出的接收方
+func (t *Transaction) Keys() []string {
+	keys := []string{}
+	add := func(key []byte) {
+		account := AccountOf(key)
+		for _, k := range keys {
+			if k == account {
+				return
+			}
+		}
+		if account != "" {
+			keys = append(keys, account)
+		}
+	}
+	add(t.Header.From)
+	add(t.Header.To)
+	for _, o := range t.Outputs {
+		add(o.To)
+	}
+	return keys
+}
+
+//获取交易哈希值，包括头部和输入输出
 func (t *Transaction) Hash() []byte {
 	txhb, _ := t.Header.MarshalBinary()
-	return SHA256(txhb)
+	iob, _ := t.marshalInputsOutputs()
+	return SHA256(append(txhb, iob...))
 }
 
 //生成交易信息签名
@@ -48,14 +110,41 @@ func (t *Transaction) Sign(keypair *Keypair) []byte {
 }
 
 //验证交易信息
-//验证签名，payloadHash和Pow
+//验证签名或脚本，payloadHash和Pow，以及金额是否有效；余额是否足够需要由账本验证
 func (t *Transaction) VerifyTransaction(pow []byte) bool {
+	//转账金额加手续费不能溢出，转账必须有接收方
+	if t.Cost() < t.Header.Amount || (t.Header.Amount > 0 && len(t.Header.To) == 0) {
+		return false
+	}
+	//公钥和签名须能序列化
+	if len(t.Header.From) > MAX_KEY_SIZE || len(t.Header.To) > MAX_KEY_SIZE || len(t.Signature) > MAX_KEY_SIZE {
+		return false
+	}
+	if int(t.Header.InputCount) != len(t.Inputs) || int(t.Header.OutputCount) != len(t.Outputs) {
+		return false
+	}
+	//输出金额必须大于0，总额加手续费不能溢出
+	total := t.Header.Fee
+	for _, o := range t.Outputs {
+		if o.Amount == 0 || len(o.To) == 0 || len(o.To) > MAX_KEY_SIZE || total+o.Amount < total {
+			return false
+		}
+		total += o.Amount
+	}
 	headHash := t.Hash()
 	payloadHash := SHA256(t.Payload)
 
 	return reflect.DeepEqual(payloadHash, t.Header.PayloadHash) &&
 		CheckProofofWork(pow, headHash) &&
-		SignatureVerify(t.Header.From, t.Signature, headHash)
+		t.verifySignature(headHash)
+}
+
+//验证签名，发送方为锁定脚本时执行解锁脚本和锁定脚本
+func (t *Transaction) verifySignature(hash []byte) bool {
+	if IsScript(t.Header.From) {
+		return t.ExecuteScript() == nil
+	}
+	return SignatureVerify(t.Header.From, t.Signature, hash)
 }
 
 //获取满足难度的随机值
@@ -70,43 +159,101 @@ func (t *Transaction) GenerateNonce(prefix []byte) uint32 {
 	return newT.Header.Nonce
 }
 
-//序列化交易信息
+//序列化交易信息：头部 + 签名(变长) + 交易数据 + 输入输出
 func (t *Transaction) MarshalBinary() ([]byte, error) {
-	headerByters, _ := t.Header.MarshalBinary()
-
-	if len(headerByters) != TRANSCATION_HEADER_SIZE {
-		return nil, errors.New("序列化交易头部信息失败")
+	headerByters, err := t.Header.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(headerByters)
+	if err := writeVarBytes(buf, t.Signature); err != nil {
+		return nil, err
 	}
+	buf.Write(t.Payload)
+	iob, err := t.marshalInputsOutputs()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(iob)
+	return buf.Bytes(), nil
+}
 
-	return append(append(headerByters, FitBytesInto(t.Signature, NETWORK_KEY_SIZE)...), t.Payload...), nil
+//序列化输入输出，每个输入为 交易哈希值(32字节) + 序号(4字节)，每个输出为 金额(8字节) + 公钥(变长)
+func (t *Transaction) marshalInputsOutputs() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, in := range t.Inputs {
+		buf.Write(FitBytesInto(in.Hash, 32))
+		binary.Write(buf, binary.LittleEndian, in.Index)
+	}
+	for _, o := range t.Outputs {
+		binary.Write(buf, binary.LittleEndian, o.Amount)
+		if err := writeVarBytes(buf, o.To); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
 }
 
-//反序列化交易信息
+//反序列化交易信息，返回剩余的字节
 func (t *Transaction) UnmarshalBinary(d []byte) ([]byte, error) {
 	buf := bytes.NewBuffer(d)
-	if len(d) < TRANSCATION_HEADER_SIZE+NETWORK_KEY_SIZE {
+	if len(d) < TRANSCATION_HEADER_SIZE+1 {
 		return nil, errors.New("交易字节长度小于反序列化要求的长度")
 	}
 	header := &TranscationHeader{}
-	if err := header.UnmarshalBinary(buf.Next(TRANSCATION_HEADER_SIZE)); err != nil {
+	if err := header.readBinary(buf); err != nil {
 		return nil, err
 	}
 	t.Header = *header
-	t.Signature = StripByte(buf.Next(NETWORK_KEY_SIZE), 0)
-	t.Payload = buf.Next(int(t.Header.PayloadLength))
+	var err error
+	if t.Signature, err = readVarBytes(buf); err != nil {
+		return nil, err
+	}
+	if t.Payload, err = readBytes(buf, int(t.Header.PayloadLength)); err != nil {
+		return nil, errors.New("交易数据长度不足")
+	}
+
+	t.Inputs, t.Outputs = nil, nil
+	if buf.Len() < int(t.Header.InputCount)*(32+4)+int(t.Header.OutputCount)*(8+1) {
+		return nil, errors.New("交易输入输出长度不足")
+	}
+	for i := 0; i < int(t.Header.InputCount); i++ {
+		in := OutPoint{Hash: buf.Next(32)}
+		in.Index = binary.LittleEndian.Uint32(buf.Next(4))
+		t.Inputs = append(t.Inputs, in)
+	}
+	for i := 0; i < int(t.Header.OutputCount); i++ {
+		amount, err := readBytes(buf, 8)
+		if err != nil {
+			return nil, errors.New("交易输入输出长度不足")
+		}
+		o := TxOutput{Amount: binary.LittleEndian.Uint64(amount)}
+		if o.To, err = readVarBytes(buf); err != nil {
+			return nil, errors.New("交易输入输出长度不足")
+		}
+		t.Outputs = append(t.Outputs, o)
+	}
 
 	return buf.Next(MaxInt), nil
 }
 
-//序列化交易头部信息
+//序列化交易头部信息，From和To为变长字段：长度(1字节) + 公钥
 func (th *TranscationHeader) MarshalBinary() ([]byte, error) {
 	buf := new(bytes.Buffer)
 
-	buf.Write(FitBytesInto(th.From, NETWORK_KEY_SIZE))
-	buf.Write(FitBytesInto(th.To, NETWORK_KEY_SIZE))
+	if err := writeVarBytes(buf, th.From); err != nil {
+		return nil, err
+	}
+	if err := writeVarBytes(buf, th.To); err != nil {
+		return nil, err
+	}
+	binary.Write(buf, binary.LittleEndian, th.Amount)
+	binary.Write(buf, binary.LittleEndian, th.Fee)
 	binary.Write(buf, binary.LittleEndian, th.TimeStamp)
 	buf.Write(FitBytesInto(th.PayloadHash, 32))
 	binary.Write(buf, binary.LittleEndian, th.PayloadLength)
+	binary.Write(buf, binary.LittleEndian, th.InputCount)
+	binary.Write(buf, binary.LittleEndian, th.OutputCount)
 	binary.Write(buf, binary.LittleEndian, th.Nonce)
 
 	return buf.Bytes(), nil
@@ -114,13 +261,35 @@ func (th *TranscationHeader) MarshalBinary() ([]byte, error) {
 
 //反序列化交易头部信息
 func (th *TranscationHeader) UnmarshalBinary(d []byte) error {
-
 	buf := bytes.NewBuffer(d)
-	th.From = StripByte(buf.Next(NETWORK_KEY_SIZE), 0)
-	th.To = StripByte(buf.Next(NETWORK_KEY_SIZE), 0)
+	if err := th.readBinary(buf); err != nil {
+		return err
+	}
+	if buf.Len() != 0 {
+		return errors.New("交易头部长度错误")
+	}
+	return nil
+}
+
+//从buf中读取交易头部信息
+func (th *TranscationHeader) readBinary(buf *bytes.Buffer) error {
+	var err error
+	if th.From, err = readVarBytes(buf); err != nil {
+		return err
+	}
+	if th.To, err = readVarBytes(buf); err != nil {
+		return err
+	}
+	if buf.Len() < TRANSCATION_HEADER_SIZE-2 {
+		return errors.New("交易头部长度不足")
+	}
+	binary.Read(bytes.NewBuffer(buf.Next(8)), binary.LittleEndian, &th.Amount)
+	binary.Read(bytes.NewBuffer(buf.Next(8)), binary.LittleEndian, &th.Fee)
 	binary.Read(bytes.NewBuffer(buf.Next(4)), binary.LittleEndian, &th.TimeStamp)
 	th.PayloadHash = buf.Next(32)
 	binary.Read(bytes.NewBuffer(buf.Next(4)), binary.LittleEndian, &th.PayloadLength)
+	binary.Read(bytes.NewBuffer(buf.Next(2)), binary.LittleEndian, &th.InputCount)
+	binary.Read(bytes.NewBuffer(buf.Next(2)), binary.LittleEndian, &th.OutputCount)
 	binary.Read(bytes.NewBuffer(buf.Next(4)), binary.LittleEndian, &th.Nonce)
 
 	return nil
@@ -149,7 +318,10 @@ func (ts TransactionSlice) AddTransaction(tr Transaction) TransactionSlice {
 	//交易按时间排序
 	for i, t := range ts {
 		if t.Header.TimeStamp >= tr.Header.TimeStamp {
-			return append(append(ts[:i], tr), ts[i:]...)
+			ts = append(ts, Transaction{})
+			copy(ts[i+1:], ts[i:])
+			ts[i] = tr
+			return ts
 		}
 	}
 	return append(ts, tr)
@@ -171,7 +343,7 @@ func (ts TransactionSlice) MarshalBinary() ([]byte, error) {
 //反序列化交易队列
 func (ts *TransactionSlice) UnmarshalBinary(d []byte) error {
 	remaining := d
-	for len(remaining) > TRANSCATION_HEADER_SIZE+NETWORK_KEY_SIZE {
+	for len(remaining) >= TRANSCATION_HEADER_SIZE+1 {
 		t := new(Transaction)
 		rem, err := t.UnmarshalBinary(remaining)
 		if err != nil {
@@ -65,3 +65,36 @@ func TestIncorrectTransationSignatureVerfication(t *testing.T) {
 		t.Error("错误的密钥对可以验证通过")
 	}
 }
+
+//测试不同签名算法的公钥和签名序列化为变长字段
+func TestTransactionSchemes(t *testing.T) {
+	pow := ArrayOfBytes(TRANSACTION_POW_COMPLEXITY, POW_PREFIX)
+	from, _ := GenerateKeypair(SCHEME_ED25519)
+	to, _ := GenerateKeypair(SCHEME_SECP256K1)
+	out, _ := GenerateKeypair(SCHEME_P256)
+	tr := NewUTXOTransaction(from.Public, []OutPoint{{Hash: SHA256([]byte("in")), Index: 1}},
+		[]TxOutput{{Amount: 5, To: out.Public}, {Amount: 3, To: to.Public}}, 1, []byte("schemes"))
+	tr.Header.Nonce = tr.GenerateNonce(pow)
+	tr.Signature = tr.Sign(from)
+
+	data, err := tr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newT := &Transaction{}
+	rem, err := newT.UnmarshalBinary(data)
+	if err != nil || len(rem) != 0 || !reflect.DeepEqual(*newT, *tr) {
+		t.Fatal("序列化，反序列化失败", err)
+	}
+	if !newT.VerifyTransaction(pow) {
+		t.Error("验证交易失败")
+	}
+	if _, err := newT.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Error("长度不足应返回错误")
+	}
+
+	tr.Header.To = ArrayOfBytes(MAX_KEY_SIZE+1, 'a')
+	if _, err := tr.MarshalBinary(); err == nil || tr.VerifyTransaction(pow) {
+		t.Error("公钥过长应无法序列化")
+	}
+}
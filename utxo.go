@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//UTXO账本，保存主链上所有未花费的输出
+//每个区块的奖励和手续费是一个隐含的输出，位置为 区块哈希值 + 序号0，锁定到记账者公钥
+//输出可以锁定到公钥或地址，发送方公钥的地址与输出的账户相同(AccountOf)即可花费
+type UTXOLedger struct {
+	utxos utxoMap                    //未花费的输出，键为输出位置
+	undo  map[string][]UnspentOutput //区块哈希值 -> 该区块花费的输出，用于回滚
+
+	mutex sync.RWMutex
+}
+
+//未花费的输出
+type UnspentOutput struct {
+	OutPoint
+	TxOutput
+}
+
+//新建UTXO账本
+func NewUTXOLedger() *UTXOLedger {
+	return &UTXOLedger{utxos: utxoMap{}, undo: map[string][]UnspentOutput{}}
+}
+
+//输出位置的键
+func (op OutPoint) Key() string {
+	buf := bytes.NewBuffer(FitBytesInto(op.Hash, 32))
+	binary.Write(buf, binary.LittleEndian, op.Index)
+	return buf.String()
+}
+
+//区块奖励输出的位置
+func CoinbaseOutPoint(b Block) OutPoint {
+	return OutPoint{Hash: b.Hash(), Index: 0}
+}
+
+//获取未花费的输出
+func (l *UTXOLedger) Get(op OutPoint) (TxOutput, bool) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	o, ok := l.utxos[op.Key()]
+	return o, ok
+}
+
+//获取地址或公钥的余额：锁定到该账户的未花费输出总额
+func (l *UTXOLedger) Balance(public []byte) uint64 {
+	balance := uint64(0)
+	for _, u := range l.Unspent(public) {
+		balance += u.Amount
+	}
+	return balance
+}
+
+//获取锁定到地址或公钥所属账户的未花费输出，按位置排序
+func (l *UTXOLedger) Unspent(public []byte) []UnspentOutput {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	account := AccountOf(public)
+	us := []UnspentOutput{}
+	for k, o := range l.utxos {
+		if AccountOf(o.To) == account {
+			us = append(us, UnspentOutput{decodeOutPointKey(k), o})
+		}
+	}
+	sort.Slice(us, func(i, j int) bool { return us[i].Key() < us[j].Key() })
+	return us
+}
+
+//检查交易的输入是否都是发送方未花费的输出，且输入总额等于输出总额+手续费
+func (l *UTXOLedger) CheckTransaction(t Transaction, pending uint64) error {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	_, err := l.checkTransaction(t, l.utxos)
+	return err
+}
+
+//连接区块：逐个花费交易的输入并添加输出，最后添加区块奖励输出
+//同一区块中的交易可以花费前面交易的输出，重复花费的区块返回错误，账本不变
+func (l *UTXOLedger) ConnectBlock(b Block) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	//未花费输出的变化，值为nil表示被花费
+	changed := map[string]*TxOutput{}
+	view := utxoView{l.utxos, changed}
+	spent := []UnspentOutput{}
+	fees := uint64(BLOCK_REWARD)
+
+	for _, t := range *b.TransactionSlice {
+		ins, err := l.checkTransaction(t, view)
+		if err != nil {
+			return fmt.Errorf("交易 %x %v", t.Hash(), err)
+		}
+		for i, in := range t.Inputs {
+			spent = append(spent, UnspentOutput{in, ins[i]})
+			changed[in.Key()] = nil
+		}
+		hash := t.Hash()
+		for i, o := range t.Outputs {
+			o := o
+			changed[OutPoint{hash, uint32(i)}.Key()] = &o
+		}
+		if fees+t.Header.Fee < fees {
+			return fmt.Errorf("交易 %x 手续费溢出", hash)
+		}
+		fees += t.Header.Fee
+	}
+	coinbase := CoinbaseOutPoint(b)
+	if _, ok := view.get(coinbase.Key()); ok {
+		return errors.New("区块奖励输出已存在")
+	}
+	changed[coinbase.Key()] = &TxOutput{Amount: fees, To: b.BlockHeader.Origin}
+
+	for k, o := range changed {
+		if o == nil {
+			delete(l.utxos, k)
+		} else {
+			l.utxos[k] = *o
+		}
+	}
+	l.undo[string(b.Hash())] = spent
+	return nil
+}
+
+//回滚区块：恢复被花费的输出，移除区块中交易的输出和区块奖励输出
+//区块中新建又被花费的输出先恢复再移除
+func (l *UTXOLedger) DisconnectBlock(b Block) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, s := range l.undo[string(b.Hash())] {
+		l.utxos[s.Key()] = s.TxOutput
+	}
+	delete(l.undo, string(b.Hash()))
+
+	delete(l.utxos, CoinbaseOutPoint(b).Key())
+	for _, t := range *b.TransactionSlice {
+		hash := t.Hash()
+		for i := range t.Outputs {
+			delete(l.utxos, OutPoint{hash, uint32(i)}.Key())
+		}
+	}
+}
+
+//从锁定到public的未花费输出中选取总额不少于amount的输入，跳过isSpent返回true的输出，返回输入和找零
+func (l *UTXOLedger) SelectInputs(public []byte, amount uint64, isSpent func(OutPoint) bool) ([]OutPoint, uint64, error) {
+	ins, total := []OutPoint{}, uint64(0)
+	for _, u := range l.Unspent(public) {
+		if total >= amount {
+			break
+		}
+		if isSpent != nil && isSpent(u.OutPoint) {
+			continue
+		}
+		ins = append(ins, u.OutPoint)
+		total += u.Amount
+	}
+	if total < amount {
+		return nil, 0, fmt.Errorf("余额不足：%d，需要 %d", total, amount)
+	}
+	return ins, total - amount, nil
+}
+
+//检查交易，返回交易花费的输出
+func (l *UTXOLedger) checkTransaction(t Transaction, utxos outputGetter) ([]TxOutput, error) {
+	if t.Header.Amount != 0 {
+		return nil, errors.New("UTXO模式不支持账户转账")
+	}
+	ins := []TxOutput{}
+	seen := map[string]bool{}
+	total := uint64(0)
+	for _, in := range t.Inputs {
+		k := in.Key()
+		o, ok := utxos.get(k)
+		if !ok || seen[k] {
+			return nil, fmt.Errorf("输入 %x:%d 不存在或已花费", in.Hash, in.Index)
+		}
+		if AccountOf(o.To) != AccountOf(t.Header.From) {
+			return nil, fmt.Errorf("输入 %x:%d 不属于交易发送方", in.Hash, in.Index)
+		}
+		if total+o.Amount < total {
+			return nil, errors.New("输入总额溢出")
+		}
+		seen[k] = true
+		total += o.Amount
+		ins = append(ins, o)
+	}
+
+	out := t.Header.Fee
+	for _, o := range t.Outputs {
+		if out+o.Amount < out {
+			return nil, errors.New("输出总额溢出")
+		}
+		out += o.Amount
+	}
+	if total != out {
+		return nil, fmt.Errorf("输入总额 %d 不等于输出总额加手续费 %d", total, out)
+	}
+	return ins, nil
+}
+
+//未花费输出的查询接口
+type outputGetter interface {
+	get(key string) (TxOutput, bool)
+}
+
+//账本中的未花费输出
+type utxoMap map[string]TxOutput
+
+func (m utxoMap) get(key string) (TxOutput, bool) {
+	o, ok := m[key]
+	return o, ok
+}
+
+//账本加上区块中已处理交易的变化
+type utxoView struct {
+	base    utxoMap
+	changed map[string]*TxOutput
+}
+
+func (v utxoView) get(key string) (TxOutput, bool) {
+	if o, ok := v.changed[key]; ok {
+		if o == nil {
+			return TxOutput{}, false
+		}
+		return *o, true
+	}
+	return v.base.get(key)
+}
+
+func decodeOutPointKey(k string) OutPoint {
+	return OutPoint{Hash: []byte(k[:32]), Index: binary.LittleEndian.Uint32([]byte(k[32:]))}
+}
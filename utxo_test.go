@@ -0,0 +1,155 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+//生成已签名的UTXO交易
+func newTestUTXOTransaction(kp *Keypair, ins []OutPoint, outs []TxOutput, fee uint64) Transaction {
+	tr := NewUTXOTransaction(kp.Public, ins, outs, fee, []byte(RandomString(8)))
+	tr.Header.Nonce = tr.GenerateNonce(TRANSACTION_POW)
+	tr.Signature = tr.Sign(kp)
+	return *tr
+}
+
+func TestUTXOTransactionMarshalling(t *testing.T) {
+	kp := newTestKeypair()
+	tr := newTestUTXOTransaction(kp,
+		[]OutPoint{{SHA256([]byte("a")), 0}, {SHA256([]byte("b")), 3}},
+		[]TxOutput{{Amount: 5, To: []byte("bob")}}, 1)
+
+	data, err := tr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newT := &Transaction{}
+	rem, err := newT.UnmarshalBinary(append(data, 9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*newT, tr) || len(rem) != 1 {
+		t.Error("序列化，反序列化失败")
+	}
+	if !newT.VerifyTransaction(TRANSACTION_POW) {
+		t.Error("验证交易失败")
+	}
+
+	//交易哈希值包含输出，修改输出后签名无效
+	newT.Outputs[0].To = []byte("eve")
+	if newT.VerifyTransaction(TRANSACTION_POW) {
+		t.Error("修改输出后交易不应验证通过")
+	}
+}
+
+func TestUTXOLedger(t *testing.T) {
+	miner, bob := newTestKeypair(), newTestKeypair()
+	l := NewUTXOLedger()
+
+	b1 := newLedgerTestBlock(miner)
+	if err := l.ConnectBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	if l.Balance(miner.Public) != BLOCK_REWARD {
+		t.Error("区块奖励未记入记账者", l.Balance(miner.Public))
+	}
+
+	coinbase := CoinbaseOutPoint(b1)
+	pay := newTestUTXOTransaction(miner, []OutPoint{coinbase},
+		[]TxOutput{{Amount: 10 * COIN, To: bob.Public}, {Amount: BLOCK_REWARD - 11*COIN, To: miner.Public}}, COIN)
+	//同一区块中花费前面交易的输出
+	spend := newTestUTXOTransaction(bob, []OutPoint{{pay.Hash(), 0}}, []TxOutput{{Amount: 10 * COIN, To: miner.Public}}, 0)
+	b2 := newLedgerTestBlock(bob)
+	b2.TransactionSlice = &TransactionSlice{pay, spend}
+	if err := l.ConnectBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+	if l.Balance(miner.Public) != BLOCK_REWARD-COIN || l.Balance(bob.Public) != BLOCK_REWARD+COIN {
+		t.Error("转账后余额错误", l.Balance(miner.Public), l.Balance(bob.Public))
+	}
+	if _, ok := l.Get(coinbase); ok {
+		t.Error("已花费的输出未移除")
+	}
+
+	//重复花费
+	if err := l.CheckTransaction(pay, 0); err == nil {
+		t.Error("已花费的输出不应再被花费")
+	}
+
+	l.DisconnectBlock(b2)
+	if l.Balance(miner.Public) != BLOCK_REWARD || l.Balance(bob.Public) != 0 {
+		t.Error("回滚区块后余额错误", l.Balance(miner.Public), l.Balance(bob.Public))
+	}
+	if _, ok := l.Get(OutPoint{pay.Hash(), 0}); ok {
+		t.Error("回滚区块后区块中新建的输出未移除")
+	}
+}
+
+func TestUTXOLedgerRejectsDoubleSpend(t *testing.T) {
+	miner, bob := newTestKeypair(), newTestKeypair()
+	l := NewUTXOLedger()
+	b1 := newLedgerTestBlock(miner)
+	l.ConnectBlock(b1)
+
+	coinbase := CoinbaseOutPoint(b1)
+	t1 := newTestUTXOTransaction(miner, []OutPoint{coinbase}, []TxOutput{{Amount: BLOCK_REWARD, To: bob.Public}}, 0)
+	t2 := newTestUTXOTransaction(miner, []OutPoint{coinbase}, []TxOutput{{Amount: BLOCK_REWARD, To: miner.Public}}, 0)
+	b2 := newLedgerTestBlock(bob)
+	b2.TransactionSlice = &TransactionSlice{t1, t2}
+	if err := l.ConnectBlock(b2); err == nil {
+		t.Error("重复花费的区块不应连接成功")
+	}
+	if l.Balance(miner.Public) != BLOCK_REWARD {
+		t.Error("连接失败后账本不应改变")
+	}
+
+	//不属于发送方的输出
+	t3 := newTestUTXOTransaction(bob, []OutPoint{coinbase}, []TxOutput{{Amount: BLOCK_REWARD, To: bob.Public}}, 0)
+	if err := l.CheckTransaction(t3, 0); err == nil {
+		t.Error("花费他人输出的交易应被拒绝")
+	}
+	//输入输出金额不一致
+	t4 := newTestUTXOTransaction(miner, []OutPoint{coinbase}, []TxOutput{{Amount: BLOCK_REWARD, To: bob.Public}}, 1)
+	if err := l.CheckTransaction(t4, 0); err == nil {
+		t.Error("输出总额加手续费超过输入的交易应被拒绝")
+	}
+
+	mp := NewMempool(l)
+	if err := mp.Add(t1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(t2); err == nil {
+		t.Error("交易池中的双重花费应被拒绝")
+	}
+	if !mp.IsSpent(coinbase) {
+		t.Error("交易池未记录花费的输出")
+	}
+	mp.Remove(t1.Hash())
+	if mp.IsSpent(coinbase) {
+		t.Error("移除交易后未释放花费的输出")
+	}
+}
+
+func TestLedgerModes(t *testing.T) {
+	miner := newTestKeypair()
+	account, err := NewLedger(LEDGER_MODE_ACCOUNT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b1 := newLedgerTestBlock(miner)
+	account.ConnectBlock(b1)
+	tr := newTestUTXOTransaction(miner, []OutPoint{CoinbaseOutPoint(b1)}, []TxOutput{{Amount: BLOCK_REWARD, To: miner.Public}}, 0)
+	if err := account.CheckTransaction(tr, 0); err == nil {
+		t.Error("账户模式应拒绝UTXO交易")
+	}
+
+	utxo, _ := NewLedger(LEDGER_MODE_UTXO)
+	utxo.ConnectBlock(b1)
+	transfer := NewTransferTransaction(miner.Public, []byte("bob"), 1, 0, nil)
+	if err := utxo.CheckTransaction(*transfer, 0); err == nil {
+		t.Error("UTXO模式应拒绝账户转账")
+	}
+	if _, err := NewLedger("unknown"); err == nil {
+		t.Error("未知的账本模式应返回错误")
+	}
+}
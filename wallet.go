@@ -0,0 +1,548 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	WALLET_DEFAULT_KEY = "default" //旧版keys.json迁移后的密钥名称
+	WALLET_KEY_EXT     = ".json"
+
+	WALLET_PENDING_FILENAME = "passwd.pending" //修改密码时待重命名的文件清单，存在时打开钱包先完成重命名
+)
+
+var walletKeyName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+//钱包，在keys目录中保存多个命名的密钥对，每个密钥一个加密文件，所有密钥和助记词使用同一个密码
+//wallet.json记录挖矿使用的密钥(区块的记账者)、默认的交易密钥和派生的密钥路径
+//有助记词时新密钥按HDKeyPath依次派生，用助记词可以恢复整个钱包
+type Wallet struct {
+	Mining    string            `json:"mining"`               //挖矿密钥名称
+	Default   string            `json:"default"`              //默认交易密钥名称
+	NextIndex uint32            `json:"next_index,omitempty"` //下一个派生密钥的索引
+	Paths     map[string]string `json:"paths,omitempty"`      //名称 -> 派生路径，随机生成和导入的密钥没有
+
+	base       string                       //数据目录
+	dir        string                       //密钥目录
+	keys       map[string]*EncryptedKeypair //名称 -> 加密的密钥
+	plain      map[string]*Keypair          //尚未加密的旧版明文密钥，解锁时加密
+	unlocked   map[string]*Keypair          //解锁后的密钥
+	seed       *EncryptedData               //加密的助记词
+	mnemonic   string                       //解锁后的助记词
+	passphrase []byte
+	prompt     PassphraseFunc
+	mutex      sync.Mutex
+}
+
+//导入导出密钥的格式，私钥为明文
+type ExportedKey struct {
+	Name    string `json:"name"`
+	Public  string `json:"public"`
+	Private string `json:"private"`
+}
+
+//打开钱包，读取公钥不需要密码，需要私钥时用prompt获取密码解锁
+//旧版的~/.yibc/keys.json移动到keys目录，名称为default
+func OpenWallet(dir string, prompt PassphraseFunc) (*Wallet, error) {
+	base := getDirectoryWithBaseDir(dir)
+	w := &Wallet{
+		Paths:    map[string]string{},
+		base:     base,
+		dir:      path.Join(base, BLOCKCHAIN_KEYS_DIRECTORY),
+		keys:     map[string]*EncryptedKeypair{},
+		plain:    map[string]*Keypair{},
+		unlocked: map[string]*Keypair{},
+		prompt:   prompt,
+	}
+	if err := os.MkdirAll(w.dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := completePassphraseChange(base); err != nil {
+		return nil, errors.New("完成修改密码失败：" + err.Error())
+	}
+	legacy, moved := keysFile(dir), w.keyFile(WALLET_DEFAULT_KEY)
+	if _, err := os.Stat(legacy); err == nil {
+		if _, err := os.Stat(moved); os.IsNotExist(err) {
+			if err := os.Rename(legacy, moved); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	files, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), WALLET_KEY_EXT)
+		if f.IsDir() || !strings.HasSuffix(f.Name(), WALLET_KEY_EXT) || !walletKeyName.MatchString(name) {
+			continue
+		}
+		kf, err := readKeyFile(path.Join(w.dir, f.Name()))
+		if err != nil {
+			return nil, errors.New("读取密钥" + name + "失败：" + err.Error())
+		}
+		if kf.Encrypted != nil {
+			w.keys[name] = kf.Encrypted
+		} else if kf.Plain != nil {
+			w.plain[name] = kf.Plain
+		}
+	}
+
+	for _, f := range []struct {
+		name string
+		v    interface{}
+	}{{BLOCKCHAIN_WALLET_FILENAME, w}, {BLOCKCHAIN_SEED_FILENAME, &w.seed}} {
+		d, err := os.ReadFile(path.Join(base, f.name))
+		if err == nil {
+			err = json.Unmarshal(d, f.v)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	//没有设置或设置的密钥不存在时使用第一个密钥
+	if names := w.Names(); len(names) > 0 {
+		if !w.Has(w.Default) {
+			w.Default = names[0]
+		}
+		if !w.Has(w.Mining) {
+			w.Mining = w.Default
+		}
+	}
+	return w, nil
+}
+
+func (w *Wallet) keyFile(name string) string {
+	return path.Join(w.dir, name+WALLET_KEY_EXT)
+}
+
+func (w *Wallet) save() error {
+	d, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(w.base, BLOCKCHAIN_WALLET_FILENAME), d, 0600)
+}
+
+//按名称排序的密钥名称
+func (w *Wallet) Names() []string {
+	names := []string{}
+	for name := range w.keys {
+		names = append(names, name)
+	}
+	for name := range w.plain {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//未指定名称时新密钥的名称：default，已存在时依次为key1、key2……
+func (w *Wallet) NextName() string {
+	if !w.Has(WALLET_DEFAULT_KEY) {
+		return WALLET_DEFAULT_KEY
+	}
+	for i := 1; ; i++ {
+		if name := fmt.Sprintf("key%d", i); !w.Has(name) {
+			return name
+		}
+	}
+}
+
+func (w *Wallet) Has(name string) bool {
+	return w.keys[name] != nil || w.plain[name] != nil
+}
+
+//获取公钥，不需要解锁
+func (w *Wallet) Public(name string) []byte {
+	if e := w.keys[name]; e != nil {
+		return []byte(e.Public)
+	}
+	if k := w.plain[name]; k != nil {
+		return k.Public
+	}
+	return nil
+}
+
+//获取密码并解密所有密钥和助记词，明文密钥用该密码加密后保存；钱包为空时设置新密码
+func (w *Wallet) Unlock() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.unlock()
+}
+
+func (w *Wallet) unlock() error {
+	if w.passphrase != nil {
+		return nil
+	}
+	p, err := w.prompt(len(w.keys) == 0 && w.seed == nil)
+	if err != nil {
+		return err
+	}
+	if len(p) == 0 {
+		return errors.New("密码不能为空")
+	}
+	mnemonic := []byte{}
+	if w.seed != nil {
+		if mnemonic, err = w.seed.Decrypt(nil, p); err != nil {
+			return err
+		}
+	}
+	unlocked := map[string]*Keypair{}
+	for name, e := range w.keys {
+		k, err := e.Decrypt(p)
+		if err != nil {
+			return err
+		}
+		unlocked[name] = k
+	}
+	for name, k := range w.plain {
+		if err := w.write(name, k, p); err != nil {
+			return err
+		}
+		unlocked[name] = k
+	}
+	w.plain = map[string]*Keypair{}
+	w.unlocked, w.mnemonic, w.passphrase = unlocked, string(mnemonic), p
+	return nil
+}
+
+//加密并保存密钥
+func (w *Wallet) write(name string, k *Keypair, passphrase []byte) error {
+	e, err := EncryptKeypair(k, passphrase)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyFile(w.keyFile(name), e); err != nil {
+		return err
+	}
+	w.keys[name] = e
+	return nil
+}
+
+//按名称、地址或公钥查找密钥，返回名称，找不到时返回空字符串
+func (w *Wallet) Find(s string) string {
+	if w.Has(s) {
+		return s
+	}
+	for _, name := range w.Names() {
+		public := w.Public(name)
+		if string(public) == s || string(NewAddress(public)) == s {
+			return name
+		}
+	}
+	return ""
+}
+
+//获取解锁后的密钥对
+func (w *Wallet) Keypair(name string) (*Keypair, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.Has(name) {
+		return nil, errors.New("密钥不存在：" + name)
+	}
+	if err := w.unlock(); err != nil {
+		return nil, err
+	}
+	return w.unlocked[name], nil
+}
+
+func (w *Wallet) checkName(name string) error {
+	if !walletKeyName.MatchString(name) {
+		return errors.New("密钥名称只能包含字母、数字、_和-，最长32个字符")
+	}
+	if w.Has(name) {
+		return errors.New("密钥已存在：" + name)
+	}
+	return nil
+}
+
+//添加密钥，第一个密钥同时作为默认交易密钥和挖矿密钥
+func (w *Wallet) Add(name string, k *Keypair) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.checkName(name); err != nil {
+		return err
+	}
+	if err := w.unlock(); err != nil {
+		return err
+	}
+	return w.add(name, k)
+}
+
+func (w *Wallet) add(name string, k *Keypair) error {
+	if err := w.write(name, k, w.passphrase); err != nil {
+		return err
+	}
+	w.unlocked[name] = k
+	if w.Default == "" {
+		w.Default = name
+	}
+	if w.Mining == "" {
+		w.Mining = name
+	}
+	return w.save()
+}
+
+//生成新的密钥对并添加，有助记词时派生下一个P-224密钥，否则或使用其他签名算法时随机生成
+func (w *Wallet) Generate(name, scheme string) (*Keypair, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.checkName(name); err != nil {
+		return nil, err
+	}
+	if err := w.unlock(); err != nil {
+		return nil, err
+	}
+	if w.mnemonic == "" || scheme != SCHEME_P224 {
+		k, err := GenerateKeypair(scheme)
+		if err != nil {
+			return nil, err
+		}
+		return k, w.add(name, k)
+	}
+	seed, err := MnemonicToSeed(w.mnemonic, "")
+	if err != nil {
+		return nil, err
+	}
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		keyPath := HDKeyPath(w.NextIndex)
+		ek, err := master.Derive(keyPath)
+		w.NextIndex++
+		if err == ErrInvalidChild {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		k := ek.Keypair()
+		w.Paths[name] = keyPath
+		return k, w.add(name, k)
+	}
+}
+
+func (w *Wallet) HasMnemonic() bool {
+	return w.seed != nil
+}
+
+//设置助记词，之后生成的密钥由助记词派生
+func (w *Wallet) InitMnemonic(mnemonic string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.seed != nil {
+		return errors.New("钱包已有助记词")
+	}
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	if _, err := MnemonicToSeed(mnemonic, ""); err != nil {
+		return err
+	}
+	if err := w.unlock(); err != nil {
+		return err
+	}
+	e, err := EncryptData([]byte(mnemonic), nil, w.passphrase)
+	if err != nil {
+		return err
+	}
+	if err := writeKeyFile(path.Join(w.base, BLOCKCHAIN_SEED_FILENAME), e); err != nil {
+		return err
+	}
+	w.seed, w.mnemonic, w.NextIndex = e, mnemonic, 0
+	return w.save()
+}
+
+//从助记词恢复钱包，依次派生count个密钥，钱包须为空
+func (w *Wallet) Restore(mnemonic string, count int) error {
+	if len(w.Names()) > 0 || w.seed != nil {
+		return errors.New("只能恢复到空钱包")
+	}
+	if err := w.InitMnemonic(mnemonic); err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		if _, err := w.Generate(w.NextName(), SCHEME_P224); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//获取助记词，用于备份
+func (w *Wallet) Mnemonic() (string, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.seed == nil {
+		return "", errors.New("钱包没有助记词")
+	}
+	if err := w.unlock(); err != nil {
+		return "", err
+	}
+	return w.mnemonic, nil
+}
+
+//为旧版编码的P-224密钥添加规范编码的密钥newName，私钥和派生路径相同，之后可以把旧公钥的余额转到新公钥
+func (w *Wallet) Migrate(name, newName string) (*Keypair, error) {
+	k, err := w.Keypair(name)
+	if err != nil {
+		return nil, err
+	}
+	ck, err := k.Canonical()
+	if err != nil {
+		return nil, err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.checkName(newName); err != nil {
+		return nil, err
+	}
+	if path, ok := w.Paths[name]; ok {
+		w.Paths[newName] = path
+	}
+	return ck, w.add(newName, ck)
+}
+
+//导入密钥，检查私钥与公钥是否匹配
+func (w *Wallet) Import(e ExportedKey) error {
+	k := &Keypair{Public: []byte(e.Public), Private: []byte(e.Private)}
+	hash := SHA256([]byte(e.Name))
+	sig, err := k.Sign(hash)
+	if err != nil || !SignatureVerify(k.Public, sig, hash) {
+		return errors.New("私钥与公钥不匹配")
+	}
+	return w.Add(e.Name, k)
+}
+
+//导出密钥，私钥为明文
+func (w *Wallet) Export(name string) (ExportedKey, error) {
+	k, err := w.Keypair(name)
+	if err != nil {
+		return ExportedKey{}, err
+	}
+	return ExportedKey{Name: name, Public: string(k.Public), Private: string(k.Private)}, nil
+}
+
+//设置挖矿使用的密钥，重新启动节点后生效
+func (w *Wallet) SetMining(name string) error {
+	if !w.Has(name) {
+		return errors.New("密钥不存在：" + name)
+	}
+	w.Mining = name
+	return w.save()
+}
+
+//设置默认的交易密钥
+func (w *Wallet) SetDefault(name string) error {
+	if !w.Has(name) {
+		return errors.New("密钥不存在：" + name)
+	}
+	w.Default = name
+	return w.save()
+}
+
+//修改密码，用原密码解锁后以newPassphrase获取的新密码重新加密所有密钥
+//所有密钥文件和助记词先写入临时文件，写入失败时原文件不变，仍可用原密码解锁；
+//全部写入成功后记录待重命名的文件清单再重命名，重命名中断时再次打开钱包会完成剩余的重命名，之后使用新密码
+func (w *Wallet) ChangePassphrase(newPassphrase PassphraseFunc) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.keys)+len(w.plain) == 0 {
+		return errors.New("钱包中没有密钥")
+	}
+	if err := w.unlock(); err != nil {
+		return err
+	}
+	p, err := newPassphrase(true)
+	if err != nil {
+		return err
+	}
+	if len(p) == 0 {
+		return errors.New("密码不能为空")
+	}
+	keys := map[string]*EncryptedKeypair{}
+	files := map[string]interface{}{}
+	for name, k := range w.unlocked {
+		e, err := EncryptKeypair(k, p)
+		if err != nil {
+			return err
+		}
+		keys[name], files[w.keyFile(name)] = e, e
+	}
+	var seed *EncryptedData
+	if w.seed != nil {
+		if seed, err = EncryptData([]byte(w.mnemonic), nil, p); err != nil {
+			return err
+		}
+		files[path.Join(w.base, BLOCKCHAIN_SEED_FILENAME)] = seed
+	}
+
+	tmps, names := []string{}, []string{}
+	for file, e := range files {
+		tmp, err := writeTempKeyFile(file, e)
+		if err == nil {
+			var name string
+			name, err = filepath.Rel(w.base, file)
+			names = append(names, name)
+		}
+		if err != nil {
+			for _, t := range append(tmps, tmp) {
+				os.Remove(t)
+			}
+			return err
+		}
+		tmps = append(tmps, tmp)
+	}
+	if err := writeKeyFile(path.Join(w.base, WALLET_PENDING_FILENAME), names); err != nil {
+		for _, t := range tmps {
+			os.Remove(t)
+		}
+		return err
+	}
+	if err := completePassphraseChange(w.base); err != nil {
+		return errors.New("密码未完全修改，再次打开钱包时完成，之后使用新密码：" + err.Error())
+	}
+	for name, e := range keys {
+		w.keys[name] = e
+	}
+	if seed != nil {
+		w.seed = seed
+	}
+	w.passphrase = p
+	return nil
+}
+
+//按清单将修改密码时写入的临时文件重命名，全部完成后删除清单；没有清单时不做任何操作
+//清单只在所有临时文件写入成功后才写入，已重命名的文件没有临时文件，跳过
+func completePassphraseChange(base string) error {
+	pending := path.Join(base, WALLET_PENDING_FILENAME)
+	d, err := os.ReadFile(pending)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	if err := json.Unmarshal(d, &names); err != nil {
+		return err
+	}
+	for _, name := range names {
+		file := path.Join(base, name)
+		if err := os.Rename(file+".tmp", file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Remove(pending)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/tv42/base58"
+)
+
+func TestWalletMigration(t *testing.T) {
+	dir := t.TempDir()
+	kp := newTestKeypair()
+	file := keysFile(dir)
+	if err := os.MkdirAll(path.Dir(file), 0777); err != nil {
+		t.Fatal(err)
+	}
+	d, _ := json.Marshal(kp)
+	if err := os.WriteFile(file, d, 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	//旧版明文keys.json移动到钱包中，解锁时加密
+	w, err := OpenWallet(dir, fixedPassphrase("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(w.Names(), []string{WALLET_DEFAULT_KEY}) || w.Default != WALLET_DEFAULT_KEY || w.Mining != WALLET_DEFAULT_KEY {
+		t.Fatal("旧版密钥应迁移为default", w.Names())
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Error("旧版密钥文件应被移动")
+	}
+	if k, err := w.Keypair(WALLET_DEFAULT_KEY); err != nil || !reflect.DeepEqual(k, kp) {
+		t.Fatal("获取迁移的密钥失败", err)
+	}
+	kf, err := readKeyFile(w.keyFile(WALLET_DEFAULT_KEY))
+	if err != nil || kf.Encrypted == nil {
+		t.Fatal("明文密钥应被加密", err)
+	}
+
+	//修改密码后旧密码不能解锁
+	if err := w.ChangePassphrase(fixedPassphrase("second")); err != nil {
+		t.Fatal(err)
+	}
+	w, _ = OpenWallet(dir, fixedPassphrase("first"))
+	if !reflect.DeepEqual(w.Public(WALLET_DEFAULT_KEY), kp.Public) {
+		t.Error("读取公钥不应需要密码")
+	}
+	if err := w.Unlock(); err != ErrWrongPassphrase {
+		t.Error("旧密码不应能解锁", err)
+	}
+	w, _ = OpenWallet(dir, fixedPassphrase("second"))
+	if k, err := w.Keypair(WALLET_DEFAULT_KEY); err != nil || !reflect.DeepEqual(k, kp) {
+		t.Error("新密码解锁失败", err)
+	}
+}
+
+//任何一个文件写入失败时修改密码失败，所有密钥和助记词仍可用原密码解锁
+func TestChangePassphraseFailure(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := OpenWallet(dir, fixedPassphrase("first"))
+	if err := w.InitMnemonic(testMnemonic); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := w.Generate(w.NextName(), SCHEME_P224)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Generate(w.NextName(), SCHEME_P224); err != nil {
+		t.Fatal(err)
+	}
+
+	//临时文件位置被目录占用，该密钥文件无法写入
+	blocked := w.keyFile("key1") + ".tmp"
+	if err := os.Mkdir(blocked, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ChangePassphrase(fixedPassphrase("second")); err == nil {
+		t.Fatal("写入失败时修改密码应失败")
+	}
+	for _, file := range []string{w.keyFile(WALLET_DEFAULT_KEY), path.Join(w.base, BLOCKCHAIN_SEED_FILENAME)} {
+		if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+			t.Error("失败后应删除临时文件", file)
+		}
+	}
+	old, _ := OpenWallet(dir, fixedPassphrase("first"))
+	if k, err := old.Keypair(WALLET_DEFAULT_KEY); err != nil || !reflect.DeepEqual(k, kp) {
+		t.Error("失败后原密码应能解锁所有密钥", err)
+	}
+	if m, err := old.Mnemonic(); err != nil || m != testMnemonic {
+		t.Error("失败后原密码应能解密助记词", err)
+	}
+
+	os.Remove(blocked)
+	if err := w.ChangePassphrase(fixedPassphrase("second")); err != nil {
+		t.Fatal(err)
+	}
+	changed, _ := OpenWallet(dir, fixedPassphrase("second"))
+	if m, err := changed.Mnemonic(); err != nil || m != testMnemonic {
+		t.Error("新密码解密助记词失败", err)
+	}
+	if _, err := changed.Keypair("key1"); err != nil {
+		t.Error("新密码解锁失败", err)
+	}
+}
+
+//重命名中断后再次打开钱包时按清单完成剩余的重命名，所有密钥和助记词都使用新密码
+func TestChangePassphraseInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	w, _ := OpenWallet(dir, fixedPassphrase("first"))
+	if err := w.InitMnemonic(testMnemonic); err != nil {
+		t.Fatal(err)
+	}
+	kp, err := w.Generate(w.NextName(), SCHEME_P224)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Generate(w.NextName(), SCHEME_P224); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{BLOCKCHAIN_SEED_FILENAME}
+	for _, name := range w.Names() {
+		names = append(names, path.Join(BLOCKCHAIN_KEYS_DIRECTORY, name+WALLET_KEY_EXT))
+	}
+	old := map[string][]byte{}
+	for _, name := range names {
+		old[name], _ = os.ReadFile(path.Join(w.base, name))
+	}
+	if err := w.ChangePassphrase(fixedPassphrase("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	//模拟只重命名了第一个文件：其余文件恢复为旧密码加密的内容，新内容留在临时文件中
+	for _, name := range names[1:] {
+		file := path.Join(w.base, name)
+		changed, _ := os.ReadFile(file)
+		if err := os.WriteFile(file+".tmp", changed, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, old[name], 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writeKeyFile(path.Join(w.base, WALLET_PENDING_FILENAME), names); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := OpenWallet(dir, fixedPassphrase("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, err := recovered.Keypair(WALLET_DEFAULT_KEY); err != nil || !reflect.DeepEqual(k, kp) {
+		t.Error("完成重命名后新密码应能解锁所有密钥", err)
+	}
+	if m, err := recovered.Mnemonic(); err != nil || m != testMnemonic {
+		t.Error("完成重命名后新密码应能解密助记词", err)
+	}
+	if _, err := os.Stat(path.Join(w.base, WALLET_PENDING_FILENAME)); !os.IsNotExist(err) {
+		t.Error("完成后应删除清单", err)
+	}
+}
+
+func TestWalletKeys(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWallet(dir, fixedPassphrase("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Names()) != 0 {
+		t.Fatal("新钱包应为空")
+	}
+	spend, err := w.Generate("spend", SCHEME_P224)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Generate("miner", SCHEME_P224); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Generate("spend", SCHEME_P224); err == nil {
+		t.Error("重复的名称应失败")
+	}
+	if _, err := w.Generate("../x", SCHEME_P224); err == nil {
+		t.Error("非法的名称应失败")
+	}
+	if w.Default != "spend" || w.Mining != "spend" {
+		t.Error("第一个密钥应作为默认交易密钥和挖矿密钥", w.Default, w.Mining)
+	}
+	if err := w.SetMining("miner"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetMining("nobody"); err == nil {
+		t.Error("不存在的密钥不能设置为挖矿密钥")
+	}
+
+	//导出后导入到另一个钱包
+	e, err := w.Export("spend")
+	if err != nil || e.Public != string(spend.Public) {
+		t.Fatal("导出密钥失败", err)
+	}
+	other, _ := OpenWallet(t.TempDir(), fixedPassphrase("other"))
+	if err := other.Import(e); err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := other.Keypair("spend"); !reflect.DeepEqual(k, spend) {
+		t.Error("导入的密钥错误")
+	}
+	e.Name, e.Public = "bad", string(newTestKeypair().Public)
+	if err := other.Import(e); err == nil {
+		t.Error("私钥与公钥不匹配时应导入失败")
+	}
+
+	//旧版编码的密钥转换为规范编码，私钥相同
+	d := big.NewInt(7919)
+	public, _ := legacyP224Scheme{}.Public(d.Bytes())
+	legacy := ExportedKey{Name: "legacy", Public: string(encodeBase58(public)), Private: string(base58.EncodeBig(nil, d))}
+	if err := other.Import(legacy); err != nil {
+		t.Fatal(err)
+	}
+	if k, err := other.Migrate("legacy", "legacy2"); err != nil || !reflect.DeepEqual(k, NewKeypairFromPrivate(d)) {
+		t.Error("转换旧版密钥失败", err)
+	}
+	if _, err := other.Migrate("spend", "spend2"); err == nil {
+		t.Error("规范编码的密钥不需要转换")
+	}
+
+	//重新打开后保留设置
+	w, _ = OpenWallet(dir, fixedPassphrase("secret"))
+	if !reflect.DeepEqual(w.Names(), []string{"miner", "spend"}) || w.Default != "spend" || w.Mining != "miner" {
+		t.Error("重新打开后钱包错误", w.Names(), w.Default, w.Mining)
+	}
+}